@@ -0,0 +1,362 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sixfeetup/blobtube/internal/config"
+	"github.com/sixfeetup/blobtube/internal/hls"
+	"github.com/sixfeetup/blobtube/internal/stream"
+	"github.com/sixfeetup/blobtube/internal/transcode"
+)
+
+const (
+	defaultAdminPageSize = 50
+	maxAdminPageSize     = 500
+	// maxExtendSeconds bounds a single TTL extension.
+	maxExtendSeconds = 7 * 24 * 3600
+)
+
+type adminStream struct {
+	ID          string           `json:"id"`
+	State       string           `json:"state"`
+	Live        bool             `json:"live"`
+	Metadata    *stream.Metadata `json:"metadata,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	LastAccess  time.Time        `json:"last_access"`
+	KeepUntil   *time.Time       `json:"keep_until,omitempty"`
+	AgeSeconds  int              `json:"age_seconds"`
+	IdleSeconds int              `json:"idle_seconds"`
+	DiskBytes   int64            `json:"disk_bytes"`
+	// TranscodedSeconds is the length of the smallest tier written so
+	// far; Progress divides it by the effective duration when known.
+	TranscodedSeconds float64     `json:"transcoded_seconds"`
+	Progress          *float64    `json:"progress,omitempty"`
+	Work              stream.Work `json:"work"`
+	ErrorCode         string      `json:"error_code,omitempty"`
+	Error             string      `json:"error,omitempty"`
+}
+
+type adminStreamList struct {
+	Streams []adminStream `json:"streams"`
+	Total   int           `json:"total"`
+	Limit   int           `json:"limit"`
+	Offset  int           `json:"offset"`
+}
+
+type extendRequest struct {
+	Seconds int `json:"seconds"`
+}
+
+type admin struct {
+	cfg       config.Config
+	streams   *stream.Manager
+	resources *stream.Resources
+	// firstTier is the playlist progress is measured on.
+	firstTier string
+}
+
+// NewAdminHandler serves the admin API: stream listing, cancel, purge, TTL
+// extension and the stderr of a stream's processes. It must only be
+// mounted on the admin listener.
+func NewAdminHandler(cfg config.Config, streams *stream.Manager, opts ...HandlerOption) (http.Handler, error) {
+	if cfg.AdminToken == "" {
+		return nil, errors.New("admin token is required")
+	}
+	o := handlerOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.resources == nil {
+		o.resources = stream.NewResources(log.Logger)
+	}
+
+	ladder, err := transcode.VariantLadder(cfg.VideoEncoders)
+	if err != nil {
+		return nil, err
+	}
+	a := &admin{cfg: cfg, streams: streams, resources: o.resources, firstTier: string(ladder[0].Tier)}
+
+	// Admin consoles on other origins send the token, so responses to them
+	// are credentialed; the allowlist must name them exactly.
+	adminCORS, err := cors(corsPolicy{
+		Origins:     cfg.AdminCORSOrigins,
+		Methods:     []string{http.MethodGet, http.MethodPost, http.MethodDelete},
+		Headers:     []string{"Authorization", "Content-Type"},
+		Credentials: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("admin %w", err)
+	}
+
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(middleware.Recoverer)
+	r.Use(RequestLogger)
+	// Preflights carry no token, so CORS answers them first.
+	r.Use(adminCORS)
+	r.Use(requireBearerToken(cfg.AdminToken))
+
+	r.Route("/api/admin/streams", func(r chi.Router) {
+		r.Get("/", a.listStreams)
+		r.Route("/{id}", func(r chi.Router) {
+			r.Get("/", a.getStream)
+			r.Delete("/", a.purgeStream)
+			r.Post("/cancel", a.cancelStream)
+			r.Post("/extend", a.extendStream)
+			r.Get("/stderr", a.streamStderr)
+		})
+	})
+	return r, nil
+}
+
+// requireBearerToken rejects requests without the admin token.
+func requireBearerToken(token string) func(http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := []byte(r.Header.Get("Authorization"))
+			if subtle.ConstantTimeCompare(got, want) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="blobtube-admin"`)
+				http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (a *admin) listStreams(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, err := queryInt(q.Get("limit"), defaultAdminPageSize)
+	if err != nil || limit <= 0 || limit > maxAdminPageSize {
+		http.Error(w, `{"error":"invalid limit"}`, http.StatusBadRequest)
+		return
+	}
+	offset, err := queryInt(q.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		http.Error(w, `{"error":"invalid offset"}`, http.StatusBadRequest)
+		return
+	}
+	states := map[string]bool{}
+	for _, s := range strings.Split(q.Get("state"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			states[s] = true
+		}
+	}
+
+	var matched []stream.Stream
+	for _, s := range a.streams.List() {
+		if len(states) == 0 || states[string(s.State)] {
+			matched = append(matched, s)
+		}
+	}
+
+	resp := adminStreamList{Streams: []adminStream{}, Total: len(matched), Limit: limit, Offset: offset}
+	now := time.Now()
+	for i := offset; i < len(matched) && i < offset+limit; i++ {
+		resp.Streams = append(resp.Streams, a.describe(matched[i], now))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (a *admin) getStream(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.lookup(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, a.describe(s, time.Now()))
+}
+
+// cancelStream stops a stream's transcode and fails it. Its files stay for
+// debugging until a purge removes them: the janitor leaves failed streams
+// alone.
+func (a *admin) cancelStream(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.lookup(w, r)
+	if !ok {
+		return
+	}
+	if s.State.Terminal() {
+		http.Error(w, `{"error":"stream is not running"}`, http.StatusConflict)
+		return
+	}
+	a.stop(s.ID)
+	log.Info().Str("stream_id", s.ID).Msg("stream canceled by admin")
+
+	s, _ = a.streams.Get(s.ID)
+	writeJSON(w, http.StatusOK, a.describe(s, time.Now()))
+}
+
+// purgeStream stops a stream and removes it and its files.
+func (a *admin) purgeStream(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.lookup(w, r)
+	if !ok {
+		return
+	}
+	// Even a completed stream may still be building its storyboard.
+	a.stop(s.ID)
+	// The record goes first: a processStream still winding down checks
+	// for it after each write and removes the directory itself once it's
+	// gone, so files it writes after ours are removed are not left behind.
+	a.streams.Delete(s.ID)
+	a.resources.DropStderrLog(s.ID)
+	if err := os.RemoveAll(filepath.Join(a.cfg.StreamsDir, s.ID)); err != nil {
+		log.Error().Str("stream_id", s.ID).Err(err).Msg("failed to remove stream dir")
+		http.Error(w, `{"error":"failed to remove stream files"}`, http.StatusInternalServerError)
+		return
+	}
+	log.Info().Str("stream_id", s.ID).Msg("stream purged by admin")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// extendStream keeps a stream from timing out for the given number of
+// seconds from now, whether or not anyone is watching.
+func (a *admin) extendStream(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.lookup(w, r)
+	if !ok {
+		return
+	}
+	var req extendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid json"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Seconds <= 0 || req.Seconds > maxExtendSeconds {
+		http.Error(w, `{"error":"seconds out of range"}`, http.StatusBadRequest)
+		return
+	}
+	if s.State.Terminal() {
+		http.Error(w, `{"error":"stream is not running"}`, http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	a.streams.KeepUntil(s.ID, now.Add(time.Duration(req.Seconds)*time.Second))
+	s, _ = a.streams.Get(s.ID)
+	writeJSON(w, http.StatusOK, a.describe(s, now))
+}
+
+// streamStderr downloads the redacted stderr tail of every ffmpeg and yt-dlp
+// process the stream has run, including ones still running.
+func (a *admin) streamStderr(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.lookup(w, r)
+	if !ok {
+		return
+	}
+	l := a.resources.StderrLog(s.ID)
+	if l == nil {
+		http.Error(w, `{"error":"no stderr captured"}`, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-stderr.log"`, s.ID))
+	if _, err := l.WriteTo(w); err != nil {
+		log.Warn().Str("stream_id", s.ID).Err(err).Msg("failed to write stderr log")
+	}
+}
+
+// stop fails the stream, unless it's already terminal, before cancelling
+// its work, so the orchestrator sees a cancelled context on a stream that
+// is already settled.
+func (a *admin) stop(streamID string) {
+	a.streams.Fail(streamID, stream.ErrCodeCanceled, failureMessages[stream.ErrCodeCanceled])
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	a.resources.CleanupStream(ctx, streamID)
+}
+
+func (a *admin) lookup(w http.ResponseWriter, r *http.Request) (stream.Stream, bool) {
+	id := chi.URLParam(r, "id")
+	if !streamIDRe.MatchString(id) {
+		http.Error(w, `{"error":"invalid stream id"}`, http.StatusBadRequest)
+		return stream.Stream{}, false
+	}
+	s, ok := a.streams.Get(id)
+	if !ok {
+		http.Error(w, `{"error":"stream not found"}`, http.StatusNotFound)
+		return stream.Stream{}, false
+	}
+	return s, true
+}
+
+func (a *admin) describe(s stream.Stream, now time.Time) adminStream {
+	dir := filepath.Join(a.cfg.StreamsDir, s.ID)
+	out := adminStream{
+		ID:          s.ID,
+		State:       string(s.State),
+		Live:        s.Live,
+		Metadata:    s.Metadata,
+		CreatedAt:   s.CreatedAt,
+		LastAccess:  s.LastAccess,
+		AgeSeconds:  int(now.Sub(s.CreatedAt).Seconds()),
+		IdleSeconds: int(now.Sub(s.LastAccess).Seconds()),
+		DiskBytes:   dirSize(dir),
+		Work:        a.resources.Tracked(s.ID),
+		ErrorCode:   string(s.ErrorCode),
+		Error:       s.Error,
+	}
+	if !s.KeepUntil.IsZero() {
+		keep := s.KeepUntil
+		out.KeepUntil = &keep
+	}
+
+	if b, err := os.ReadFile(filepath.Join(dir, a.firstTier, "index.m3u8")); err == nil {
+		if d, err := hls.MediaPlaylistDuration(b); err == nil {
+			out.TranscodedSeconds = d.Seconds()
+		}
+	}
+	switch {
+	case s.State == stream.StateCompleted:
+		p := 1.0
+		out.Progress = &p
+	case !s.Live && s.Metadata != nil && s.Metadata.EffectiveDurationSeconds > 0:
+		p := out.TranscodedSeconds / float64(s.Metadata.EffectiveDurationSeconds)
+		if p > 1 {
+			p = 1
+		}
+		out.Progress = &p
+	}
+	return out
+}
+
+// dirSize sums the sizes of the regular files under dir, best effort.
+func dirSize(dir string) int64 {
+	var total int64
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+func queryInt(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,277 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/sixfeetup/blobtube/internal/config"
+	"github.com/sixfeetup/blobtube/internal/stream"
+	"github.com/sixfeetup/blobtube/internal/transcode"
+)
+
+const testAdminToken = "s3cret"
+
+func newTestAdmin(t *testing.T, streams *stream.Manager, resources *stream.Resources) (http.Handler, string) {
+	t.Helper()
+	root := t.TempDir()
+	h, err := NewAdminHandler(config.Config{StreamsDir: root, AdminToken: testAdminToken}, streams, WithResources(resources))
+	if err != nil {
+		t.Fatalf("NewAdminHandler: %v", err)
+	}
+	return h, root
+}
+
+func adminRequest(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestNewAdminHandler_RequiresToken(t *testing.T) {
+	if _, err := NewAdminHandler(config.Config{}, stream.NewManager(time.Minute)); err == nil {
+		t.Fatalf("expected error without an admin token")
+	}
+}
+
+func TestAdmin_RejectsMissingOrWrongToken(t *testing.T) {
+	h, _ := newTestAdmin(t, stream.NewManager(time.Minute), nil)
+	for _, auth := range []string{"", "Bearer wrong", testAdminToken} {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/streams", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("auth %q: expected 401, got %d", auth, rr.Code)
+		}
+	}
+}
+
+func TestAdmin_NotServedOnPublicHandler(t *testing.T) {
+	h, err := NewHandler(config.Config{StaticDir: t.TempDir(), AdminToken: testAdminToken}, stream.NewManager(time.Minute))
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+	rr := adminRequest(h, http.MethodGet, "/api/admin/streams", "")
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 on the public handler, got %d", rr.Code)
+	}
+}
+
+func TestAdmin_ListStreams_FiltersAndPaginates(t *testing.T) {
+	streams := stream.NewManager(time.Minute)
+	base := time.Now().Add(-time.Hour)
+	for i, id := range []string{"a", "b", "c", "d"} {
+		streams.Register(id, base.Add(time.Duration(i)*time.Second))
+	}
+	streams.SetState("c", stream.StateCompleted, "")
+	h, _ := newTestAdmin(t, streams, nil)
+
+	rr := adminRequest(h, http.MethodGet, "/api/admin/streams?state=active&limit=2&offset=1", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp adminStreamList
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Total != 3 || len(resp.Streams) != 2 || resp.Streams[0].ID != "b" || resp.Streams[1].ID != "d" {
+		t.Fatalf("unexpected page: %+v", resp)
+	}
+	if resp.Streams[0].AgeSeconds < 3500 {
+		t.Fatalf("expected age in seconds, got %d", resp.Streams[0].AgeSeconds)
+	}
+
+	if rr := adminRequest(h, http.MethodGet, "/api/admin/streams?limit=0", ""); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad limit, got %d", rr.Code)
+	}
+}
+
+func TestAdmin_GetStream_ReportsDiskAndProgress(t *testing.T) {
+	streams := stream.NewManager(time.Minute)
+	streams.Register("abc", time.Now())
+	streams.SetMetadata("abc", stream.Metadata{Title: "x", DurationSeconds: 16, EffectiveDurationSeconds: 16})
+	h, root := newTestAdmin(t, streams, nil)
+
+	tier := filepath.Join(root, "abc", "64x64")
+	if err := os.MkdirAll(tier, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	pl := "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:4\n#EXTINF:4.000,\nsegment_00000.m4s\n"
+	if err := os.WriteFile(filepath.Join(tier, "index.m3u8"), []byte(pl), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	rr := adminRequest(h, http.MethodGet, "/api/admin/streams/abc", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var resp adminStream
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.DiskBytes != int64(len(pl)) {
+		t.Fatalf("expected %d disk bytes, got %d", len(pl), resp.DiskBytes)
+	}
+	if resp.Progress == nil || *resp.Progress != 0.25 {
+		t.Fatalf("expected progress 0.25, got %v", resp.Progress)
+	}
+}
+
+func TestAdmin_CancelStopsWorkAndFailsStream(t *testing.T) {
+	streams := stream.NewManager(time.Minute)
+	streams.Register("abc", time.Now())
+	resources := stream.NewResources(zerolog.Nop())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resources.RegisterCancel("abc", cancel)
+	h, _ := newTestAdmin(t, streams, resources)
+
+	rr := adminRequest(h, http.MethodPost, "/api/admin/streams/abc/cancel", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if ctx.Err() == nil {
+		t.Fatalf("expected the transcode to be cancelled")
+	}
+	if s, _ := streams.Get("abc"); s.State != stream.StateError || s.ErrorCode != stream.ErrCodeCanceled {
+		t.Fatalf("expected canceled error state, got %q/%q", s.State, s.ErrorCode)
+	}
+
+	if rr := adminRequest(h, http.MethodPost, "/api/admin/streams/abc/cancel", ""); rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a stopped stream, got %d", rr.Code)
+	}
+}
+
+func TestAdmin_CancelReachesStreamStillStarting(t *testing.T) {
+	streams := stream.NewManager(time.Minute)
+	resources := stream.NewResources(zerolog.Nop())
+	life := NewLifecycle()
+	started := make(chan struct{})
+	ytdlp := transcode.NewYtDLP("yt-dlp", zerolog.Nop(), false)
+	ytdlp.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, nil, ctx.Err()
+	}
+	root := t.TempDir()
+	cfg := config.Config{StreamsDir: root, StaticDir: root, AdminToken: testAdminToken}
+	public, err := NewHandler(cfg, streams, WithYtDLP(ytdlp), WithResources(resources), WithLifecycle(life))
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+	admin, err := NewAdminHandler(cfg, streams, WithResources(resources))
+	if err != nil {
+		t.Fatalf("NewAdminHandler: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	public.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/stream/", strings.NewReader(`{"url":"https://www.youtube.com/watch?v=abc"}`)))
+	var created CreateStreamResponse
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil || created.StreamID == "" {
+		t.Fatalf("expected a stream, got %d %v", rr.Code, err)
+	}
+	<-started
+
+	if rr := adminRequest(admin, http.MethodPost, "/api/admin/streams/"+created.StreamID+"/cancel", ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if sum := life.Drain(5 * time.Second); sum.Canceled != 0 {
+		t.Fatalf("expected the extraction to stop on cancel, not on shutdown: %+v", sum)
+	}
+	if s, _ := streams.Get(created.StreamID); s.State != stream.StateError || s.ErrorCode != stream.ErrCodeCanceled {
+		t.Fatalf("expected the cancel to stick, got %q/%q", s.State, s.ErrorCode)
+	}
+	if _, err := os.Stat(filepath.Join(root, created.StreamID)); !os.IsNotExist(err) {
+		t.Fatalf("expected no stream dir for a stream canceled before it started")
+	}
+}
+
+func TestAdmin_PurgeRemovesStreamAndFiles(t *testing.T) {
+	streams := stream.NewManager(time.Minute)
+	streams.Register("abc", time.Now())
+	h, root := newTestAdmin(t, streams, nil)
+	if err := os.MkdirAll(filepath.Join(root, "abc", "64x64"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	rr := adminRequest(h, http.MethodDelete, "/api/admin/streams/abc", "")
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rr.Code)
+	}
+	if _, ok := streams.Get("abc"); ok {
+		t.Fatalf("expected stream to be forgotten")
+	}
+	if _, err := os.Stat(filepath.Join(root, "abc")); !os.IsNotExist(err) {
+		t.Fatalf("expected stream dir to be removed, got %v", err)
+	}
+}
+
+func TestAdmin_StreamStderr_DownloadsRedactedLog(t *testing.T) {
+	streams := stream.NewManager(time.Minute)
+	streams.Register("abc", time.Now())
+	resources := stream.NewResources(zerolog.Nop())
+	h, _ := newTestAdmin(t, streams, resources)
+
+	if rr := adminRequest(h, http.MethodGet, "/api/admin/streams/abc/stderr", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 before anything ran, got %d", rr.Code)
+	}
+
+	l := transcode.NewStderrLog(4096, zerolog.Nop())
+	resources.SetStderrLog("abc", l)
+	ex := transcode.CaptureStderr(transcode.ExecFunc(func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		return nil, []byte("ERROR: unable to read /run/secrets/cookies.txt\n"), nil
+	}), l)
+	ex.Run(context.Background(), transcode.Command{Name: "yt-dlp", Args: []string{"--cookies", "/run/secrets/cookies.txt", "https://www.youtube.com/watch?v=abc"}})
+
+	rr := adminRequest(h, http.MethodGet, "/api/admin/streams/abc/stderr", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("expected text/plain, got %q", ct)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, "=== yt-dlp --cookies [redacted]") || !strings.Contains(body, "unable to read [redacted]") {
+		t.Fatalf("unexpected log %q", body)
+	}
+	if strings.Contains(body, "cookies.txt") {
+		t.Fatalf("expected the cookie path to be redacted, got %q", body)
+	}
+
+	if rr := adminRequest(h, http.MethodDelete, "/api/admin/streams/abc", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rr.Code)
+	}
+	if resources.StderrLog("abc") != nil {
+		t.Fatalf("expected the log to go with the purged stream")
+	}
+}
+
+func TestAdmin_ExtendKeepsStreamAlive(t *testing.T) {
+	streams := stream.NewManager(time.Minute)
+	streams.Register("abc", time.Now())
+	h, _ := newTestAdmin(t, streams, nil)
+
+	rr := adminRequest(h, http.MethodPost, "/api/admin/streams/abc/extend", `{"seconds":3600}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if expired := streams.ExpireInactive(time.Now().Add(30 * time.Minute)); len(expired) != 0 {
+		t.Fatalf("expected extended stream to survive, expired %v", expired)
+	}
+	if rr := adminRequest(h, http.MethodPost, "/api/admin/streams/abc/extend", `{"seconds":0}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
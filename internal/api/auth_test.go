@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/sixfeetup/blobtube/internal/config"
+	"github.com/sixfeetup/blobtube/internal/stream"
+	"github.com/sixfeetup/blobtube/internal/transcode"
+)
+
+func writeKeyFile(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return path
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func newKeyedHandler(t *testing.T, streams *stream.Manager, public bool) http.Handler {
+	t.Helper()
+	keys := writeKeyFile(t, `[
+		{"label":"alice","sha256":"`+sha256Hex("alice-key")+`","max_concurrent":1},
+		{"label":"bob","sha256":"`+sha256Hex("bob-key")+`"}
+	]`)
+	// yt-dlp hangs until its context ends, so created streams hold their
+	// quota slot for the whole test.
+	ytdlp := transcode.NewYtDLP("yt-dlp", zerolog.Nop(), false)
+	ytdlp.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		<-ctx.Done()
+		return nil, nil, errors.New("stopped")
+	}
+	root := t.TempDir()
+	h, err := NewHandler(config.Config{StreamsDir: root, StaticDir: root, APIKeysFile: keys, PublicPlayback: public}, streams, WithYtDLP(ytdlp))
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+	return h
+}
+
+func keyedRequest(h http.Handler, method, path, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCreateStream_RequiresAPIKeyAndEnforcesQuota(t *testing.T) {
+	h := newKeyedHandler(t, stream.NewManager(time.Minute), true)
+	body := `{"url":"https://www.youtube.com/watch?v=abc"}`
+
+	if rr := keyedRequest(h, http.MethodPost, "/api/stream/", "", body); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a key, got %d", rr.Code)
+	}
+	if rr := keyedRequest(h, http.MethodPost, "/api/stream/", "alice-key", body); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+	// alice's single slot is held until the first stream stops.
+	if rr := keyedRequest(h, http.MethodPost, "/api/stream/", "alice-key", body); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rr.Code)
+	}
+	if rr := keyedRequest(h, http.MethodPost, "/api/stream/", "bob-key", body); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected bob's quota to be separate, got %d", rr.Code)
+	}
+}
+
+func TestStreamRoutes_ScopedToOwningKey(t *testing.T) {
+	streams := stream.NewManager(time.Minute)
+	streams.Register("abc", time.Now())
+	streams.SetOwner("abc", "alice")
+	h := newKeyedHandler(t, streams, false)
+
+	for _, tc := range []struct {
+		path, key string
+		want      int
+	}{
+		{"/api/stream/abc/status", "alice-key", http.StatusOK},
+		{"/api/stream/abc/status", "bob-key", http.StatusNotFound},
+		{"/api/stream/abc/status", "", http.StatusUnauthorized},
+		{"/api/stream/abc/master.m3u8", "", http.StatusUnauthorized},
+		{"/api/stream/abc/master.m3u8", "bob-key", http.StatusNotFound},
+	} {
+		if rr := keyedRequest(h, http.MethodGet, tc.path, tc.key, ""); rr.Code != tc.want {
+			t.Fatalf("%s with %q: expected %d, got %d", tc.path, tc.key, tc.want, rr.Code)
+		}
+	}
+}
+
+func TestStreamRoutes_PublicPlaybackSkipsKeyForHLS(t *testing.T) {
+	streams := stream.NewManager(time.Minute)
+	streams.Register("abc", time.Now())
+	streams.SetOwner("abc", "alice")
+	h := newKeyedHandler(t, streams, true)
+
+	// No playlist on disk yet: anything but 401/404-by-scope proves the
+	// request reached the handler.
+	if rr := keyedRequest(h, http.MethodGet, "/api/stream/abc/64x64/segment_00000.m4s", "", ""); rr.Code == http.StatusUnauthorized {
+		t.Fatalf("expected public playback without a key, got %d", rr.Code)
+	}
+	if rr := keyedRequest(h, http.MethodGet, "/api/stream/abc/status", "", ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status to still need a key, got %d", rr.Code)
+	}
+}
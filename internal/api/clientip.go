@@ -0,0 +1,85 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parseTrustedProxies parses CIDRs or bare addresses.
+func parseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, e := range entries {
+		if p, err := netip.ParsePrefix(e); err == nil {
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(e)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: want a CIDR or an IP", e)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+// realIP sets r.RemoteAddr to the client's IP. Forwarding headers are
+// only believed from trusted proxies: X-Forwarded-For is read right to
+// left, and the first hop that isn't a trusted proxy is the client, so a
+// client can't spoof its address by sending the header itself.
+func realIP(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	isTrusted := func(addr netip.Addr) bool {
+		addr = addr.Unmap()
+		for _, p := range trusted {
+			if p.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+			client, err := netip.ParseAddr(host)
+			if err == nil && isTrusted(client) {
+				client = forwardedClient(r, client, isTrusted)
+			}
+			if client.IsValid() {
+				r.RemoteAddr = client.Unmap().String()
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedClient walks the forwarding headers of a request from a
+// trusted proxy. If every hop is trusted, the outermost one is the client.
+func forwardedClient(r *http.Request, peer netip.Addr, isTrusted func(netip.Addr) bool) netip.Addr {
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	if len(hops) == 0 {
+		if v := r.Header.Get("X-Real-IP"); v != "" {
+			hops = []string{v}
+		}
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// Whatever is left of a malformed hop can't be trusted.
+			break
+		}
+		client = addr
+		if !isTrusted(addr) {
+			break
+		}
+	}
+	return client
+}
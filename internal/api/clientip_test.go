@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP_TrustsForwardingOnlyFromProxies(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("parseTrustedProxies: %v", err)
+	}
+	var got string
+	h := realIP(trusted)(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got = r.RemoteAddr
+	}))
+
+	for _, tc := range []struct {
+		name, remote, xff, want string
+	}{
+		{"direct client spoofing", "203.0.113.7:1234", "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy", "10.0.0.5:1234", "198.51.100.1", "198.51.100.1"},
+		{"chained proxies", "10.0.0.5:1234", "198.51.100.1, 192.0.2.1", "198.51.100.1"},
+		{"spoofed hop before the proxy", "10.0.0.5:1234", "1.2.3.4, 198.51.100.1", "198.51.100.1"},
+		{"all hops trusted", "10.0.0.5:1234", "10.0.0.9", "10.0.0.9"},
+		{"proxy without header", "10.0.0.5:1234", "", "10.0.0.5"},
+		{"malformed hop", "10.0.0.5:1234", "garbage", "10.0.0.5"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			req.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if got != tc.want {
+			t.Fatalf("%s: expected %s, got %s", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestParseTrustedProxies_RejectsGarbage(t *testing.T) {
+	if _, err := parseTrustedProxies([]string{"not-a-cidr"}); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
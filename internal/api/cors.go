@@ -0,0 +1,94 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// corsMaxAge is how long browsers may cache a preflight, in seconds.
+const corsMaxAge = 600
+
+// corsPolicy describes which cross-origin requests a route group accepts.
+// Requests from other origins get no CORS headers, so browsers block them;
+// same-origin requests and non-browser clients are unaffected.
+type corsPolicy struct {
+	// Origins are exact origins such as "https://example.com", or "*" for
+	// any. Empty allows none.
+	Origins []string
+	Methods []string
+	Headers []string
+	// Expose lists response headers scripts may read.
+	Expose []string
+	// Credentials lets browsers send cookies and auth with requests. It
+	// can't be combined with "*".
+	Credentials bool
+}
+
+// cors returns middleware enforcing p. It answers preflights itself, so
+// the group needs an OPTIONS route for them to reach it.
+func cors(p corsPolicy) (func(http.Handler) http.Handler, error) {
+	anyOrigin := slices.Contains(p.Origins, "*")
+	if anyOrigin && p.Credentials {
+		return nil, errors.New("cors: credentials can't be allowed for any origin")
+	}
+	methods := strings.Join(p.Methods, ", ")
+	headers := strings.Join(p.Headers, ", ")
+	expose := strings.Join(p.Expose, ", ")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if !anyOrigin {
+				w.Header().Add("Vary", "Origin")
+			}
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !anyOrigin && !slices.Contains(p.Origins, origin) {
+				if preflight {
+					http.Error(w, `{"error":"origin not allowed"}`, http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if anyOrigin {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if p.Credentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			if !preflight {
+				if expose != "" {
+					w.Header().Set("Access-Control-Expose-Headers", expose)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !slices.Contains(p.Methods, r.Header.Get("Access-Control-Request-Method")) {
+				http.Error(w, `{"error":"method not allowed"}`, http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			if headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}, nil
+}
+
+// noContent answers OPTIONS requests that aren't preflights.
+func noContent(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
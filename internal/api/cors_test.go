@@ -0,0 +1,94 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sixfeetup/blobtube/internal/config"
+	"github.com/sixfeetup/blobtube/internal/stream"
+)
+
+func corsRequest(h http.Handler, method, path, origin, preflightMethod string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if preflightMethod != "" {
+		req.Header.Set("Access-Control-Request-Method", preflightMethod)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCORS_AllowlistsOriginsPerRouteGroup(t *testing.T) {
+	streams := stream.NewManager(time.Minute)
+	streams.Register("abc", time.Now())
+	root := t.TempDir()
+	cfg := config.Config{
+		StreamsDir:  root,
+		StaticDir:   root,
+		CORSOrigins: []string{"https://embed.example"},
+		CORSHeaders: []string{"Content-Type"},
+	}
+	h, err := NewHandler(cfg, streams)
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+
+	rr := corsRequest(h, http.MethodGet, "/api/stream/abc/status", "https://embed.example", "")
+	if rr.Code != http.StatusOK || rr.Header().Get("Access-Control-Allow-Origin") != "https://embed.example" {
+		t.Fatalf("expected the allowlisted origin to be allowed, got %d %v", rr.Code, rr.Header())
+	}
+	rr = corsRequest(h, http.MethodGet, "/api/stream/abc/status", "https://evil.example", "")
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("expected no CORS headers for another origin, got %q", got)
+	}
+
+	rr = corsRequest(h, http.MethodOptions, "/api/stream/", "https://embed.example", http.MethodPost)
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Access-Control-Allow-Methods") != "POST" {
+		t.Fatalf("unexpected create preflight: %d %v", rr.Code, rr.Header())
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Headers"); got != "Content-Type" {
+		t.Fatalf("expected only configured headers, got %q", got)
+	}
+	rr = corsRequest(h, http.MethodOptions, "/api/stream/abc/64x64/index.m3u8", "https://embed.example", http.MethodDelete)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected a disallowed method to fail preflight, got %d", rr.Code)
+	}
+	rr = corsRequest(h, http.MethodOptions, "/api/stream/abc/64x64/index.m3u8", "https://evil.example", http.MethodGet)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected another origin to fail preflight, got %d", rr.Code)
+	}
+	rr = corsRequest(h, http.MethodOptions, "/api/stream/abc/64x64/index.m3u8", "https://embed.example", http.MethodGet)
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Fatalf("unexpected playback preflight: %d %v", rr.Code, rr.Header())
+	}
+}
+
+func TestCORS_AdminIsCredentialed(t *testing.T) {
+	cfg := config.Config{
+		StreamsDir:       t.TempDir(),
+		AdminToken:       testAdminToken,
+		AdminCORSOrigins: []string{"https://console.example"},
+	}
+	h, err := NewAdminHandler(cfg, stream.NewManager(time.Minute))
+	if err != nil {
+		t.Fatalf("NewAdminHandler: %v", err)
+	}
+
+	// The preflight carries no token.
+	rr := corsRequest(h, http.MethodOptions, "/api/admin/streams/", "https://console.example", http.MethodGet)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected preflight to pass without a token, got %d", rr.Code)
+	}
+	if rr.Header().Get("Access-Control-Allow-Origin") != "https://console.example" || rr.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Fatalf("expected credentialed CORS headers, got %v", rr.Header())
+	}
+
+	if _, err := NewAdminHandler(config.Config{AdminToken: testAdminToken, AdminCORSOrigins: []string{"*"}}, stream.NewManager(time.Minute)); err == nil {
+		t.Fatalf("expected credentialed CORS for any origin to be rejected")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package api
+
+import "errors"
+
+func diskFree(string) (uint64, error) {
+	return 0, errors.New("free space check not supported on this platform")
+}
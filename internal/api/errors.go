@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"syscall"
+
+	"github.com/sixfeetup/blobtube/internal/stream"
+	"github.com/sixfeetup/blobtube/internal/transcode"
+)
+
+// failureMessages are the client-facing messages for each error code. Raw
+// tool output (yt-dlp and ffmpeg stderr) only goes to logs.
+var failureMessages = map[stream.ErrorCode]string{
+	stream.ErrCodeUnsupportedURL:    "this URL is not supported",
+	stream.ErrCodeUnavailable:       "this video is unavailable or private",
+	stream.ErrCodeRegionLocked:      "this video is not available in the server's region",
+	stream.ErrCodeAgeRestricted:     "this video is age restricted",
+	stream.ErrCodeLiveNotSupported:  "live streams and premieres are not supported",
+	stream.ErrCodeSourceTooLong:     "this video is longer than the server allows",
+	stream.ErrCodeSourceTooLarge:    "this video is larger than the server allows",
+	stream.ErrCodeUnknownDuration:   "this video has no known duration",
+	stream.ErrCodePlaylist:          "playlists are not supported, link a single video",
+	stream.ErrCodeSourceRateLimited: "the video host is rate limiting this server, try again later",
+	stream.ErrCodeExtractionFailed:  "could not read the video",
+	stream.ErrCodeEncoderFailed:     "transcoding failed",
+	stream.ErrCodeDiskFull:          "the server is out of disk space",
+	stream.ErrCodeCanceled:          "the stream was stopped by an operator",
+	stream.ErrCodeInternal:          "internal error",
+}
+
+// failureCode classifies a processing error. fallback is used when the
+// error matches no known cause, since the step that failed says more than
+// "internal".
+func failureCode(err error, fallback stream.ErrorCode) stream.ErrorCode {
+	switch {
+	case errors.Is(err, transcode.ErrUnsupportedURL):
+		return stream.ErrCodeUnsupportedURL
+	case errors.Is(err, transcode.ErrAgeRestricted):
+		return stream.ErrCodeAgeRestricted
+	case errors.Is(err, transcode.ErrLiveNotSupported):
+		return stream.ErrCodeLiveNotSupported
+	case errors.Is(err, transcode.ErrSourceTooLong):
+		return stream.ErrCodeSourceTooLong
+	case errors.Is(err, transcode.ErrSourceTooLarge):
+		return stream.ErrCodeSourceTooLarge
+	case errors.Is(err, transcode.ErrUnknownDuration):
+		return stream.ErrCodeUnknownDuration
+	case errors.Is(err, transcode.ErrPlaylistNotSupported):
+		return stream.ErrCodePlaylist
+	case errors.Is(err, transcode.ErrSourceRateLimited):
+		return stream.ErrCodeSourceRateLimited
+	case errors.Is(err, transcode.ErrVideoUnavailable):
+		return stream.ErrCodeUnavailable
+	case errors.Is(err, transcode.ErrRegionLocked):
+		return stream.ErrCodeRegionLocked
+	case errors.Is(err, transcode.ErrDiskFull), errors.Is(err, syscall.ENOSPC):
+		return stream.ErrCodeDiskFull
+	case errors.Is(err, transcode.ErrEncoderFailed):
+		return stream.ErrCodeEncoderFailed
+	case errors.Is(err, context.DeadlineExceeded):
+		return stream.ErrCodeExtractionFailed
+	default:
+		return fallback
+	}
+}
+
+// failStream records a classified failure on the stream.
+func (orch *StreamOrchestrator) failStream(streamID string, err error, fallback stream.ErrorCode) {
+	code := failureCode(err, fallback)
+	orch.streams.Fail(streamID, code, failureMessages[code])
+}
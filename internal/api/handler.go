@@ -8,44 +8,248 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rs/zerolog/log"
 
+	"github.com/sixfeetup/blobtube/internal/auth"
 	"github.com/sixfeetup/blobtube/internal/config"
+	"github.com/sixfeetup/blobtube/internal/outputcache"
+	"github.com/sixfeetup/blobtube/internal/ratelimit"
 	"github.com/sixfeetup/blobtube/internal/stream"
 	"github.com/sixfeetup/blobtube/internal/transcode"
 )
 
-func NewHandler(cfg config.Config, streams *stream.Manager) (http.Handler, error) {
+type handlerOptions struct {
+	resources *stream.Resources
+	ytdlp     *transcode.YtDLP
+	lifecycle *Lifecycle
+}
+
+type HandlerOption func(*handlerOptions)
+
+// WithResources shares the server's process registry with the handler, so
+// the janitor and shutdown can stop the transcodes the handler starts.
+func WithResources(r *stream.Resources) HandlerOption {
+	return func(o *handlerOptions) {
+		o.resources = r
+	}
+}
+
+// WithYtDLP shares the server's yt-dlp runner, whose version and updates
+// the server manages.
+func WithYtDLP(y *transcode.YtDLP) HandlerOption {
+	return func(o *handlerOptions) {
+		o.ytdlp = y
+	}
+}
+
+// rateLimits are the per-client limits of each route group. Playlists,
+// segments and previews share one, as a player fetches them together.
+type rateLimits struct {
+	create, status, segments *ratelimit.Limiter
+}
+
+// WithLifecycle lets the server drain the stream work the handler starts.
+func WithLifecycle(l *Lifecycle) HandlerOption {
+	return func(o *handlerOptions) {
+		o.lifecycle = l
+	}
+}
+
+// ownerOnly scopes a stream's routes to the API key that created it. Other
+// keys get a 404, so stream IDs can't be probed. With public set, anyone
+// may read. A nil keyring disables the check.
+func ownerOnly(streams *stream.Manager, keys *auth.Keyring, public bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if keys == nil || public {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := auth.FromContext(r.Context())
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="blobtube"`)
+				http.Error(w, `{"error":"api key required"}`, http.StatusUnauthorized)
+				return
+			}
+			s, found := getStream(streams, chi.URLParam(r, "id"))
+			if !found || s.Owner != key.Label {
+				http.NotFound(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// NewYtDLP builds the yt-dlp runner described by cfg.
+func NewYtDLP(cfg config.Config) *transcode.YtDLP {
+	ytdlp := transcode.NewYtDLP(cfg.YtDLPPath, log.Logger, cfg.DevMode)
+	ytdlp.Options = transcode.YtDLPOptions{
+		CookiesFile:      cfg.YtDLPCookiesFile,
+		Proxy:            cfg.YtDLPProxy,
+		ProxyAllowlist:   cfg.YtDLPProxyAllowlist,
+		GeoBypassCountry: cfg.YtDLPGeoBypassCountry,
+		ExtractorArgs:    cfg.YtDLPExtractorArgs,
+	}
+	if cfg.YtDLPCacheSize > 0 {
+		ytdlp.EnableCache(transcode.MetadataCacheConfig{
+			Size:        cfg.YtDLPCacheSize,
+			TTL:         cfg.YtDLPCacheTTL,
+			NegativeTTL: cfg.YtDLPCacheNegativeTTL,
+		})
+	}
+	return ytdlp
+}
+
+func NewHandler(cfg config.Config, streams *stream.Manager, opts ...HandlerOption) (http.Handler, error) {
+	o := handlerOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.resources == nil {
+		o.resources = stream.NewResources(log.Logger)
+	}
+	if o.lifecycle == nil {
+		o.lifecycle = NewLifecycle()
+	}
+
+	trusted, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	limits := rateLimits{
+		create:   ratelimit.New(cfg.CreateRateLimit.PerMinute, cfg.CreateRateLimit.Burst),
+		status:   ratelimit.New(cfg.StatusRateLimit.PerMinute, cfg.StatusRateLimit.Burst),
+		segments: ratelimit.New(cfg.SegmentsRateLimit.PerMinute, cfg.SegmentsRateLimit.Burst),
+	}
+
+	corsCreate, err := cors(corsPolicy{
+		Origins: cfg.CORSOrigins,
+		Methods: []string{http.MethodPost},
+		Headers: cfg.CORSHeaders,
+		Expose:  []string{"Retry-After"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	corsRead, err := cors(corsPolicy{
+		Origins: cfg.CORSOrigins,
+		Methods: []string{http.MethodGet, http.MethodHead},
+		Headers: cfg.CORSHeaders,
+		Expose:  []string{"Retry-After"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	corsPlayback, err := cors(corsPolicy{
+		Origins: cfg.CORSOrigins,
+		Methods: []string{http.MethodGet, http.MethodHead},
+		Headers: cfg.CORSHeaders,
+		Expose:  []string{"Content-Length", "Content-Range", "Retry-After"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(realIP(trusted))
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.Timeout(30 * time.Second))
-	r.Use(RequestLogger)
+	r.Use(requestLogger(uint32(max(cfg.LogSampleSegments, 1))))
 
-	r.Get("/health", healthHandler)
+	ladder, err := transcode.VariantLadder(cfg.VideoEncoders)
+	if err != nil {
+		return nil, err
+	}
+	ladder, err = transcode.WithRateControl(ladder, transcode.RateControlMode(cfg.RateControl))
+	if err != nil {
+		return nil, err
+	}
+	qualities := qualitySet(ladder)
 
 	// Initialize transcoding components
-	ytdlp := transcode.NewYtDLP(cfg.YtDLPPath, log.Logger, cfg.DevMode)
+	ytdlp := o.ytdlp
+	if ytdlp == nil {
+		ytdlp = NewYtDLP(cfg)
+	}
 	ffmpeg := transcode.NewFFmpeg("ffmpeg", log.Logger)
-	resources := stream.NewResources(log.Logger)
+	ffmpeg.MaxDurationSeconds = cfg.MaxStreamDuration
+	ffmpeg.LiveWindowSegments = cfg.LiveWindowSegments
+
+	var cache *outputcache.Cache
+	if cfg.OutputCacheDir != "" {
+		cache, err = outputcache.New(cfg.OutputCacheDir, cfg.OutputCacheMaxBytes, log.Logger)
+		if err != nil {
+			return nil, err
+		}
+		go cache.RunScrub(o.lifecycle.Context(), outputcache.ScrubInterval)
+	}
+
+	var keys *auth.Keyring
+	if cfg.APIKeysFile != "" {
+		keys, err = auth.LoadKeyring(cfg.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pb, err := newPlayback(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	orch := &StreamOrchestrator{
 		cfg:      cfg,
 		streams:  streams,
 		ytdlp:    ytdlp,
 		ffmpeg:   ffmpeg,
-		resource: resources,
+		resource: o.resources,
+		ladder:   ladder,
+		cache:    cache,
+		keys:     keys,
+		life:     o.lifecycle,
+		policy: transcode.Policy{
+			MaxStreamSeconds:     ffmpeg.MaxDurationSeconds,
+			MaxSourceSeconds:     cfg.MaxSourceDuration,
+			MaxSourceBytes:       cfg.MaxSourceBytes,
+			AllowUnknownDuration: cfg.AllowUnknownDuration,
+			AllowAgeRestricted:   cfg.AllowAgeRestricted,
+			AllowLive:            cfg.LiveEnabled,
+		},
 	}
 
+	ready := newReadiness(cfg, ffmpeg, ytdlp, ladder, o.lifecycle)
+	r.Get("/health", serveHealth(ytdlp, limits))
+	r.Get("/health/live", serveLive)
+	r.Get("/health/ready", ready.serve)
+
+	// CORS runs first in each group, so preflights skip the rate limits
+	// and rejections still carry the headers browsers need to read them.
 	r.Route("/api/stream", func(r chi.Router) {
-		r.Options("/*", corsPreflight)
-		r.Post("/", serveCreateStream(orch))
+		r.Group(func(r chi.Router) {
+			r.Use(corsCreate, ratelimit.Middleware(limits.create), auth.Middleware(keys))
+			r.Options("/", noContent)
+			r.With(auth.Require(keys)).Post("/", serveCreateStream(orch))
+		})
 
 		r.Route("/{id}", func(r chi.Router) {
-			r.Get("/status", serveStreamStatus(streams))
-			r.Get("/master.m3u8", serveMasterPlaylist(cfg, streams))
-			r.Get("/{quality}/index.m3u8", serveMediaPlaylist(cfg, streams))
-			r.Get("/{quality}/{segment}", serveSegment(cfg, streams))
+			r.Group(func(r chi.Router) {
+				r.Use(corsRead, ratelimit.Middleware(limits.status), auth.Middleware(keys), ownerOnly(streams, keys, false))
+				r.Options("/", noContent)
+				r.Options("/status", noContent)
+				r.Get("/", serveStream(streams, pb))
+				r.Get("/status", serveStreamStatus(streams, pb))
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(corsPlayback, ratelimit.Middleware(limits.segments), auth.Middleware(keys), playbackAccess(streams, keys, pb, cfg.PublicPlayback), knownStream(streams))
+				r.Options("/*", noContent)
+				r.Get("/master.m3u8", serveMasterPlaylist(cfg, streams, ladder, pb))
+				r.Get("/{quality}/index.m3u8", serveMediaPlaylist(cfg, streams, qualities, pb))
+				r.Get("/{quality}/{segment}", serveSegment(cfg, streams, qualities))
+				r.Get("/subs/{lang}/{file}", serveSubtitle(cfg, streams, pb))
+				r.Get("/thumbnail.jpg", serveThumbnail(cfg, streams))
+				r.Get("/storyboard/{file}", serveStoryboard(cfg, streams, pb))
+			})
 		})
 	})
 
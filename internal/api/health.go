@@ -1,12 +1,243 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sixfeetup/blobtube/internal/config"
+	"github.com/sixfeetup/blobtube/internal/lru"
+	"github.com/sixfeetup/blobtube/internal/ratelimit"
+	"github.com/sixfeetup/blobtube/internal/transcode"
 )
 
-func healthHandler(w http.ResponseWriter, _ *http.Request) {
+type healthResponse struct {
+	Status string      `json:"status"`
+	YtDLP  ytdlpHealth `json:"ytdlp"`
+	// YtDLPCache is omitted when the metadata cache is off.
+	YtDLPCache *lru.Stats `json:"ytdlp_cache,omitempty"`
+	// RateLimits has an entry per enabled limit.
+	RateLimits map[string]ratelimit.Stats `json:"rate_limits,omitempty"`
+}
+
+type ytdlpHealth struct {
+	Version   string                    `json:"version,omitempty"`
+	Extractor transcode.ExtractorHealth `json:"extractor"`
+}
+
+func serveHealth(ytdlp *transcode.YtDLP, limits rateLimits) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		// A broken extractor doesn't make the server unhealthy: playback of
+		// running streams still works, so it's reported, not failed on.
+		resp := healthResponse{
+			Status: "ok",
+			YtDLP:  ytdlpHealth{Version: ytdlp.Version(), Extractor: ytdlp.Health()},
+		}
+		if stats, ok := ytdlp.CacheStats(); ok {
+			resp.YtDLPCache = &stats
+		}
+		for name, l := range map[string]*ratelimit.Limiter{"create": limits.create, "status": limits.status, "segments": limits.segments} {
+			if l == nil {
+				continue
+			}
+			if resp.RateLimits == nil {
+				resp.RateLimits = map[string]ratelimit.Stats{}
+			}
+			resp.RateLimits[name] = l.Stats()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// serveLive answers liveness probes: the process is up and serving. It
+// checks nothing else, so a sick dependency never gets the process
+// restarted.
+func serveLive(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	_, _ = w.Write([]byte(`{"status":"ok"}` + "\n"))
+}
+
+const (
+	// readyCacheTTL spaces out the checks, which start processes, however
+	// often probes come.
+	readyCacheTTL = 5 * time.Second
+	readyTimeout  = 5 * time.Second
+)
+
+type readyResponse struct {
+	Status string                `json:"status"`
+	Checks map[string]readyCheck `json:"checks"`
+}
+
+type readyCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// readiness decides whether the instance can take new streams: ffmpeg has
+// the ladder's encoders, yt-dlp runs, StreamsDir is writable with room to
+// spare, and transcodes aren't saturated or draining.
+type readiness struct {
+	cfg    config.Config
+	ffmpeg *transcode.FFmpeg
+	ytdlp  *transcode.YtDLP
+	libs   []string
+	life   *Lifecycle
+
+	mu         sync.Mutex
+	last       readyResponse
+	checkedAt  time.Time
+	refreshing chan struct{}
+	now        func() time.Time
+}
+
+func newReadiness(cfg config.Config, ffmpeg *transcode.FFmpeg, ytdlp *transcode.YtDLP, ladder []transcode.VariantConfig, life *Lifecycle) *readiness {
+	return &readiness{
+		cfg:    cfg,
+		ffmpeg: ffmpeg,
+		ytdlp:  ytdlp,
+		libs:   transcode.LadderLibraries(ladder),
+		life:   life,
+		now:    time.Now,
+	}
+}
+
+func (rd *readiness) serve(w http.ResponseWriter, r *http.Request) {
+	resp := rd.check(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Status != "ready" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// check returns the cached result while it's fresh, and otherwise waits for
+// a refresh. Concurrent callers share one refresh, which runs without the
+// lock held and detached from any request, so a client hanging up can't
+// cache a failure.
+func (rd *readiness) check(ctx context.Context) readyResponse {
+	rd.mu.Lock()
+	if !rd.checkedAt.IsZero() && rd.now().Sub(rd.checkedAt) < readyCacheTTL {
+		resp := rd.last
+		rd.mu.Unlock()
+		return resp
+	}
+	done := rd.refreshing
+	if done == nil {
+		done = make(chan struct{})
+		rd.refreshing = done
+		go rd.refresh(done)
+	}
+	rd.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return readyResponse{Status: "not_ready", Checks: map[string]readyCheck{}}
+	}
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	return rd.last
+}
+
+func (rd *readiness) refresh(done chan struct{}) {
+	defer close(done)
+	ctx, cancel := context.WithTimeout(context.Background(), readyTimeout)
+	defer cancel()
+	checks := map[string]readyCheck{
+		"ffmpeg":      rd.checkFFmpeg(ctx),
+		"ytdlp":       rd.checkYtDLP(ctx),
+		"streams_dir": rd.checkStreamsDir(),
+		"queue":       rd.checkQueue(),
+	}
+	resp := readyResponse{Status: "ready", Checks: checks}
+	for _, c := range checks {
+		if !c.OK {
+			resp.Status = "not_ready"
+		}
+	}
+
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	rd.last, rd.checkedAt, rd.refreshing = resp, rd.now(), nil
+}
+
+func (rd *readiness) checkFFmpeg(ctx context.Context) readyCheck {
+	have, err := rd.ffmpeg.Encoders(ctx)
+	if err != nil {
+		return readyCheck{Detail: err.Error()}
+	}
+	var missing []string
+	for _, lib := range rd.libs {
+		if !have[lib] {
+			missing = append(missing, lib)
+		}
+	}
+	if len(missing) > 0 {
+		return readyCheck{Detail: "missing encoders: " + strings.Join(missing, ", ")}
+	}
+	return readyCheck{OK: true, Detail: strings.Join(rd.libs, ", ")}
+}
+
+func (rd *readiness) checkYtDLP(ctx context.Context) readyCheck {
+	v, err := rd.ytdlp.RefreshVersion(ctx)
+	if err != nil {
+		return readyCheck{Detail: err.Error()}
+	}
+	if v == "" {
+		return readyCheck{Detail: "version unknown"}
+	}
+	return readyCheck{OK: true, Detail: v}
+}
+
+func (rd *readiness) checkStreamsDir() readyCheck {
+	dir := rd.cfg.StreamsDir
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return readyCheck{Detail: err.Error()}
+	}
+	if !fi.IsDir() {
+		return readyCheck{Detail: dir + " is not a directory"}
+	}
+	f, err := os.CreateTemp(dir, ".ready-*")
+	if err != nil {
+		return readyCheck{Detail: "not writable: " + err.Error()}
+	}
+	_, werr := f.Write([]byte("ok"))
+	cerr := f.Close()
+	_ = os.Remove(f.Name())
+	if werr != nil || cerr != nil {
+		return readyCheck{Detail: fmt.Sprintf("not writable: %v", errors.Join(werr, cerr))}
+	}
+
+	free, err := diskFree(dir)
+	if err != nil {
+		return readyCheck{Detail: err.Error()}
+	}
+	detail := fmt.Sprintf("%d bytes free", free)
+	if rd.cfg.MinFreeBytes > 0 && free < uint64(rd.cfg.MinFreeBytes) {
+		return readyCheck{Detail: fmt.Sprintf("%s, below the %d minimum", detail, rd.cfg.MinFreeBytes)}
+	}
+	return readyCheck{OK: true, Detail: detail}
+}
+
+func (rd *readiness) checkQueue() readyCheck {
+	if rd.life.Draining() {
+		return readyCheck{Detail: "draining for shutdown"}
+	}
+	n := rd.life.InFlight()
+	if limit := rd.cfg.ReadyMaxInFlight; limit > 0 {
+		detail := fmt.Sprintf("%d/%d streams in flight", n, limit)
+		return readyCheck{OK: n < limit, Detail: detail}
+	}
+	return readyCheck{OK: true, Detail: fmt.Sprintf("%d streams in flight", n)}
 }
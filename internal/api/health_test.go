@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/sixfeetup/blobtube/internal/config"
+	"github.com/sixfeetup/blobtube/internal/transcode"
+)
+
+func fakeReadiness(t *testing.T, cfg config.Config, encoders string, ytdlpErr error) *readiness {
+	t.Helper()
+	ffmpeg := transcode.NewFFmpeg("ffmpeg", zerolog.Nop())
+	ffmpeg.Exec = transcode.ExecFunc(func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		return []byte(" ------\n" + encoders), nil, nil
+	})
+	ytdlp := transcode.NewYtDLP("yt-dlp", zerolog.Nop(), false)
+	ytdlp.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		if ytdlpErr != nil {
+			return nil, nil, ytdlpErr
+		}
+		return []byte("2025.01.01\n"), nil, nil
+	}
+	ladder, err := transcode.VariantLadder(cfg.VideoEncoders)
+	if err != nil {
+		t.Fatalf("VariantLadder: %v", err)
+	}
+	return newReadiness(cfg, ffmpeg, ytdlp, ladder, NewLifecycle())
+}
+
+func probeReady(t *testing.T, rd *readiness) (int, readyResponse) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	rd.serve(rr, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	var resp readyResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return rr.Code, resp
+}
+
+func TestReadiness_ReadyWhenDependenciesAreHealthy(t *testing.T) {
+	rd := fakeReadiness(t, config.Config{StreamsDir: t.TempDir()}, " V....D libx264  H.264\n", nil)
+	code, resp := probeReady(t, rd)
+	if code != http.StatusOK || resp.Status != "ready" {
+		t.Fatalf("expected ready, got %d %+v", code, resp)
+	}
+	for _, name := range []string{"ffmpeg", "ytdlp", "streams_dir", "queue"} {
+		if !resp.Checks[name].OK {
+			t.Fatalf("expected %s to pass: %+v", name, resp.Checks[name])
+		}
+	}
+	if resp.Checks["ytdlp"].Detail != "2025.01.01" {
+		t.Fatalf("expected the yt-dlp version, got %+v", resp.Checks["ytdlp"])
+	}
+}
+
+func TestReadiness_ReportsEachFailure(t *testing.T) {
+	cfg := config.Config{
+		StreamsDir:       t.TempDir(),
+		VideoEncoders:    []string{"h264", "av1"},
+		MinFreeBytes:     1 << 62,
+		ReadyMaxInFlight: 1,
+	}
+	rd := fakeReadiness(t, cfg, " V....D libx264  H.264\n", errors.New("exec: not found"))
+	rd.life.start()
+	defer rd.life.done()
+
+	code, resp := probeReady(t, rd)
+	if code != http.StatusServiceUnavailable || resp.Status != "not_ready" {
+		t.Fatalf("expected not ready, got %d %+v", code, resp)
+	}
+	for _, name := range []string{"ffmpeg", "ytdlp", "streams_dir", "queue"} {
+		if resp.Checks[name].OK {
+			t.Fatalf("expected %s to fail: %+v", name, resp.Checks[name])
+		}
+	}
+	if got := resp.Checks["ffmpeg"].Detail; got != "missing encoders: libsvtav1" {
+		t.Fatalf("unexpected ffmpeg detail %q", got)
+	}
+}
+
+func TestReadiness_CachesResults(t *testing.T) {
+	now := time.Unix(0, 0)
+	rd := fakeReadiness(t, config.Config{StreamsDir: t.TempDir()}, " V....D libx264  H.264\n", nil)
+	rd.now = func() time.Time { return now }
+	if _, resp := probeReady(t, rd); resp.Status != "ready" {
+		t.Fatalf("expected ready, got %+v", resp)
+	}
+
+	rd.life.Drain(0)
+	if _, resp := probeReady(t, rd); resp.Status != "ready" {
+		t.Fatalf("expected the cached result, got %+v", resp)
+	}
+	now = now.Add(readyCacheTTL)
+	if _, resp := probeReady(t, rd); resp.Status != "not_ready" || resp.Checks["queue"].OK {
+		t.Fatalf("expected a draining instance not to be ready, got %+v", resp)
+	}
+}
+
+func TestReadiness_CanceledProbeCachesNothing(t *testing.T) {
+	rd := fakeReadiness(t, config.Config{StreamsDir: t.TempDir()}, " V....D libx264  H.264\n", nil)
+	release := make(chan struct{})
+	version := rd.ytdlp.Exec
+	rd.ytdlp.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		<-release
+		return version(ctx, name, args...)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if resp := rd.check(ctx); resp.Status == "ready" {
+		t.Fatalf("expected a canceled probe not to report ready, got %+v", resp)
+	}
+	close(release)
+	if resp := rd.check(context.Background()); resp.Status != "ready" {
+		t.Fatalf("expected the refresh to finish unaffected, got %+v", resp)
+	}
+}
+
+func TestReadiness_MissingStreamsDirIsNotCreated(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "streams")
+	rd := fakeReadiness(t, config.Config{StreamsDir: dir}, " V....D libx264  H.264\n", nil)
+	if _, resp := probeReady(t, rd); resp.Checks["streams_dir"].OK {
+		t.Fatalf("expected a missing streams dir to fail, got %+v", resp.Checks["streams_dir"])
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("expected the probe to leave %s alone, got %v", dir, err)
+	}
+}
+
+func TestServeLive(t *testing.T) {
+	h, err := NewHandler(config.Config{StaticDir: t.TempDir()}, nil)
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/health/live", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "{\"status\":\"ok\"}\n" {
+		t.Fatalf("unexpected liveness response %d %q", rr.Code, rr.Body.String())
+	}
+}
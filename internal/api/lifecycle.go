@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// drainCancelWait bounds how long Drain waits for stream work to return
+// once it has been canceled.
+const drainCancelWait = 10 * time.Second
+
+// Lifecycle tracks the stream work a handler starts, so shutdown can stop
+// taking new streams and let running ones finish before files go away.
+// All stream work runs under its context.
+type Lifecycle struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	draining bool
+	inFlight int
+	wg       sync.WaitGroup
+}
+
+// DrainSummary reports what became of the stream work running when Drain
+// was called.
+type DrainSummary struct {
+	InFlight int `json:"in_flight"`
+	// Finished ran to completion within the grace period.
+	Finished int `json:"finished"`
+	// Canceled was stopped after the grace period and returned.
+	Canceled int `json:"canceled"`
+	// Abandoned hadn't returned drainCancelWait after being canceled.
+	Abandoned int `json:"abandoned"`
+}
+
+func NewLifecycle() *Lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Lifecycle{ctx: ctx, cancel: cancel}
+}
+
+// Context is canceled when a drain gives up waiting. A nil Lifecycle never
+// cancels.
+func (l *Lifecycle) Context() context.Context {
+	if l == nil {
+		return context.Background()
+	}
+	return l.ctx
+}
+
+// Draining reports whether new streams are refused.
+func (l *Lifecycle) Draining() bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.draining
+}
+
+// start counts a piece of stream work, unless draining has begun. Every
+// successful start must be paired with done.
+func (l *Lifecycle) start() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.draining {
+		return false
+	}
+	l.inFlight++
+	l.wg.Add(1)
+	return true
+}
+
+func (l *Lifecycle) done() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.inFlight--
+	l.mu.Unlock()
+	l.wg.Done()
+}
+
+// InFlight counts the stream work running now.
+func (l *Lifecycle) InFlight() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+// Drain refuses new streams, gives running ones grace to finish, then
+// cancels the rest and waits for them to return.
+func (l *Lifecycle) Drain(grace time.Duration) DrainSummary {
+	l.mu.Lock()
+	l.draining = true
+	sum := DrainSummary{InFlight: l.inFlight}
+	l.mu.Unlock()
+
+	idle := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(idle)
+	}()
+
+	wait := func(d time.Duration) {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-idle:
+		case <-t.C:
+		}
+	}
+
+	wait(grace)
+	left := l.InFlight()
+	sum.Finished = sum.InFlight - left
+	l.cancel()
+	if left > 0 {
+		wait(drainCancelWait)
+	}
+	sum.Abandoned = l.InFlight()
+	sum.Canceled = left - sum.Abandoned
+	return sum
+}
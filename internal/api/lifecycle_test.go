@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sixfeetup/blobtube/internal/config"
+	"github.com/sixfeetup/blobtube/internal/stream"
+)
+
+func TestLifecycle_DrainWaitsThenCancels(t *testing.T) {
+	l := NewLifecycle()
+
+	// One piece of work finishes within the grace period, one only stops
+	// when canceled.
+	for _, d := range []time.Duration{10 * time.Millisecond, time.Hour} {
+		if !l.start() {
+			t.Fatalf("expected start before draining")
+		}
+		go func(d time.Duration) {
+			defer l.done()
+			select {
+			case <-time.After(d):
+			case <-l.Context().Done():
+			}
+		}(d)
+	}
+
+	sum := l.Drain(200 * time.Millisecond)
+	want := DrainSummary{InFlight: 2, Finished: 1, Canceled: 1}
+	if sum != want {
+		t.Fatalf("expected %+v, got %+v", want, sum)
+	}
+	if !l.Draining() || l.start() {
+		t.Fatalf("expected no new work while draining")
+	}
+}
+
+func TestLifecycle_DrainWithNothingRunning(t *testing.T) {
+	l := NewLifecycle()
+	start := time.Now()
+	if sum := l.Drain(time.Minute); sum != (DrainSummary{}) {
+		t.Fatalf("unexpected summary %+v", sum)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("expected an idle drain to return at once")
+	}
+}
+
+func TestCreateStream_503WhileDraining(t *testing.T) {
+	l := NewLifecycle()
+	l.Drain(0)
+	streams := stream.NewManager(time.Minute)
+	h, err := NewHandler(config.Config{StaticDir: t.TempDir()}, streams, WithLifecycle(l))
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/stream/", strings.NewReader(`{"url":"https://www.youtube.com/watch?v=abc"}`))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 503 with Retry-After, got %d %v", rr.Code, rr.Header())
+	}
+	if len(streams.IDs()) != 0 {
+		t.Fatalf("expected no stream to be created")
+	}
+}
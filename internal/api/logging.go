@@ -1,15 +1,22 @@
 package api
 
 import (
+	"context"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
 type statusCapturingResponseWriter struct {
 	http.ResponseWriter
 	status int
+	bytes  int64
 }
 
 func (w *statusCapturingResponseWriter) WriteHeader(code int) {
@@ -17,19 +24,90 @@ func (w *statusCapturingResponseWriter) WriteHeader(code int) {
 	w.ResponseWriter.WriteHeader(code)
 }
 
+func (w *statusCapturingResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusCapturingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// RequestLogger logs every request; see requestLogger.
 func RequestLogger(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		cw := &statusCapturingResponseWriter{ResponseWriter: w, status: http.StatusOK}
-
-		next.ServeHTTP(cw, r)
-
-		d := time.Since(start)
-		log.Info().
-			Str("method", r.Method).
-			Str("path", r.URL.Path).
-			Int("status", cw.status).
-			Dur("duration", d).
-			Msg("request")
-	})
+	return requestLogger(1)(next)
+}
+
+// requestLogger puts a logger carrying the request ID, client IP, user
+// agent and route pattern in the request context, for handlers and the work
+// they start, and
+// logs each request once it's served. Players fetch a segment every few
+// seconds, so only one in sampleSegments successful segment fetches is
+// logged; failures always are.
+func requestLogger(sampleSegments uint32) func(http.Handler) http.Handler {
+	var sampler zerolog.Sampler
+	if sampleSegments > 1 {
+		sampler = &zerolog.BasicSampler{N: sampleSegments}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			pattern := routePattern(r)
+			logger := log.With().
+				Str("request_id", middleware.GetReqID(r.Context())).
+				Str("remote_ip", remoteIP(r)).
+				Str("user_agent", r.UserAgent()).
+				Str("route", pattern).
+				Logger()
+			r = r.WithContext(logger.WithContext(r.Context()))
+			cw := &statusCapturingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+
+			next.ServeHTTP(cw, r)
+
+			if sampler != nil && cw.status < http.StatusBadRequest && strings.HasSuffix(pattern, "/{segment}") {
+				logger = logger.Sample(sampler)
+			}
+			logger.Info().
+				Str("method", r.Method).
+				Str("path", r.URL.Path).
+				Int("status", cw.status).
+				Int64("bytes", cw.bytes).
+				Dur("duration", time.Since(start)).
+				Msg("request")
+		})
+	}
+}
+
+// routePattern finds the route r will take. Middleware runs before the
+// router has matched, so the pattern is looked up rather than read from the
+// route context, which only has it once the handler runs.
+func routePattern(r *http.Request) string {
+	rc := chi.RouteContext(r.Context())
+	if rc == nil || rc.Routes == nil {
+		return ""
+	}
+	path := r.URL.RawPath
+	if path == "" {
+		path = r.URL.Path
+	}
+	return rc.Routes.Find(chi.NewRouteContext(), r.Method, path)
+}
+
+// requestLog returns the logger requestLogger put in ctx, or the global
+// one outside a request.
+func requestLog(ctx context.Context) zerolog.Logger {
+	if l := zerolog.Ctx(ctx); l.GetLevel() != zerolog.Disabled {
+		return *l
+	}
+	return log.Logger
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// captureLogs points the global logger at a buffer for one test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := log.Logger
+	log.Logger = zerolog.New(&buf)
+	t.Cleanup(func() { log.Logger = prev })
+	return &buf
+}
+
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if l == "" {
+			continue
+		}
+		var m map[string]any
+		if err := json.Unmarshal([]byte(l), &m); err != nil {
+			t.Fatalf("decode %q: %v", l, err)
+		}
+		lines = append(lines, m)
+	}
+	return lines
+}
+
+func TestRequestLogger_CarriesRequestFields(t *testing.T) {
+	buf := captureLogs(t)
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(requestLogger(1))
+	r.Get("/things/{id}", func(w http.ResponseWriter, r *http.Request) {
+		l := requestLog(r.Context())
+		l.Info().Msg("handler")
+		_, _ = w.Write([]byte("hello"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/things/42", nil)
+	req.RemoteAddr = "203.0.113.7:1234"
+	req.Header.Set("User-Agent", "probe/1.0")
+	req.Header.Set(middleware.RequestIDHeader, "req-1")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := logLines(t, buf)
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines, got %d: %s", len(lines), buf)
+	}
+	for _, l := range lines {
+		if l["request_id"] != "req-1" || l["remote_ip"] != "203.0.113.7" || l["user_agent"] != "probe/1.0" || l["route"] != "/things/{id}" {
+			t.Fatalf("expected request fields on every line, got %v", l)
+		}
+	}
+	done := lines[1]
+	if done["bytes"] != float64(5) || done["status"] != float64(200) {
+		t.Fatalf("unexpected request line %v", done)
+	}
+}
+
+func TestRequestLogger_HandlerLogsCarryNestedRoute(t *testing.T) {
+	buf := captureLogs(t)
+	r := chi.NewRouter()
+	r.Use(requestLogger(1))
+	r.Route("/api/stream", func(r chi.Router) {
+		r.Route("/{id}", func(r chi.Router) {
+			r.Get("/{quality}/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
+				l := requestLog(r.Context())
+				l.Info().Msg("handler")
+			})
+		})
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/stream/abc/64x64/index.m3u8", nil))
+
+	lines := logLines(t, buf)
+	if len(lines) != 2 || lines[0]["route"] != "/api/stream/{id}/{quality}/index.m3u8" {
+		t.Fatalf("expected the full route on the handler's line, got %s", buf)
+	}
+}
+
+func TestRequestLogger_SamplesSuccessfulSegments(t *testing.T) {
+	buf := captureLogs(t)
+	r := chi.NewRouter()
+	r.Use(requestLogger(5))
+	r.Get("/s/{segment}", func(w http.ResponseWriter, r *http.Request) {
+		if chi.URLParam(r, "segment") == "missing" {
+			http.NotFound(w, r)
+		}
+	})
+
+	for i := 0; i < 10; i++ {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/s/ok", nil))
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/s/missing", nil))
+
+	lines := logLines(t, buf)
+	if len(lines) != 3 {
+		t.Fatalf("expected 2 sampled lines and the failure, got %d", len(lines))
+	}
+	if lines[2]["status"] != float64(404) {
+		t.Fatalf("expected the failure to be logged, got %v", lines[2])
+	}
+}
+
+func TestRequestLog_FallsBackToGlobalLogger(t *testing.T) {
+	buf := captureLogs(t)
+	l := requestLog(httptest.NewRequest(http.MethodGet, "/", nil).Context())
+	l.Info().Msg("outside a request")
+	if !strings.Contains(buf.String(), "outside a request") {
+		t.Fatalf("expected the global logger to be used")
+	}
+}
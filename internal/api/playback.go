@@ -0,0 +1,104 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/sixfeetup/blobtube/internal/auth"
+	"github.com/sixfeetup/blobtube/internal/config"
+	"github.com/sixfeetup/blobtube/internal/stream"
+)
+
+// playbackTokenParam carries the signed playback token on HLS URLs.
+const playbackTokenParam = "token"
+
+// playback signs the URLs players fetch. A nil *playback, or one without
+// a signer, leaves URLs unsigned.
+type playback struct {
+	signer *auth.Signer
+	// bindIP ties tokens to the client IP they were issued to.
+	bindIP bool
+}
+
+// binding is the client a token is issued to or checked against.
+func (p *playback) binding(r *http.Request) string {
+	if p == nil || !p.bindIP {
+		return ""
+	}
+	return remoteIP(r)
+}
+
+// query returns the query string that signs URLs of a stream, or "" when
+// signing is off. Every playlist served carries a fresh token, so a player
+// that keeps reloading a live playlist keeps access past the first token's
+// TTL; one that stops reloading loses it.
+func (p *playback) query(r *http.Request, streamID string) string {
+	if p == nil || p.signer == nil {
+		return ""
+	}
+	return url.Values{playbackTokenParam: {p.signer.Sign(streamID, p.binding(r))}}.Encode()
+}
+
+// signURL appends the stream's playback token to u.
+func (p *playback) signURL(r *http.Request, streamID, u string) string {
+	q := p.query(r, streamID)
+	if q == "" {
+		return u
+	}
+	return u + "?" + q
+}
+
+// playbackAccess guards playlist, segment and preview routes. With signing
+// on, a valid token grants access, as does the owning API key; without
+// it, ownerOnly's rules apply.
+func playbackAccess(streams *stream.Manager, keys *auth.Keyring, p *playback, public bool) func(http.Handler) http.Handler {
+	if p == nil || p.signer == nil {
+		return ownerOnly(streams, keys, public)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := chi.URLParam(r, "id")
+			if token := r.URL.Query().Get(playbackTokenParam); token != "" {
+				if err := p.signer.Verify(token, id, p.binding(r)); err != nil {
+					logger := requestLog(r.Context())
+					logger.Warn().Str("stream_id", id).Err(err).Msg("playback token rejected")
+					http.Error(w, `{"error":"invalid or expired token"}`, http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			if key, ok := auth.FromContext(r.Context()); ok {
+				if s, found := getStream(streams, id); found && s.Owner == key.Label {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			http.Error(w, `{"error":"signed url required"}`, http.StatusForbidden)
+		})
+	}
+}
+
+// newPlayback builds the URL signer described by cfg. Signing needs
+// configured keys: a key made up at startup would break every issued link
+// on the next restart.
+func newPlayback(cfg config.Config) (*playback, error) {
+	if !cfg.SignedURLs {
+		return nil, nil
+	}
+	keys, err := auth.ParseSigningKeys(cfg.URLSigningKeys)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("SIGNED_URLS needs URL_SIGNING_KEYS")
+	}
+	signer, err := auth.NewSigner(keys, cfg.URLSigningTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &playback{signer: signer, bindIP: cfg.URLSigningBindIP}, nil
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sixfeetup/blobtube/internal/auth"
+	"github.com/sixfeetup/blobtube/internal/config"
+	"github.com/sixfeetup/blobtube/internal/stream"
+)
+
+func newSignedHandler(t *testing.T, streams *stream.Manager) (http.Handler, string) {
+	t.Helper()
+	root := t.TempDir()
+	cfg := config.Config{
+		StreamsDir:     root,
+		StaticDir:      root,
+		PublicPlayback: true,
+		SignedURLs:     true,
+		URLSigningKeys: []string{"k1:0123456789abcdef"},
+		URLSigningTTL:  time.Hour,
+	}
+	h, err := NewHandler(cfg, streams)
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+	return h, root
+}
+
+func getPath(h http.Handler, path string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+	return rr
+}
+
+func TestSignedURLs_RequiredForPlayback(t *testing.T) {
+	streams := stream.NewManager(time.Minute)
+	streams.Register("abc", time.Now())
+	h, root := newSignedHandler(t, streams)
+
+	qDir := filepath.Join(root, "abc", "64x64")
+	if err := os.MkdirAll(qDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	playlist := "#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:4.000,\nsegment_00000.m4s\n"
+	if err := os.WriteFile(filepath.Join(qDir, "index.m3u8"), []byte(playlist), 0o644); err != nil {
+		t.Fatalf("write playlist: %v", err)
+	}
+
+	if rr := getPath(h, "/api/stream/abc/64x64/index.m3u8"); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without a token, got %d", rr.Code)
+	}
+	if rr := getPath(h, "/api/stream/abc/64x64/index.m3u8?token=k1.9999999999.bogus"); rr.Code != http.StatusForbidden ||
+		strings.TrimSpace(rr.Body.String()) != `{"error":"invalid or expired token"}` {
+		t.Fatalf("expected a generic 403 for a forged token, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	rr := getPath(h, "/api/stream/abc/status")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status: expected 200, got %d", rr.Code)
+	}
+	var status struct {
+		MasterURL string `json:"master_url"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	u, err := url.Parse(status.MasterURL)
+	if err != nil || u.Path != "/api/stream/abc/master.m3u8" || u.Query().Get("token") == "" {
+		t.Fatalf("expected a signed master url, got %q", status.MasterURL)
+	}
+	token := u.Query().Get("token")
+
+	rr = getPath(h, status.MasterURL)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("master: expected 200, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "64x64/index.m3u8?token=") {
+		t.Fatalf("expected signed variant URIs:\n%s", rr.Body.String())
+	}
+
+	rr = getPath(h, "/api/stream/abc/64x64/index.m3u8?token="+url.QueryEscape(token))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("media: expected 200, got %d", rr.Code)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, `URI="init.mp4?token=`) || !strings.Contains(body, "segment_00000.m4s?token=") {
+		t.Fatalf("expected signed segment URIs:\n%s", body)
+	}
+
+	// A token is bound to its stream.
+	streams.Register("other", time.Now())
+	if rr := getPath(h, "/api/stream/other/master.m3u8?token="+url.QueryEscape(token)); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for another stream, got %d", rr.Code)
+	}
+}
+
+func TestSignedURLs_NeedConfiguredKeys(t *testing.T) {
+	cfg := config.Config{StaticDir: t.TempDir(), SignedURLs: true, URLSigningTTL: time.Hour}
+	if _, err := NewHandler(cfg, stream.NewManager(time.Minute)); err == nil {
+		t.Fatalf("expected signed URLs without keys to be refused")
+	}
+}
+
+func TestSignedURLs_PlaylistsCarryFreshTokens(t *testing.T) {
+	streams := stream.NewManager(time.Minute)
+	streams.Register("abc", time.Now())
+	h, root := newSignedHandler(t, streams)
+	qDir := filepath.Join(root, "abc", "64x64")
+	if err := os.MkdirAll(qDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(qDir, "index.m3u8"), []byte("#EXTM3U\n#EXTINF:4.000,\nsegment_00000.m4s\n"), 0o644); err != nil {
+		t.Fatalf("write playlist: %v", err)
+	}
+
+	// A token about to expire, as a live player would hold after a while.
+	keys, err := auth.ParseSigningKeys([]string{"k1:0123456789abcdef"})
+	if err != nil {
+		t.Fatalf("ParseSigningKeys: %v", err)
+	}
+	short, err := auth.NewSigner(keys, time.Minute)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	old := short.Sign("abc", "")
+	rr := getPath(h, "/api/stream/abc/64x64/index.m3u8?token="+url.QueryEscape(old))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if strings.Contains(rr.Body.String(), url.QueryEscape(old)) {
+		t.Fatalf("expected a fresh token rather than the incoming one:\n%s", rr.Body.String())
+	}
+}
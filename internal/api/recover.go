@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sixfeetup/blobtube/internal/config"
+	"github.com/sixfeetup/blobtube/internal/stream"
+	"github.com/sixfeetup/blobtube/internal/transcode"
+)
+
+// streamDirRe matches the directories the server creates: stream IDs are
+// UUIDv4s. Anything else under StreamsDir isn't ours to touch.
+var streamDirRe = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+// RecoveryResult counts what RecoverStreams did with each stream dir.
+type RecoveryResult struct {
+	Restored int
+	Removed  int
+	Skipped  int
+}
+
+// RecoverStreams scans StreamsDir once at startup, before anything is
+// served. A stream whose transcode finished, with at least one tier
+// playlist closed by EXT-X-ENDLIST, is registered again as completed, last
+// played when its directory was last written, so output already past its
+// retention goes at the janitor's first pass. Everything else —
+// interrupted transcodes, live windows, failures — can never finish, so
+// its directory is removed.
+//
+// The records are rebuilt from disk alone: metadata and the owning API key
+// aren't kept there. With API keys on, a stream without its owner could
+// be neither reached nor purged by anyone, so those are removed too.
+func RecoverStreams(cfg config.Config, streams *stream.Manager) (RecoveryResult, error) {
+	var res RecoveryResult
+	ladder, err := transcode.VariantLadder(cfg.VideoEncoders)
+	if err != nil {
+		return res, err
+	}
+	entries, err := os.ReadDir(cfg.StreamsDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return res, nil
+	}
+	if err != nil {
+		return res, err
+	}
+
+	now := time.Now()
+	for _, e := range entries {
+		id := e.Name()
+		if !e.IsDir() || !streamDirRe.MatchString(id) {
+			res.Skipped++
+			continue
+		}
+		if _, known := streams.Get(id); known {
+			continue
+		}
+		logger := log.With().Str("stream_id", id).Logger()
+		streamDir := filepath.Join(cfg.StreamsDir, id)
+
+		if cfg.APIKeysFile != "" {
+			if err := os.RemoveAll(streamDir); err != nil {
+				logger.Warn().Err(err).Msg("failed to remove ownerless stream dir")
+				continue
+			}
+			logger.Info().Msg("removed stream dir whose owner can't be recovered")
+			res.Removed++
+			continue
+		}
+
+		var qualities []string
+		for _, v := range ladder {
+			if playlistEnded(filepath.Join(streamDir, string(v.Tier), "index.m3u8")) {
+				qualities = append(qualities, string(v.Tier))
+			}
+		}
+		if len(qualities) == 0 {
+			if err := os.RemoveAll(streamDir); err != nil {
+				logger.Warn().Err(err).Msg("failed to remove orphaned stream dir")
+				continue
+			}
+			logger.Info().Msg("removed orphaned stream dir")
+			res.Removed++
+			continue
+		}
+
+		written := now
+		if info, err := e.Info(); err == nil {
+			written = info.ModTime()
+		}
+		streams.Restore(stream.Stream{
+			ID:         id,
+			Qualities:  qualities,
+			State:      stream.StateCompleted,
+			CreatedAt:  written,
+			LastAccess: written,
+			Subtitles:  restoredSubtitles(streamDir),
+			Thumbnail:  fileExists(filepath.Join(streamDir, previewDir, transcode.ThumbnailFile)),
+			Storyboard: fileExists(filepath.Join(streamDir, previewDir, transcode.StoryboardTrack)),
+		})
+		logger.Info().Strs("qualities", qualities).Msg("restored completed stream")
+		res.Restored++
+	}
+	return res, nil
+}
+
+// playlistEnded reports whether a media playlist was closed, i.e. its
+// transcode ran to the end.
+func playlistEnded(path string) bool {
+	b, err := os.ReadFile(path)
+	return err == nil && bytes.Contains(b, []byte("#EXT-X-ENDLIST"))
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sixfeetup/blobtube/internal/config"
+	"github.com/sixfeetup/blobtube/internal/stream"
+)
+
+func writeFile(t *testing.T, path, body string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func TestRecoverStreams_RestoresCompletedAndRemovesOrphans(t *testing.T) {
+	root := t.TempDir()
+	const (
+		completed   = "0b6f3c1e-8a3d-4f5e-9b7a-1c2d3e4f5a6b"
+		interrupted = "1c7a4d2f-9b4e-4a6f-8c8b-2d3e4f5a6b7c"
+		empty       = "2d8b5e3a-ac5f-4b7a-9d9c-3e4f5a6b7c8d"
+	)
+	ended := "#EXTM3U\n#EXTINF:4.000,\nsegment_00000.m4s\n#EXT-X-ENDLIST\n"
+	writeFile(t, filepath.Join(root, completed, "64x64", "index.m3u8"), ended)
+	writeFile(t, filepath.Join(root, completed, "128x128", "index.m3u8"), "#EXTM3U\n")
+	writeFile(t, filepath.Join(root, completed, "subs", "en", "index.m3u8"), ended)
+	writeFile(t, filepath.Join(root, completed, "preview", "thumbnail.jpg"), "jpg")
+	writeFile(t, filepath.Join(root, interrupted, "64x64", "index.m3u8"), "#EXTM3U\n#EXTINF:4.000,\nsegment_00000.m4s\n")
+	if err := os.MkdirAll(filepath.Join(root, empty), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	writeFile(t, filepath.Join(root, "not-a-stream", "keep"), "x")
+
+	streams := stream.NewManager(time.Minute)
+	res, err := RecoverStreams(config.Config{StreamsDir: root}, streams)
+	if err != nil {
+		t.Fatalf("RecoverStreams: %v", err)
+	}
+	if res.Restored != 1 || res.Removed != 2 || res.Skipped != 1 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+
+	s, ok := streams.Get(completed)
+	if !ok {
+		t.Fatalf("expected the completed stream to be restored")
+	}
+	if s.State != stream.StateCompleted || len(s.Qualities) != 1 || s.Qualities[0] != "64x64" {
+		t.Fatalf("unexpected restored stream %+v", s)
+	}
+	if len(s.Subtitles) != 1 || s.Subtitles[0] != "en" || !s.Thumbnail || s.Storyboard {
+		t.Fatalf("unexpected restored extras %+v", s)
+	}
+	if s.LastAccess.After(time.Now()) || s.LastAccess.Before(time.Now().Add(-time.Minute)) {
+		t.Fatalf("expected last access from the dir mtime, got %v", s.LastAccess)
+	}
+
+	for _, id := range []string{interrupted, empty} {
+		if _, err := os.Stat(filepath.Join(root, id)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed, got %v", id, err)
+		}
+		if _, ok := streams.Get(id); ok {
+			t.Fatalf("expected %s not to be registered", id)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "not-a-stream", "keep")); err != nil {
+		t.Fatalf("expected unrelated dirs to be left alone: %v", err)
+	}
+}
+
+func TestRecoverStreams_ExpiresOldOutput(t *testing.T) {
+	root := t.TempDir()
+	const id = "0b6f3c1e-8a3d-4f5e-9b7a-1c2d3e4f5a6b"
+	writeFile(t, filepath.Join(root, id, "64x64", "index.m3u8"), "#EXTM3U\n#EXT-X-ENDLIST\n")
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(root, id), old, old); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+
+	streams := stream.NewManager(time.Minute)
+	streams.SetRetention(time.Hour)
+	if _, err := RecoverStreams(config.Config{StreamsDir: root}, streams); err != nil {
+		t.Fatalf("RecoverStreams: %v", err)
+	}
+	if expired := streams.ExpireInactive(time.Now()); len(expired) != 1 || expired[0] != id {
+		t.Fatalf("expected output past its retention to expire at once, got %v", expired)
+	}
+}
+
+func TestRecoverStreams_RemovesOwnerlessWithKeys(t *testing.T) {
+	root := t.TempDir()
+	const id = "0b6f3c1e-8a3d-4f5e-9b7a-1c2d3e4f5a6b"
+	writeFile(t, filepath.Join(root, id, "64x64", "index.m3u8"), "#EXTM3U\n#EXT-X-ENDLIST\n")
+
+	streams := stream.NewManager(time.Minute)
+	res, err := RecoverStreams(config.Config{StreamsDir: root, APIKeysFile: "keys.json"}, streams)
+	if err != nil || res.Restored != 0 || res.Removed != 1 {
+		t.Fatalf("expected the stream to be removed, got %+v, %v", res, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, id)); !os.IsNotExist(err) {
+		t.Fatalf("expected the dir to be removed, got %v", err)
+	}
+}
+
+func TestRecoverStreams_MissingDir(t *testing.T) {
+	res, err := RecoverStreams(config.Config{StreamsDir: filepath.Join(t.TempDir(), "missing")}, stream.NewManager(time.Minute))
+	if err != nil || res != (RecoveryResult{}) {
+		t.Fatalf("expected nothing to do, got %+v, %v", res, err)
+	}
+}
@@ -3,21 +3,33 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 
+	"github.com/sixfeetup/blobtube/internal/auth"
 	"github.com/sixfeetup/blobtube/internal/config"
+	"github.com/sixfeetup/blobtube/internal/outputcache"
 	"github.com/sixfeetup/blobtube/internal/stream"
 	"github.com/sixfeetup/blobtube/internal/transcode"
 )
 
 type CreateStreamRequest struct {
 	URL string `json:"url"`
+	// Proxy picks one of the operator's allowlisted yt-dlp proxies instead
+	// of the default.
+	Proxy string `json:"proxy,omitempty"`
+	// Subtitles lists the caption languages to fetch, e.g. ["en","es"].
+	// It defaults to the operator's SUBTITLE_LANGS; at most
+	// transcode.MaxSubtitleLanguages may be given.
+	Subtitles []string `json:"subtitles,omitempty"`
 }
 
 type CreateStreamResponse struct {
@@ -31,13 +43,32 @@ type StreamOrchestrator struct {
 	ytdlp    *transcode.YtDLP
 	ffmpeg   *transcode.FFmpeg
 	resource *stream.Resources
+	ladder   []transcode.VariantConfig
+	policy   transcode.Policy
+	// cache is nil unless the output cache is enabled.
+	cache *outputcache.Cache
+	// keys is nil unless API keys are configured.
+	keys *auth.Keyring
+	// life counts processStream runs and cancels them on shutdown.
+	life *Lifecycle
 }
 
+// drainingRetryAfter is the Retry-After sent while shutting down, long
+// enough for a replacement instance to come up.
+const drainingRetryAfter = "30"
+
 func serveCreateStream(orch *StreamOrchestrator) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if orch.life.Draining() {
+			w.Header().Set("Retry-After", drainingRetryAfter)
+			http.Error(w, `{"error":"server is shutting down"}`, http.StatusServiceUnavailable)
+			return
+		}
+
+		logger := requestLog(r.Context())
 		var req CreateStreamRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			log.Warn().Err(err).Msg("invalid json")
+			logger.Warn().Err(err).Msg("invalid json")
 			http.Error(w, `{"error":"invalid json"}`, http.StatusBadRequest)
 			return
 		}
@@ -47,13 +78,63 @@ func serveCreateStream(orch *StreamOrchestrator) http.HandlerFunc {
 			return
 		}
 
+		proxy, err := orch.ytdlp.Options.ResolveProxy(req.Proxy)
+		if err != nil {
+			http.Error(w, `{"error":"proxy not allowed"}`, http.StatusBadRequest)
+			return
+		}
+
+		if len(req.Subtitles) > transcode.MaxSubtitleLanguages {
+			http.Error(w, fmt.Sprintf(`{"error":"at most %d subtitle languages"}`, transcode.MaxSubtitleLanguages), http.StatusBadRequest)
+			return
+		}
+		langs := req.Subtitles
+		if langs == nil {
+			langs = orch.cfg.SubtitleLanguages
+		}
+		for _, l := range langs {
+			if !transcode.ValidSubtitleLanguage(l) {
+				http.Error(w, `{"error":"invalid subtitle language"}`, http.StatusBadRequest)
+				return
+			}
+		}
+
+		job := streamJob{url: req.URL, proxy: proxy, subtitles: langs, logger: logger}
+		if key, ok := auth.FromContext(r.Context()); ok && orch.keys != nil {
+			if err := orch.keys.Acquire(key); err != nil {
+				logger.Info().Str("key", key.Label).Err(err).Msg("api key quota exceeded")
+				http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusTooManyRequests)
+				return
+			}
+			job.key = &key
+		}
+
 		// Create stream entry
 		s, err := orch.streams.Create(time.Now())
 		if err != nil {
-			log.Error().Err(err).Msg("failed to create stream")
+			logger.Error().Err(err).Msg("failed to create stream")
+			orch.release(job)
 			http.Error(w, `{"error":"failed to create stream"}`, http.StatusInternalServerError)
 			return
 		}
+		if job.key != nil {
+			orch.streams.SetOwner(s.ID, job.key.Label)
+		}
+
+		qualities := make([]string, 0, len(orch.ladder))
+		for _, v := range orch.ladder {
+			qualities = append(qualities, string(v.Tier))
+		}
+		orch.streams.SetQualities(s.ID, qualities)
+
+		// A drain may have begun since the check above.
+		if !orch.life.start() {
+			orch.release(job)
+			orch.streams.Delete(s.ID)
+			w.Header().Set("Retry-After", drainingRetryAfter)
+			http.Error(w, `{"error":"server is shutting down"}`, http.StatusServiceUnavailable)
+			return
+		}
 
 		// Return stream ID immediately
 		resp := CreateStreamResponse{
@@ -61,27 +142,85 @@ func serveCreateStream(orch *StreamOrchestrator) http.HandlerFunc {
 			Status:   string(stream.StateInitializing),
 		}
 		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.WriteHeader(http.StatusAccepted)
 		json.NewEncoder(w).Encode(resp)
 
 		// Start async processing
-		go orch.processStream(s.ID, req.URL)
+		go orch.processStream(s.ID, job)
+	}
+}
+
+// streamJob is a validated create request.
+type streamJob struct {
+	url       string
+	proxy     string
+	subtitles []string
+	// key is the API key the stream counts against, if any.
+	key *auth.Key
+	// logger carries the creating request's fields, so the stream's logs
+	// can be traced back to it.
+	logger zerolog.Logger
+}
+
+// release returns a job's slot to its key's concurrency quota.
+func (orch *StreamOrchestrator) release(job streamJob) {
+	if job.key != nil && orch.keys != nil {
+		orch.keys.Release(*job.key)
+	}
+}
+
+// limitsFor applies a key's max duration on top of the server's.
+func (orch *StreamOrchestrator) limitsFor(job streamJob) (*transcode.FFmpeg, transcode.Policy) {
+	ff, policy := orch.ffmpeg, orch.policy
+	if job.key == nil || job.key.MaxDurationSeconds <= 0 {
+		return ff, policy
 	}
+	if limit := job.key.MaxDurationSeconds; ff.MaxDurationSeconds <= 0 || limit < ff.MaxDurationSeconds {
+		capped := *ff
+		capped.MaxDurationSeconds = limit
+		policy.MaxStreamSeconds = limit
+		return &capped, policy
+	}
+	return ff, policy
 }
 
-func (orch *StreamOrchestrator) processStream(streamID string, youtubeURL string) {
-	logger := log.With().Str("stream_id", streamID).Str("url", youtubeURL).Logger()
+func (orch *StreamOrchestrator) processStream(streamID string, job streamJob) {
+	defer orch.life.done()
+	defer orch.release(job)
+	youtubeURL, proxy := job.url, job.proxy
+	ffmpeg, policy := orch.limitsFor(job)
+
+	logger := job.logger.With().Str("stream_id", streamID).Str("url", youtubeURL).Logger()
 	logger.Info().Msg("stream processing started")
 
+	// Everything below runs under runCtx, registered before any work
+	// starts so an admin cancel or purge, or the janitor, can stop it at
+	// any point. One that settled the stream before the registration is
+	// caught by the state check.
+	runCtx, stop := context.WithCancel(orch.life.Context())
+	defer stop()
+	orch.resource.RegisterCancel(streamID, stop)
+	defer orch.resource.Forget(streamID)
+	if s, ok := orch.streams.Get(streamID); !ok || s.State.Terminal() {
+		logger.Info().Msg("stream stopped before processing started")
+		return
+	}
+
+	// A nil log would be stored as a non-nil io.WriterTo.
+	if stderrLog := transcode.NewStderrLog(orch.cfg.StderrLogBytes, logger); stderrLog != nil {
+		orch.resource.SetStderrLog(streamID, stderrLog)
+		ffmpeg = ffmpeg.WithStderrLog(stderrLog)
+		runCtx = transcode.ContextWithStderrLog(runCtx, stderrLog)
+	}
+
 	// Extract video info using yt-dlp (no need to get stream URL)
-	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+	ctx, cancel := context.WithTimeout(runCtx, 90*time.Second)
 	defer cancel()
 
-	info, err := orch.ytdlp.Execute(ctx, youtubeURL)
+	info, err := orch.ytdlp.ExecuteVia(ctx, youtubeURL, proxy)
 	if err != nil {
 		logger.Error().Err(err).Msg("yt-dlp extraction failed")
-		orch.streams.SetState(streamID, stream.StateError, fmt.Sprintf("yt-dlp failed: %v", err))
+		orch.failStream(streamID, err, stream.ErrCodeExtractionFailed)
 		return
 	}
 
@@ -92,48 +231,139 @@ func (orch *StreamOrchestrator) processStream(streamID string, youtubeURL string
 		Str("format", info.FormatNote).
 		Msg("yt-dlp extraction successful")
 
+	preflight, err := policy.Check(info)
+	if err != nil {
+		logger.Warn().Err(err).Str("live_status", info.LiveStatus).Int("age_limit", info.AgeLimit).Msg("source rejected by policy")
+		orch.failStream(streamID, err, stream.ErrCodeInternal)
+		return
+	}
+	orch.streams.SetMetadata(streamID, stream.Metadata{
+		Title:                    info.Title,
+		Channel:                  info.Channel,
+		DurationSeconds:          info.Duration,
+		EffectiveDurationSeconds: preflight.EffectiveSeconds,
+	})
+	if preflight.Live {
+		orch.streams.SetLive(streamID, true)
+		logger.Info().Msg("relaying live broadcast")
+	}
+	if preflight.Truncated {
+		logger.Info().Int("effective_duration", preflight.EffectiveSeconds).Msg("source will be truncated at max duration")
+	}
+
 	// Create output directory for this stream
 	streamDir := filepath.Join(orch.cfg.StreamsDir, streamID)
 	if err := os.MkdirAll(streamDir, 0o755); err != nil {
 		logger.Error().Err(err).Msg("failed to create stream directory")
-		orch.streams.SetState(streamID, stream.StateError, fmt.Sprintf("failed to create directory: %v", err))
+		orch.failStream(streamID, err, stream.ErrCodeInternal)
 		return
 	}
 
+	cacheKey := orch.cacheKey(info, preflight, ffmpeg.MaxDurationSeconds, job.subtitles)
+	if cacheKey != "" {
+		hit, err := orch.cache.Restore(cacheKey, streamDir)
+		if err != nil {
+			logger.Warn().Err(err).Msg("output cache restore failed")
+		}
+		if hit {
+			logger.Info().Str("video_id", info.VideoID).Msg("serving transcoded output from cache")
+			orch.streams.SetSubtitles(streamID, restoredSubtitles(streamDir))
+			orch.streams.SetPreviews(streamID,
+				fileExists(filepath.Join(streamDir, previewDir, transcode.ThumbnailFile)),
+				fileExists(filepath.Join(streamDir, previewDir, transcode.StoryboardTrack)))
+			orch.advance(logger, streamID, streamDir, stream.StateCompleted)
+			return
+		}
+	}
+
+	// Extras run beside the transcode rather than ahead of it, and are
+	// published as they land; the master playlist is built per request.
+	// Whatever way processStream ends, they're stopped and waited for
+	// before settled looks at streamDir, so none write into it after.
+	var extras sync.WaitGroup
+	defer func() {
+		stop()
+		extras.Wait()
+		orch.settled(streamID, streamDir)
+	}()
+
+	var thumbnail bool
+	extras.Add(1)
+	go func() {
+		defer extras.Done()
+		thumbnail = orch.fetchThumbnail(runCtx, logger, ffmpeg, info.Thumbnail, proxy, streamDir)
+		orch.streams.SetPreviews(streamID, thumbnail, false)
+	}()
+
+	// Live captions would need their own sliding window; live relays go
+	// without.
+	var subtitles []string
+	if !preflight.Live && len(job.subtitles) > 0 {
+		extras.Add(1)
+		go func() {
+			defer extras.Done()
+			subtitles = orch.fetchSubtitles(runCtx, logger, youtubeURL, proxy, job.subtitles, streamDir, preflight.EffectiveSeconds)
+			orch.streams.SetSubtitles(streamID, subtitles)
+		}()
+	}
+
 	// Start multi-quality transcoding using yt-dlp pipe
 	// Instead of passing the stream URL directly, we use yt-dlp to pipe the video
-	orch.streams.SetState(streamID, stream.StateActive, "")
+	if !orch.advance(logger, streamID, streamDir, stream.StateActive) {
+		return
+	}
 	logger.Info().Str("youtube_url", youtubeURL).Msg("starting transcoding via yt-dlp pipe")
 
-	transcodeCtx, transcodeCancel := context.WithTimeout(context.Background(), 2*time.Hour)
+	// A live relay has no natural end short of the broadcast's; viewer
+	// inactivity stops it through the janitor's cleanup instead.
+	run := transcode.TranscodeMultiQualityHLSFromYouTube
+	var transcodeCtx context.Context
+	var transcodeCancel context.CancelFunc
+	if preflight.Live {
+		run = transcode.TranscodeLiveHLSFromYouTube
+		transcodeCtx, transcodeCancel = context.WithCancel(runCtx)
+	} else {
+		transcodeCtx, transcodeCancel = context.WithTimeout(runCtx, 2*time.Hour)
+	}
 	defer transcodeCancel()
 
-	result, err := transcode.TranscodeMultiQualityHLSFromYouTube(
+	result, err := run(
 		transcodeCtx,
 		logger,
-		orch.ffmpeg,
-		orch.ytdlp,
-		youtubeURL,
+		ffmpeg,
+		orch.ytdlp.Input(youtubeURL, proxy),
 		streamDir,
-		transcode.DefaultVariantConfigs(),
+		orch.ladder,
 	)
 
-	// Track the ffmpeg processes for cleanup
-	// Note: We'll need to enhance the Resources tracking to handle this properly
-	// For now, the janitor will clean up based on stream timeout
+	if errors.Is(transcodeCtx.Err(), context.Canceled) {
+		// Stopped by cleanup, which has already settled the stream, or
+		// by shutdown.
+		logger.Info().Bool("live", preflight.Live).Msg("transcoding stopped")
+		orch.settled(streamID, streamDir)
+		return
+	}
 
 	if err != nil {
 		logger.Error().Err(err).Msg("transcoding initialization failed")
-		orch.streams.SetState(streamID, stream.StateError, fmt.Sprintf("transcoding failed: %v", err))
+		orch.failStream(streamID, err, stream.ErrCodeEncoderFailed)
 		return
 	}
 
 	// Check for errors in individual quality tiers
 	hasErrors := false
-	for tier, tierErr := range result.Errors {
-		if tierErr != nil {
-			logger.Error().Str("tier", string(tier)).Err(tierErr).Msg("quality tier failed")
+	var tierErr error
+	for tier, err := range result.Errors {
+		if err != nil {
+			logger.Error().Str("tier", string(tier)).Err(err).Msg("quality tier failed")
 			hasErrors = true
+			tierErr = err
+		}
+	}
+
+	for tier, tierRes := range result.Results {
+		if tierRes.Truncated {
+			logger.Info().Str("tier", string(tier)).Msg("source truncated at max duration")
 		}
 	}
 
@@ -144,10 +374,164 @@ func (orch *StreamOrchestrator) processStream(streamID string, youtubeURL string
 	// Check if we have at least one successful quality
 	if len(result.Results) == 0 || len(result.Results) == len(result.Errors) {
 		logger.Error().Msg("all quality tiers failed")
-		orch.streams.SetState(streamID, stream.StateError, "all quality tiers failed")
+		orch.failStream(streamID, tierErr, stream.ErrCodeEncoderFailed)
 		return
 	}
 
+	if !orch.advance(logger, streamID, streamDir, stream.StateCompleted) {
+		return
+	}
 	logger.Info().Msg("transcoding completed successfully")
-	orch.streams.SetState(streamID, stream.StateCompleted, "")
+	// The storyboard and the cache entry need the extras, whose results
+	// are theirs until now.
+	extras.Wait()
+
+	// A live window has already dropped most of the broadcast.
+	if !preflight.Live && orch.buildStoryboard(runCtx, logger, ffmpeg, result, streamDir) {
+		orch.streams.SetPreviews(streamID, thumbnail, true)
+	}
+
+	// Only a complete ladder is worth replaying.
+	if cacheKey != "" && !hasErrors {
+		tiers := make([]string, 0, len(orch.ladder))
+		for _, v := range orch.ladder {
+			tiers = append(tiers, string(v.Tier))
+		}
+		if len(subtitles) > 0 {
+			tiers = append(tiers, subtitleDir)
+		}
+		if fileExists(filepath.Join(streamDir, previewDir)) {
+			tiers = append(tiers, previewDir)
+		}
+		if err := orch.cache.Put(cacheKey, info.VideoID, streamDir, tiers); err != nil {
+			logger.Warn().Err(err).Msg("failed to store output in cache")
+		}
+	}
+}
+
+// cacheKey returns the output cache key for a source, or "" when its
+// output can't be cached.
+func (orch *StreamOrchestrator) cacheKey(info transcode.StreamInfo, preflight transcode.Preflight, maxDuration int, subtitles []string) string {
+	if orch.cache == nil || preflight.Live || info.VideoID == "" {
+		return ""
+	}
+	settings := fmt.Sprintf("%smax_duration=%d\nsubtitles=%s\n",
+		transcode.LadderFingerprint(orch.ladder), maxDuration, strings.Join(subtitles, ","))
+	return outputcache.Key(info.VideoID, settings)
+}
+
+// subtitleDir holds one segmented WebVTT rendition per language.
+const subtitleDir = "subs"
+
+// fetchSubtitles downloads and segments the requested subtitles, returning
+// the languages now available. Captions are an extra: failures are logged
+// and the stream carries on without them.
+func (orch *StreamOrchestrator) fetchSubtitles(ctx context.Context, logger zerolog.Logger, youtubeURL, proxy string, langs []string, streamDir string, effectiveSeconds int) []string {
+	tmp, err := os.MkdirTemp("", "blobtube-subs-")
+	if err != nil {
+		logger.Warn().Err(err).Msg("failed to create subtitle temp dir")
+		return nil
+	}
+	defer os.RemoveAll(tmp)
+
+	ctx, cancel := context.WithTimeout(ctx, 45*time.Second)
+	defer cancel()
+
+	subs, err := orch.ytdlp.DownloadSubtitles(ctx, youtubeURL, proxy, langs, tmp)
+	if err != nil {
+		logger.Warn().Err(err).Strs("languages", langs).Msg("subtitle download failed")
+		return nil
+	}
+	written, err := transcode.WriteSubtitleRenditions(subs, filepath.Join(streamDir, subtitleDir), effectiveSeconds)
+	if err != nil {
+		logger.Warn().Err(err).Msg("failed to segment subtitles")
+	}
+	logger.Info().Strs("languages", written).Msg("subtitles ready")
+	return written
+}
+
+// previewDir holds the thumbnail and the storyboard sprite sheets.
+const previewDir = "preview"
+
+// fetchThumbnail proxies the source thumbnail into a small local JPEG so
+// clients never contact YouTube. Like subtitles, it's best effort.
+func (orch *StreamOrchestrator) fetchThumbnail(ctx context.Context, logger zerolog.Logger, ffmpeg *transcode.FFmpeg, thumbURL, proxy string, streamDir string) bool {
+	if thumbURL == "" {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	src, err := transcode.FetchThumbnail(ctx, thumbURL, proxy)
+	if err != nil {
+		logger.Warn().Err(err).Msg("thumbnail download failed")
+		return false
+	}
+	if err := ffmpeg.Thumbnail(ctx, src, filepath.Join(streamDir, previewDir, transcode.ThumbnailFile)); err != nil {
+		logger.Warn().Err(err).Msg("thumbnail conversion failed")
+		return false
+	}
+	return true
+}
+
+// buildStoryboard samples the smallest completed tier into scrub-preview
+// sprite sheets.
+func (orch *StreamOrchestrator) buildStoryboard(ctx context.Context, logger zerolog.Logger, ffmpeg *transcode.FFmpeg, result transcode.MultiQualityResult, streamDir string) bool {
+	for _, v := range orch.ladder {
+		res, ok := result.Results[v.Tier]
+		if !ok || result.Errors[v.Tier] != nil || res.PlaylistPath == "" {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+		defer cancel()
+		if err := ffmpeg.Storyboard(ctx, res.PlaylistPath, filepath.Join(streamDir, previewDir)); err != nil {
+			logger.Warn().Err(err).Str("tier", string(v.Tier)).Msg("storyboard generation failed")
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// advance moves the stream to state after work written into streamDir. It
+// fails when an admin cancel or purge, or the janitor, settled the stream
+// first; see settled.
+func (orch *StreamOrchestrator) advance(logger zerolog.Logger, streamID, streamDir string, state stream.State) bool {
+	if orch.streams.SetState(streamID, state, "") {
+		return true
+	}
+	logger.Info().Str("state", string(state)).Msg("stream settled while processing; stopping")
+	orch.settled(streamID, streamDir)
+	return false
+}
+
+// settled removes streamDir when whoever settled the stream meant its files
+// to go: it was purged, or timed out by the janitor. Both remove the
+// directory themselves after settling the stream, so this only catches what
+// processStream wrote after that.
+func (orch *StreamOrchestrator) settled(streamID, streamDir string) {
+	if s, ok := orch.streams.Get(streamID); ok && s.State != stream.StateTimedOut {
+		return
+	}
+	_ = os.RemoveAll(streamDir)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// restoredSubtitles lists the subtitle languages present in streamDir.
+func restoredSubtitles(streamDir string) []string {
+	entries, err := os.ReadDir(filepath.Join(streamDir, subtitleDir))
+	if err != nil {
+		return nil
+	}
+	var langs []string
+	for _, e := range entries {
+		if e.IsDir() && transcode.ValidSubtitleLanguage(e.Name()) {
+			langs = append(langs, e.Name())
+		}
+	}
+	return langs
 }
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sixfeetup/blobtube/internal/config"
+	"github.com/sixfeetup/blobtube/internal/stream"
+)
+
+func TestCreateStream_RejectsTooManySubtitleLanguages(t *testing.T) {
+	streams := stream.NewManager(time.Minute)
+	h, err := NewHandler(config.Config{StaticDir: t.TempDir()}, streams)
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+
+	body := `{"url":"https://www.youtube.com/watch?v=abc","subtitles":["en","es","fr","de","it"]}`
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/stream/", strings.NewReader(body)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+	if len(streams.IDs()) != 0 {
+		t.Fatalf("expected no stream to be created")
+	}
+}
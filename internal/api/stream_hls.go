@@ -1,30 +1,77 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 
 	"github.com/sixfeetup/blobtube/internal/config"
+	"github.com/sixfeetup/blobtube/internal/hls"
 	"github.com/sixfeetup/blobtube/internal/stream"
+	"github.com/sixfeetup/blobtube/internal/transcode"
 )
 
 var streamIDRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
 
+// liveSessionDataID flags live relays in the master playlist, for players
+// that want to show a live badge before loading a media playlist.
+const liveSessionDataID = "com.blobtube.live"
+
 var segmentRe = regexp.MustCompile(`^(segment_\d+\.m4s|init\.mp4)$`)
 
-var allowedQualities = map[string]struct{}{
-	"64x64":   {},
-	"128x128": {},
-	"256x256": {},
+var segmentNumberRe = regexp.MustCompile(`^segment_(\d+)\.m4s$`)
+
+var subtitleFileRe = regexp.MustCompile(`^(segment_\d+\.vtt|index\.m3u8)$`)
+
+var storyboardFileRe = regexp.MustCompile(`^(storyboard_\d{3}\.jpg|storyboard\.vtt)$`)
+
+// qualitySet returns the tier names of the ladder, which double as the
+// quality directory names and URL path segments.
+func qualitySet(ladder []transcode.VariantConfig) map[string]struct{} {
+	qs := make(map[string]struct{}, len(ladder))
+	for _, v := range ladder {
+		qs[string(v.Tier)] = struct{}{}
+	}
+	return qs
+}
+
+// masterVariants describes the ladder tiers named in qualities for the
+// master playlist, in ladder order, with absolute URIs so players resolve
+// them against the API rather than the page (see TODO.md, lesson 4). A
+// stream restored at startup may have only some of the tiers.
+func masterVariants(baseURL string, ladder []transcode.VariantConfig, qualities []string) ([]hls.Variant, error) {
+	have := make(map[string]bool, len(qualities))
+	for _, q := range qualities {
+		have[q] = true
+	}
+	variants := make([]hls.Variant, 0, len(qualities))
+	for _, v := range ladder {
+		if !have[string(v.Tier)] {
+			continue
+		}
+		peak, average, err := v.Bandwidth()
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, hls.Variant{
+			URI:              baseURL + "/" + string(v.Tier) + "/index.m3u8",
+			Bandwidth:        peak,
+			AverageBandwidth: average,
+			Resolution:       fmt.Sprintf("%dx%d", v.Width, v.Height),
+			Codecs:           v.Codecs(),
+		})
+	}
+	return variants, nil
 }
 
-func serveMasterPlaylist(cfg config.Config, streams *stream.Manager) http.HandlerFunc {
+func serveMasterPlaylist(cfg config.Config, streams *stream.Manager, ladder []transcode.VariantConfig, pb *playback) http.HandlerFunc {
 	base := cfg.StreamsDir
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
@@ -44,29 +91,44 @@ func serveMasterPlaylist(cfg config.Config, streams *stream.Manager) http.Handle
 			return
 		}
 
-		// Generate master playlist dynamically with absolute URLs
-		baseURL := "/api/stream/" + id
-		master := `#EXTM3U
-#EXT-X-VERSION:6
-
-#EXT-X-STREAM-INF:BANDWIDTH=50000,RESOLUTION=64x64,CODECS="avc1.42E01E,mp4a.40.2"
-` + baseURL + `/64x64/index.m3u8
-
-#EXT-X-STREAM-INF:BANDWIDTH=100000,RESOLUTION=128x128,CODECS="avc1.42E01E,mp4a.40.2"
-` + baseURL + `/128x128/index.m3u8
+		s, ok := getStream(streams, id)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
 
-#EXT-X-STREAM-INF:BANDWIDTH=200000,RESOLUTION=256x256,CODECS="avc1.42E01E,mp4a.40.2"
-` + baseURL + `/256x256/index.m3u8
-`
+		// Generate master playlist dynamically with absolute URLs
+		variants, err := masterVariants("/api/stream/"+id, ladder, s.Qualities)
+		if err != nil || len(variants) == 0 {
+			http.Error(w, "failed to build master playlist", http.StatusInternalServerError)
+			return
+		}
+		for i := range variants {
+			variants[i].URI = pb.signURL(r, id, variants[i].URI)
+		}
+		var opts []hls.MasterOption
+		if s.Live {
+			opts = append(opts, hls.WithSessionData(liveSessionDataID, "true"))
+		}
+		if len(s.Subtitles) > 0 {
+			renditions := subtitleRenditions("/api/stream/"+id, s.Subtitles)
+			for i := range renditions {
+				renditions[i].URI = pb.signURL(r, id, renditions[i].URI)
+			}
+			opts = append(opts, hls.WithSubtitles(renditions))
+		}
+		master, err := hls.BuildMasterPlaylist(variants, opts...)
+		if err != nil {
+			http.Error(w, "failed to build master playlist", http.StatusInternalServerError)
+			return
+		}
 
-		touchOrRegister(streams, id)
 		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
-		setCORSHeaders(w)
-		w.Write([]byte(master))
+		w.Write(master)
 	}
 }
 
-func serveMediaPlaylist(cfg config.Config, streams *stream.Manager) http.HandlerFunc {
+func serveMediaPlaylist(cfg config.Config, streams *stream.Manager, qualities map[string]struct{}, pb *playback) http.HandlerFunc {
 	base := cfg.StreamsDir
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
@@ -75,7 +137,7 @@ func serveMediaPlaylist(cfg config.Config, streams *stream.Manager) http.Handler
 			return
 		}
 		quality := chi.URLParam(r, "quality")
-		if _, ok := allowedQualities[quality]; !ok {
+		if _, ok := qualities[quality]; !ok {
 			http.Error(w, "invalid quality", http.StatusBadRequest)
 			return
 		}
@@ -90,14 +152,16 @@ func serveMediaPlaylist(cfg config.Config, streams *stream.Manager) http.Handler
 			return
 		}
 
-		touchOrRegister(streams, id)
 		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
-		setCORSHeaders(w)
-		http.ServeFile(w, r, p)
+		if isLive(streams, id) {
+			// The sliding window changes every segment.
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		servePlaylist(w, r, p, pb.query(r, id))
 	}
 }
 
-func serveSegment(cfg config.Config, streams *stream.Manager) http.HandlerFunc {
+func serveSegment(cfg config.Config, streams *stream.Manager, qualities map[string]struct{}) http.HandlerFunc {
 	base := cfg.StreamsDir
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
@@ -106,7 +170,7 @@ func serveSegment(cfg config.Config, streams *stream.Manager) http.HandlerFunc {
 			return
 		}
 		quality := chi.URLParam(r, "quality")
-		if _, ok := allowedQualities[quality]; !ok {
+		if _, ok := qualities[quality]; !ok {
 			http.Error(w, "invalid quality", http.StatusBadRequest)
 			return
 		}
@@ -119,54 +183,199 @@ func serveSegment(cfg config.Config, streams *stream.Manager) http.HandlerFunc {
 		segPath := filepath.Join(base, id, quality, seg)
 		if _, err := os.Stat(segPath); err != nil {
 			if os.IsNotExist(err) {
-				// If a stream exists (playlist present), treat missing segments as "not ready".
-				playlistPath := filepath.Join(base, id, quality, "index.m3u8")
-				if _, perr := os.Stat(playlistPath); perr == nil {
-					touchOrRegister(streams, id)
-					w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-					setCORSHeaders(w)
-					w.Header().Set("Retry-After", "1")
-					w.WriteHeader(http.StatusAccepted)
-					_, _ = w.Write([]byte("segment not ready"))
-					return
-				}
-				http.NotFound(w, r)
+				serveMissingSegment(w, r, filepath.Join(base, id, quality, "index.m3u8"), seg)
 				return
 			}
 			http.Error(w, "failed to read segment", http.StatusInternalServerError)
 			return
 		}
 
-		touchOrRegister(streams, id)
 		// Use video/mp4 for fMP4 segments (.m4s and init.mp4)
 		w.Header().Set("Content-Type", "video/mp4")
-		setCORSHeaders(w)
 		http.ServeFile(w, r, segPath)
 	}
 }
 
-func corsPreflight(w http.ResponseWriter, r *http.Request) {
-	setCORSHeaders(w)
-	// Minimal headers for HLS requests from browsers.
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	h := r.Header.Get("Access-Control-Request-Headers")
-	if strings.TrimSpace(h) != "" {
-		w.Header().Set("Access-Control-Allow-Headers", h)
+// serveMissingSegment tells a segment still being written, answered with
+// 202 so players retry, from one that will never exist: a live segment
+// that slid out of the window and was deleted gets 410, anything missing
+// from a finished playlist 404.
+func serveMissingSegment(w http.ResponseWriter, r *http.Request, playlistPath, seg string) {
+	b, err := os.ReadFile(playlistPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	first, ended := hls.MediaWindow(b)
+	if m := segmentNumberRe.FindStringSubmatch(seg); m != nil {
+		if n, err := strconv.ParseUint(m[1], 10, 64); err == nil && n < first {
+			http.Error(w, "segment expired", http.StatusGone)
+			return
+		}
+	}
+	if ended {
+		http.NotFound(w, r)
+		return
 	}
-	w.WriteHeader(http.StatusNoContent)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = w.Write([]byte("segment not ready"))
 }
 
-func setCORSHeaders(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+// subtitleRenditions describes each subtitle language for the master
+// playlist, with absolute URIs like the variants.
+func subtitleRenditions(baseURL string, langs []string) []hls.Rendition {
+	rs := make([]hls.Rendition, 0, len(langs))
+	for _, l := range langs {
+		rs = append(rs, hls.Rendition{Language: l, Name: l, URI: baseURL + "/" + subtitleDir + "/" + l + "/index.m3u8"})
+	}
+	return rs
 }
 
-func touchOrRegister(streams *stream.Manager, id string) {
-	if streams == nil {
+// serveSubtitle serves the segmented WebVTT renditions written next to the
+// quality tiers: subs/<lang>/index.m3u8 and its segment_N.vtt files.
+func serveSubtitle(cfg config.Config, streams *stream.Manager, pb *playback) http.HandlerFunc {
+	base := cfg.StreamsDir
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		if !streamIDRe.MatchString(id) {
+			http.Error(w, "invalid stream id", http.StatusBadRequest)
+			return
+		}
+		lang := chi.URLParam(r, "lang")
+		if !transcode.ValidSubtitleLanguage(lang) {
+			http.Error(w, "invalid language", http.StatusBadRequest)
+			return
+		}
+		file := chi.URLParam(r, "file")
+		if !subtitleFileRe.MatchString(file) {
+			http.Error(w, "invalid subtitle file", http.StatusBadRequest)
+			return
+		}
+
+		p := filepath.Join(base, id, subtitleDir, lang, file)
+		if _, err := os.Stat(p); err != nil {
+			if os.IsNotExist(err) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, "failed to read subtitles", http.StatusInternalServerError)
+			return
+		}
+
+		if strings.HasSuffix(file, ".m3u8") {
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			servePlaylist(w, r, p, pb.query(r, id))
+			return
+		}
+		w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+		http.ServeFile(w, r, p)
+	}
+}
+
+// serveThumbnail serves the downscaled source thumbnail.
+func serveThumbnail(cfg config.Config, streams *stream.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		servePreview(w, r, cfg.StreamsDir, streams, transcode.ThumbnailFile, nil)
+	}
+}
+
+// serveStoryboard serves the scrub-preview sprite sheets and their WebVTT
+// thumbnails track.
+func serveStoryboard(cfg config.Config, streams *stream.Manager, pb *playback) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		file := chi.URLParam(r, "file")
+		if !storyboardFileRe.MatchString(file) {
+			http.Error(w, "invalid storyboard file", http.StatusBadRequest)
+			return
+		}
+		servePreview(w, r, cfg.StreamsDir, streams, file, pb)
+	}
+}
+
+func servePreview(w http.ResponseWriter, r *http.Request, base string, streams *stream.Manager, file string, pb *playback) {
+	id := chi.URLParam(r, "id")
+	if !streamIDRe.MatchString(id) {
+		http.Error(w, "invalid stream id", http.StatusBadRequest)
+		return
+	}
+
+	p := filepath.Join(base, id, previewDir, file)
+	if _, err := os.Stat(p); err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "failed to read preview", http.StatusInternalServerError)
+		return
+	}
+
+	if !strings.HasSuffix(file, ".vtt") {
+		w.Header().Set("Content-Type", "image/jpeg")
+		http.ServeFile(w, r, p)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	q := pb.query(r, id)
+	if q == "" {
+		http.ServeFile(w, r, p)
+		return
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		http.Error(w, "failed to read preview", http.StatusInternalServerError)
+		return
+	}
+	// Cue payloads are sheet URIs, relative to the track.
+	lines := strings.Split(string(b), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "storyboard_") {
+			lines[i] = hls.WithQuery(line, q)
+		}
+	}
+	_, _ = w.Write([]byte(strings.Join(lines, "\n")))
+}
+
+// servePlaylist serves a media playlist from disk, signing its URIs with
+// query when set.
+func servePlaylist(w http.ResponseWriter, r *http.Request, path, query string) {
+	if query == "" {
+		http.ServeFile(w, r, path)
 		return
 	}
-	now := time.Now()
-	if streams.Touch(id, now) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		http.Error(w, "failed to read playlist", http.StatusInternalServerError)
 		return
 	}
-	streams.Register(id, now)
+	_, _ = w.Write(hls.AppendQuery(b, query))
+}
+
+func getStream(streams *stream.Manager, id string) (stream.Stream, bool) {
+	if streams == nil {
+		return stream.Stream{}, false
+	}
+	return streams.Get(id)
+}
+
+func isLive(streams *stream.Manager, id string) bool {
+	s, ok := getStream(streams, id)
+	return ok && s.Live
+}
+
+// knownStream refuses playback of streams the manager doesn't know, and
+// records viewer activity on those it does. A directory left on disk
+// doesn't make a stream; RecoverStreams decides which ones come back.
+func knownStream(streams *stream.Manager) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if streams == nil || !streams.Touch(chi.URLParam(r, "id"), time.Now()) {
+				http.NotFound(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
 }
@@ -1,10 +1,12 @@
 package api
 
 import (
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -25,23 +27,64 @@ func TestServeSegment_Returns202WhenPlaylistExistsButSegmentMissing(t *testing.T
 		t.Fatalf("write playlist: %v", err)
 	}
 
-	h, err := NewHandler(config.Config{StreamsDir: root, StaticDir: root}, stream.NewManager(5*time.Minute))
+	streams := stream.NewManager(5 * time.Minute)
+	streams.Register(streamID, time.Now())
+	cfg := config.Config{StreamsDir: root, StaticDir: root, CORSOrigins: []string{"https://embed.example"}}
+	h, err := NewHandler(cfg, streams)
 	if err != nil {
 		t.Fatalf("NewHandler: %v", err)
 	}
 
-	req := httptest.NewRequest(http.MethodGet, "/api/stream/"+streamID+"/"+quality+"/segment_00001.ts", nil)
+	req := httptest.NewRequest(http.MethodGet, "/api/stream/"+streamID+"/"+quality+"/segment_00001.m4s", nil)
+	req.Header.Set("Origin", "https://embed.example")
 	rr := httptest.NewRecorder()
 	h.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusAccepted {
 		t.Fatalf("expected 202, got %d", rr.Code)
 	}
-	if rr.Header().Get("Access-Control-Allow-Origin") != "*" {
+	if rr.Header().Get("Access-Control-Allow-Origin") != "https://embed.example" {
 		t.Fatalf("expected CORS header")
 	}
 }
 
+func TestServeSegment_AnswersSegmentsThatWillNeverExist(t *testing.T) {
+	cases := []struct {
+		name     string
+		playlist string
+		want     int
+	}{
+		{"slid out of live window", "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:5\n", http.StatusGone},
+		{"missing from ended playlist", "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-ENDLIST\n", http.StatusNotFound},
+		{"not written yet", "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:0\n", http.StatusAccepted},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			qDir := filepath.Join(root, "abc123", "128x128")
+			if err := os.MkdirAll(qDir, 0o755); err != nil {
+				t.Fatalf("mkdir: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(qDir, "index.m3u8"), []byte(tc.playlist), 0o644); err != nil {
+				t.Fatalf("write playlist: %v", err)
+			}
+
+			streams := stream.NewManager(5 * time.Minute)
+			streams.Register("abc123", time.Now())
+			h, err := NewHandler(config.Config{StreamsDir: root, StaticDir: root}, streams)
+			if err != nil {
+				t.Fatalf("NewHandler: %v", err)
+			}
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/stream/abc123/128x128/segment_00002.m4s", nil))
+			if rr.Code != tc.want {
+				t.Fatalf("expected %d, got %d", tc.want, rr.Code)
+			}
+		})
+	}
+}
+
 func TestServeSegment_ServesSegmentFile(t *testing.T) {
 	root := t.TempDir()
 	streamID := "abc123"
@@ -51,24 +94,233 @@ func TestServeSegment_ServesSegmentFile(t *testing.T) {
 	if err := os.MkdirAll(qDir, 0o755); err != nil {
 		t.Fatalf("mkdir: %v", err)
 	}
-	segPath := filepath.Join(qDir, "segment_00001.ts")
+	segPath := filepath.Join(qDir, "segment_00001.m4s")
 	if err := os.WriteFile(segPath, []byte("abc"), 0o644); err != nil {
 		t.Fatalf("write segment: %v", err)
 	}
 
-	h, err := NewHandler(config.Config{StreamsDir: root, StaticDir: root}, stream.NewManager(5*time.Minute))
+	streams := stream.NewManager(5 * time.Minute)
+	streams.Register(streamID, time.Now())
+	h, err := NewHandler(config.Config{StreamsDir: root, StaticDir: root}, streams)
 	if err != nil {
 		t.Fatalf("NewHandler: %v", err)
 	}
 
-	req := httptest.NewRequest(http.MethodGet, "/api/stream/"+streamID+"/"+quality+"/segment_00001.ts", nil)
+	req := httptest.NewRequest(http.MethodGet, "/api/stream/"+streamID+"/"+quality+"/segment_00001.m4s", nil)
 	rr := httptest.NewRecorder()
 	h.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusOK {
 		t.Fatalf("expected 200, got %d", rr.Code)
 	}
-	if rr.Header().Get("Content-Type") != "video/MP2T" {
+	if rr.Header().Get("Content-Type") != "video/mp4" {
 		t.Fatalf("unexpected content-type %q", rr.Header().Get("Content-Type"))
 	}
 }
+
+func TestServeMasterPlaylist_ListsEveryEncoder(t *testing.T) {
+	root := t.TempDir()
+	streamID := "abc123"
+	if err := os.MkdirAll(filepath.Join(root, streamID), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	streams := stream.NewManager(5 * time.Minute)
+	streams.Register(streamID, time.Now())
+	// Creating a stream records every tier of the ladder.
+	streams.SetQualities(streamID, []string{"64x64", "128x128", "256x256", "64x64-av1", "128x128-av1", "256x256-av1"})
+	cfg := config.Config{StreamsDir: root, StaticDir: root, VideoEncoders: []string{"h264", "av1"}}
+	h, err := NewHandler(cfg, streams)
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/stream/"+streamID+"/master.m3u8", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	body, _ := io.ReadAll(rr.Body)
+	s := string(body)
+	for _, want := range []string{
+		`CODECS="avc1.42E01E,mp4a.40.2"`,
+		`CODECS="av01.0.00M.08,mp4a.40.2"`,
+		"/api/stream/" + streamID + "/64x64/index.m3u8",
+		"/api/stream/" + streamID + "/64x64-av1/index.m3u8",
+	} {
+		if !strings.Contains(s, want) {
+			t.Fatalf("expected master playlist to contain %q, got:\n%s", want, s)
+		}
+	}
+}
+
+func TestServeMasterPlaylist_ListsOnlyTheStreamsTiers(t *testing.T) {
+	root := t.TempDir()
+	streamID := "abc123"
+	if err := os.MkdirAll(filepath.Join(root, streamID), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	streams := stream.NewManager(5 * time.Minute)
+	streams.Register(streamID, time.Now())
+	// As RecoverStreams leaves a stream whose largest tier didn't finish.
+	streams.SetQualities(streamID, []string{"64x64", "128x128"})
+	h, err := NewHandler(config.Config{StreamsDir: root, StaticDir: root}, streams)
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/stream/"+streamID+"/master.m3u8", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, "/128x128/index.m3u8") || strings.Contains(body, "/256x256/index.m3u8") {
+		t.Fatalf("expected only the stream's tiers, got:\n%s", body)
+	}
+}
+
+func TestServeMasterPlaylist_FlagsLiveStreams(t *testing.T) {
+	root := t.TempDir()
+	streams := stream.NewManager(5 * time.Minute)
+	s, err := streams.Create(time.Now())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	streams.SetLive(s.ID, true)
+	if err := os.MkdirAll(filepath.Join(root, s.ID), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	h, err := NewHandler(config.Config{StreamsDir: root, StaticDir: root}, streams)
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/stream/"+s.ID+"/master.m3u8", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `DATA-ID="com.blobtube.live",VALUE="true"`) {
+		t.Fatalf("expected live session data, got:\n%s", rr.Body.String())
+	}
+}
+
+func TestServeSubtitle_ServesRenditionAndListsItInMaster(t *testing.T) {
+	root := t.TempDir()
+	streams := stream.NewManager(5 * time.Minute)
+	s, err := streams.Create(time.Now())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	streams.SetSubtitles(s.ID, []string{"en"})
+	dir := filepath.Join(root, s.ID, "subs", "en")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "segment_00000.vtt"), []byte("WEBVTT\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	h, err := NewHandler(config.Config{StreamsDir: root, StaticDir: root}, streams)
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/stream/"+s.ID+"/subs/en/segment_00000.vtt", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/vtt") {
+		t.Fatalf("expected text/vtt, got %q", ct)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/stream/"+s.ID+"/subs/../segment_00000.vtt", nil)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code == http.StatusOK {
+		t.Fatalf("expected traversal to be rejected")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/stream/"+s.ID+"/master.m3u8", nil)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, "TYPE=SUBTITLES") || !strings.Contains(body, "/api/stream/"+s.ID+"/subs/en/index.m3u8") {
+		t.Fatalf("expected subtitle rendition, got:\n%s", body)
+	}
+}
+
+func TestServeStoryboard_ServesSheetsAndRejectsOtherFiles(t *testing.T) {
+	root := t.TempDir()
+	streams := stream.NewManager(5 * time.Minute)
+	s, err := streams.Create(time.Now())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	dir := filepath.Join(root, s.ID, "preview")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	for _, name := range []string{"storyboard_000.jpg", "thumbnail.jpg"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("jpeg"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	h, err := NewHandler(config.Config{StreamsDir: root, StaticDir: root}, streams)
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+
+	for path, want := range map[string]int{
+		"/thumbnail.jpg":                 http.StatusOK,
+		"/storyboard/storyboard_000.jpg": http.StatusOK,
+		"/storyboard/storyboard.vtt":     http.StatusNotFound,
+		"/storyboard/thumbnail.jpg":      http.StatusBadRequest,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/stream/"+s.ID+path, nil)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Fatalf("%s: expected %d, got %d", path, want, rr.Code)
+		}
+		if want == http.StatusOK && rr.Header().Get("Content-Type") != "image/jpeg" {
+			t.Fatalf("%s: expected image/jpeg, got %q", path, rr.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestServeMasterPlaylist_404ForUnknownStreamWithDir(t *testing.T) {
+	root := t.TempDir()
+	streamID := "abc123"
+	if err := os.MkdirAll(filepath.Join(root, streamID), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	streams := stream.NewManager(5 * time.Minute)
+	h, err := NewHandler(config.Config{StreamsDir: root, StaticDir: root}, streams)
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/stream/"+streamID+"/master.m3u8", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rr.Code)
+	}
+	if _, ok := streams.Get(streamID); ok {
+		t.Fatalf("expected the leftover dir not to register a stream")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/go-chi/chi/v5"
 
 	"github.com/sixfeetup/blobtube/internal/stream"
+	"github.com/sixfeetup/blobtube/internal/transcode"
 )
 
 type streamStatusResponse struct {
@@ -17,10 +18,31 @@ type streamStatusResponse struct {
 	CreatedAt                time.Time `json:"created_at"`
 	LastAccess               time.Time `json:"last_access"`
 	InactivityTimeoutSeconds int       `json:"inactivity_timeout_seconds"`
-	Error                    string    `json:"error,omitempty"`
+	Live                     bool      `json:"live"`
+	Subtitles                []string  `json:"subtitles"`
+	// Metadata is omitted until yt-dlp has described the source.
+	Metadata *stream.Metadata `json:"metadata,omitempty"`
+	// MasterURL is where players load the stream, signed when playback
+	// URLs are.
+	MasterURL     string `json:"master_url"`
+	Thumbnail     bool   `json:"thumbnail"`
+	ThumbnailURL  string `json:"thumbnail_url,omitempty"`
+	StoryboardURL string `json:"storyboard_url,omitempty"`
+	ErrorCode     string `json:"error_code,omitempty"`
+	Error         string `json:"error,omitempty"`
 }
 
-func serveStreamStatus(streams *stream.Manager) http.HandlerFunc {
+func serveStreamStatus(streams *stream.Manager, pb *playback) http.HandlerFunc {
+	return serveStreamResource(streams, pb, true)
+}
+
+// serveStream is GET /api/stream/{id}: the same representation as /status,
+// for API clients. Unlike polling /status it doesn't count as viewing.
+func serveStream(streams *stream.Manager, pb *playback) http.HandlerFunc {
+	return serveStreamResource(streams, pb, false)
+}
+
+func serveStreamResource(streams *stream.Manager, pb *playback, touch bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if streams == nil {
 			http.Error(w, "stream manager not configured", http.StatusServiceUnavailable)
@@ -38,21 +60,45 @@ func serveStreamStatus(streams *stream.Manager) http.HandlerFunc {
 			http.NotFound(w, r)
 			return
 		}
-		// Status checks count as activity.
-		_ = streams.Touch(id, time.Now())
-
-		resp := streamStatusResponse{
-			ID:                       s.ID,
-			Qualities:                append([]string(nil), s.Qualities...),
-			State:                    string(s.State),
-			CreatedAt:                s.CreatedAt,
-			LastAccess:               s.LastAccess,
-			InactivityTimeoutSeconds: int(streams.InactivityTimeout().Seconds()),
-			Error:                    s.Error,
+		if touch {
+			// Status checks count as activity.
+			_ = streams.Touch(id, time.Now())
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		setCORSHeaders(w)
+		resp := newStreamStatusResponse(s, streams.InactivityTimeout())
+		resp.MasterURL = pb.signURL(r, s.ID, resp.MasterURL)
+		if resp.ThumbnailURL != "" {
+			resp.ThumbnailURL = pb.signURL(r, s.ID, resp.ThumbnailURL)
+		}
+		if resp.StoryboardURL != "" {
+			resp.StoryboardURL = pb.signURL(r, s.ID, resp.StoryboardURL)
+		}
 		_ = json.NewEncoder(w).Encode(resp)
 	}
 }
+
+func newStreamStatusResponse(s stream.Stream, inactivityTimeout time.Duration) streamStatusResponse {
+	resp := streamStatusResponse{
+		ID:                       s.ID,
+		Qualities:                append([]string(nil), s.Qualities...),
+		State:                    string(s.State),
+		CreatedAt:                s.CreatedAt,
+		LastAccess:               s.LastAccess,
+		InactivityTimeoutSeconds: int(inactivityTimeout.Seconds()),
+		Live:                     s.Live,
+		Subtitles:                append([]string{}, s.Subtitles...),
+		Metadata:                 s.Metadata,
+		MasterURL:                "/api/stream/" + s.ID + "/master.m3u8",
+		Thumbnail:                s.Thumbnail,
+		ErrorCode:                string(s.ErrorCode),
+		Error:                    s.Error,
+	}
+	if s.Thumbnail {
+		resp.ThumbnailURL = "/api/stream/" + s.ID + "/thumbnail.jpg"
+	}
+	if s.Storyboard {
+		resp.StoryboardURL = "/api/stream/" + s.ID + "/storyboard/" + transcode.StoryboardTrack
+	}
+	return resp
+}
@@ -1,13 +1,19 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/sixfeetup/blobtube/internal/config"
 	"github.com/sixfeetup/blobtube/internal/stream"
+	"github.com/sixfeetup/blobtube/internal/transcode"
 )
 
 func TestServeStreamStatus_404WhenUnknown(t *testing.T) {
@@ -38,3 +44,120 @@ func TestServeStreamStatus_200WhenKnown(t *testing.T) {
 		t.Fatalf("expected 200, got %d", rr.Code)
 	}
 }
+
+func TestServeStreamStatus_ReportsErrorCode(t *testing.T) {
+	mgr := stream.NewManager(5 * time.Minute)
+	_, _ = mgr.Register("abc", time.Unix(0, 0))
+
+	orch := &StreamOrchestrator{streams: mgr}
+	orch.failStream("abc", fmt.Errorf("%w: ERROR: [youtube] abc: secret stderr", transcode.ErrRegionLocked), stream.ErrCodeExtractionFailed)
+
+	h, err := NewHandler(config.Config{StaticDir: t.TempDir()}, mgr)
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/stream/abc/status", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	var resp streamStatusResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.State != string(stream.StateError) || resp.ErrorCode != "region_locked" {
+		t.Fatalf("unexpected state/code %q/%q", resp.State, resp.ErrorCode)
+	}
+	if strings.Contains(resp.Error, "stderr") {
+		t.Fatalf("expected raw stderr to stay out of the response, got %q", resp.Error)
+	}
+}
+
+func TestFailureCode_FallsBackForUnknownErrors(t *testing.T) {
+	if got := failureCode(errors.New("yt-dlp failed: boom"), stream.ErrCodeExtractionFailed); got != stream.ErrCodeExtractionFailed {
+		t.Fatalf("expected fallback code, got %q", got)
+	}
+	if got := failureCode(fmt.Errorf("mkdir: %w", syscall.ENOSPC), stream.ErrCodeInternal); got != stream.ErrCodeDiskFull {
+		t.Fatalf("expected disk_full, got %q", got)
+	}
+}
+
+func TestServeStreamStatus_LinksPreviews(t *testing.T) {
+	mgr := stream.NewManager(5 * time.Minute)
+	_, _ = mgr.Register("abc", time.Unix(0, 0))
+	mgr.SetPreviews("abc", true, true)
+
+	h, err := NewHandler(config.Config{StaticDir: t.TempDir()}, mgr)
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/stream/abc/status", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	var resp streamStatusResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.ThumbnailURL != "/api/stream/abc/thumbnail.jpg" || resp.StoryboardURL != "/api/stream/abc/storyboard/storyboard.vtt" {
+		t.Fatalf("unexpected preview urls: %q, %q", resp.ThumbnailURL, resp.StoryboardURL)
+	}
+}
+
+func TestServeStream_ReturnsMetadataWithoutTouching(t *testing.T) {
+	mgr := stream.NewManager(5 * time.Minute)
+	_, _ = mgr.Register("abc", time.Unix(0, 0))
+	mgr.SetMetadata("abc", stream.Metadata{Title: "A video", Channel: "A channel", DurationSeconds: 7200, EffectiveDurationSeconds: 3600})
+
+	h, err := NewHandler(config.Config{StaticDir: t.TempDir()}, mgr)
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/stream/abc", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+
+	var resp streamStatusResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Metadata == nil || resp.Metadata.Title != "A video" || resp.Metadata.EffectiveDurationSeconds != 3600 {
+		t.Fatalf("unexpected metadata: %+v", resp.Metadata)
+	}
+	if s, _ := mgr.Get("abc"); !s.LastAccess.Equal(time.Unix(0, 0)) {
+		t.Fatalf("expected GET /api/stream/{id} not to count as activity")
+	}
+}
+
+func TestRateLimits_SeparatePerRouteGroup(t *testing.T) {
+	streams := stream.NewManager(time.Minute)
+	streams.Register("abc", time.Now())
+	root := t.TempDir()
+	cfg := config.Config{
+		StreamsDir:        root,
+		StaticDir:         root,
+		StatusRateLimit:   config.RateLimit{PerMinute: 1, Burst: 1},
+		SegmentsRateLimit: config.RateLimit{PerMinute: 1, Burst: 1},
+	}
+	h, err := NewHandler(cfg, streams)
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+
+	fetch := func(path string) int {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr.Code
+	}
+	if code := fetch("/api/stream/abc/status"); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if code := fetch("/api/stream/abc/status"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected the second poll to be limited, got %d", code)
+	}
+	if code := fetch("/api/stream/abc/master.m3u8"); code == http.StatusTooManyRequests {
+		t.Fatalf("expected playback to have its own bucket")
+	}
+}
@@ -0,0 +1,194 @@
+// Package auth checks API keys for the stream API and enforces each key's
+// quotas. Keys are stored hashed: the key file holds SHA-256 digests and
+// labels, never the keys themselves.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrConcurrentLimit = errors.New("concurrent stream limit reached")
+	ErrDailyLimit      = errors.New("daily stream limit reached")
+)
+
+// Key is one entry of the key file. Zero limits are unlimited.
+type Key struct {
+	Label string `json:"label"`
+	// SHA256 is the hex digest of the key, e.g. from
+	// `printf %s "$KEY" | sha256sum`.
+	SHA256             string `json:"sha256"`
+	MaxConcurrent      int    `json:"max_concurrent"`
+	MaxPerDay          int    `json:"max_per_day"`
+	MaxDurationSeconds int    `json:"max_duration_seconds"`
+}
+
+// usage is a key's live stream count and today's (UTC) creations.
+type usage struct {
+	inFlight int
+	day      string
+	today    int
+}
+
+// Keyring holds the configured keys and their usage. Usage lives in memory
+// and starts over on restart.
+type Keyring struct {
+	byHash map[[sha256.Size]byte]Key
+
+	mu    sync.Mutex
+	usage map[string]*usage
+	now   func() time.Time
+}
+
+// LoadKeyring reads a JSON array of Keys.
+func LoadKeyring(path string) (*Keyring, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+	var keys []Key
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, fmt.Errorf("parse key file: %w", err)
+	}
+	return NewKeyring(keys)
+}
+
+func NewKeyring(keys []Key) (*Keyring, error) {
+	k := &Keyring{
+		byHash: make(map[[sha256.Size]byte]Key, len(keys)),
+		usage:  map[string]*usage{},
+		now:    time.Now,
+	}
+	labels := map[string]bool{}
+	for _, key := range keys {
+		if key.Label == "" {
+			return nil, errors.New("key label is required")
+		}
+		if labels[key.Label] {
+			return nil, fmt.Errorf("duplicate key label %q", key.Label)
+		}
+		labels[key.Label] = true
+
+		raw, err := hex.DecodeString(strings.TrimSpace(key.SHA256))
+		if err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("key %q: sha256 must be 64 hex characters", key.Label)
+		}
+		k.byHash[[sha256.Size]byte(raw)] = key
+	}
+	return k, nil
+}
+
+// Lookup finds the key matching a presented secret.
+func (k *Keyring) Lookup(secret string) (Key, bool) {
+	if secret == "" {
+		return Key{}, false
+	}
+	key, ok := k.byHash[sha256.Sum256([]byte(secret))]
+	return key, ok
+}
+
+// Acquire counts a new stream against a key's limits. Every successful
+// Acquire must be paired with a Release once the stream stops working.
+func (k *Keyring) Acquire(key Key) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	u := k.usage[key.Label]
+	if u == nil {
+		u = &usage{}
+		k.usage[key.Label] = u
+	}
+	if day := k.now().UTC().Format(time.DateOnly); u.day != day {
+		u.day, u.today = day, 0
+	}
+	if key.MaxConcurrent > 0 && u.inFlight >= key.MaxConcurrent {
+		return ErrConcurrentLimit
+	}
+	if key.MaxPerDay > 0 && u.today >= key.MaxPerDay {
+		return ErrDailyLimit
+	}
+	u.inFlight++
+	u.today++
+	return nil
+}
+
+// Release ends a stream counted by Acquire.
+func (k *Keyring) Release(key Key) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if u := k.usage[key.Label]; u != nil && u.inFlight > 0 {
+		u.inFlight--
+	}
+}
+
+type ctxKey struct{}
+
+// FromContext returns the key authenticated by Middleware, if any.
+func FromContext(ctx context.Context) (Key, bool) {
+	key, ok := ctx.Value(ctxKey{}).(Key)
+	return key, ok
+}
+
+// Secret reads the presented key from "Authorization: Bearer" or
+// X-API-Key.
+func Secret(r *http.Request) string {
+	if v := r.Header.Get("X-API-Key"); v != "" {
+		return v
+	}
+	if v, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(v)
+	}
+	return ""
+}
+
+// Middleware identifies the caller. A request with a key that doesn't
+// match is rejected; one without a key continues anonymously, for routes
+// that allow it. A nil keyring disables authentication.
+func Middleware(k *Keyring) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if k == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			secret := Secret(r)
+			if secret == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key, ok := k.Lookup(secret)
+			if !ok {
+				http.Error(w, `{"error":"invalid api key"}`, http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, key)))
+		})
+	}
+}
+
+// Require rejects anonymous requests; it runs after Middleware. A nil
+// keyring disables it.
+func Require(k *Keyring) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if k == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := FromContext(r.Context()); !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="blobtube"`)
+				http.Error(w, `{"error":"api key required"}`, http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func hashOf(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestLoadKeyring_LooksUpHashedKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	body := `[{"label":"app","sha256":"` + hashOf("secret-1") + `","max_concurrent":2}]`
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	k, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("LoadKeyring: %v", err)
+	}
+	key, ok := k.Lookup("secret-1")
+	if !ok || key.Label != "app" || key.MaxConcurrent != 2 {
+		t.Fatalf("unexpected lookup: %+v, %v", key, ok)
+	}
+	if _, ok := k.Lookup(hashOf("secret-1")); ok {
+		t.Fatalf("the hash itself must not authenticate")
+	}
+}
+
+func TestNewKeyring_RejectsBadEntries(t *testing.T) {
+	for name, keys := range map[string][]Key{
+		"no label":  {{SHA256: hashOf("a")}},
+		"bad hash":  {{Label: "a", SHA256: "abc"}},
+		"duplicate": {{Label: "a", SHA256: hashOf("a")}, {Label: "a", SHA256: hashOf("b")}},
+	} {
+		if _, err := NewKeyring(keys); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestKeyring_Acquire_EnforcesConcurrentAndDailyLimits(t *testing.T) {
+	k, err := NewKeyring(nil)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	now := time.Date(2026, 1, 1, 23, 0, 0, 0, time.UTC)
+	k.now = func() time.Time { return now }
+	key := Key{Label: "app", MaxConcurrent: 1, MaxPerDay: 2}
+
+	if err := k.Acquire(key); err != nil {
+		t.Fatalf("first acquire: %v", err)
+	}
+	if err := k.Acquire(key); !errors.Is(err, ErrConcurrentLimit) {
+		t.Fatalf("expected concurrent limit, got %v", err)
+	}
+	k.Release(key)
+	if err := k.Acquire(key); err != nil {
+		t.Fatalf("second acquire: %v", err)
+	}
+	k.Release(key)
+	if err := k.Acquire(key); !errors.Is(err, ErrDailyLimit) {
+		t.Fatalf("expected daily limit, got %v", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err := k.Acquire(key); err != nil {
+		t.Fatalf("expected a new day to reset the count, got %v", err)
+	}
+}
+
+func TestMiddleware_IdentifiesAndRejects(t *testing.T) {
+	k, err := NewKeyring([]Key{{Label: "app", SHA256: hashOf("secret")}})
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	var label string
+	h := Middleware(k)(Require(k)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, _ := FromContext(r.Context())
+		label = key.Label
+	})))
+
+	for _, tc := range []struct {
+		header, value string
+		want          int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"Authorization", "Bearer nope", http.StatusUnauthorized},
+		{"Authorization", "Bearer secret", http.StatusOK},
+		{"X-API-Key", "secret", http.StatusOK},
+	} {
+		label = ""
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != tc.want {
+			t.Fatalf("%s %q: expected %d, got %d", tc.header, tc.value, tc.want, rr.Code)
+		}
+		if tc.want == http.StatusOK && label != "app" {
+			t.Fatalf("expected key in context, got %q", label)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTokenInvalid = errors.New("invalid playback token")
+	ErrTokenExpired = errors.New("playback token expired")
+)
+
+// minSecretBytes keeps HMAC keys out of brute-force range.
+const minSecretBytes = 16
+
+// SigningKey is one HMAC key of the URL signing ring. The ID travels in
+// every token so verification finds the right key after a rotation.
+type SigningKey struct {
+	ID     string
+	Secret []byte
+}
+
+// ParseSigningKeys parses "id:secret" entries, the URL_SIGNING_KEYS format.
+func ParseSigningKeys(entries []string) ([]SigningKey, error) {
+	keys := make([]SigningKey, 0, len(entries))
+	for _, e := range entries {
+		id, secret, ok := strings.Cut(strings.TrimSpace(e), ":")
+		if !ok {
+			return nil, fmt.Errorf("signing key %q: want id:secret", id)
+		}
+		keys = append(keys, SigningKey{ID: id, Secret: []byte(secret)})
+	}
+	return keys, nil
+}
+
+// Signer issues and checks expiring playback tokens bound to one stream
+// and, optionally, one client. The first key signs; every key verifies,
+// so a new key can be put first while old links keep working until the
+// retired key is dropped.
+type Signer struct {
+	keys []SigningKey
+	ttl  time.Duration
+	now  func() time.Time
+}
+
+func NewSigner(keys []SigningKey, ttl time.Duration) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one signing key is required")
+	}
+	if ttl <= 0 {
+		return nil, errors.New("token ttl must be > 0")
+	}
+	seen := map[string]bool{}
+	for _, k := range keys {
+		if k.ID == "" || strings.ContainsAny(k.ID, ".:") {
+			return nil, fmt.Errorf("signing key id %q must be non-empty without '.' or ':'", k.ID)
+		}
+		if seen[k.ID] {
+			return nil, fmt.Errorf("duplicate signing key id %q", k.ID)
+		}
+		seen[k.ID] = true
+		if len(k.Secret) < minSecretBytes {
+			return nil, fmt.Errorf("signing key %q: secret must be at least %d bytes", k.ID, minSecretBytes)
+		}
+	}
+	return &Signer{keys: append([]SigningKey(nil), keys...), ttl: ttl, now: time.Now}, nil
+}
+
+// Sign returns a token for streamID, formatted "<key id>.<expiry>.<mac>".
+// binding ties it to a client, e.g. its IP; empty means any client.
+func (s *Signer) Sign(streamID, binding string) string {
+	k := s.keys[0]
+	exp := strconv.FormatInt(s.now().Add(s.ttl).Unix(), 10)
+	return k.ID + "." + exp + "." + mac(k.Secret, streamID, exp, binding)
+}
+
+// Verify checks a token against the stream and client it must be for.
+func (s *Signer) Verify(token, streamID, binding string) error {
+	id, rest, ok := strings.Cut(token, ".")
+	if !ok {
+		return ErrTokenInvalid
+	}
+	exp, sig, ok := strings.Cut(rest, ".")
+	if !ok {
+		return ErrTokenInvalid
+	}
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return ErrTokenInvalid
+	}
+	for _, k := range s.keys {
+		if k.ID != id {
+			continue
+		}
+		if !hmac.Equal([]byte(sig), []byte(mac(k.Secret, streamID, exp, binding))) {
+			return ErrTokenInvalid
+		}
+		if !s.now().Before(time.Unix(expUnix, 0)) {
+			return ErrTokenExpired
+		}
+		return nil
+	}
+	return ErrTokenInvalid
+}
+
+func mac(secret []byte, streamID, exp, binding string) string {
+	h := hmac.New(sha256.New, secret)
+	fmt.Fprintf(h, "v1\n%s\n%s\n%s", streamID, exp, binding)
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
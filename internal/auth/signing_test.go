@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSigner(t *testing.T, keys ...SigningKey) *Signer {
+	t.Helper()
+	s, err := NewSigner(keys, time.Hour)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	return s
+}
+
+var (
+	keyA = SigningKey{ID: "a", Secret: []byte("0123456789abcdef")}
+	keyB = SigningKey{ID: "b", Secret: []byte("fedcba9876543210")}
+)
+
+func TestSigner_VerifiesOwnTokens(t *testing.T) {
+	s := testSigner(t, keyA)
+	token := s.Sign("stream1", "")
+	if !strings.HasPrefix(token, "a.") {
+		t.Fatalf("expected the key id first, got %q", token)
+	}
+	if err := s.Verify(token, "stream1", ""); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := s.Verify(token, "stream2", ""); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("expected ErrTokenInvalid for another stream, got %v", err)
+	}
+	if err := s.Verify(token+"x", "stream1", ""); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("expected ErrTokenInvalid for a tampered mac, got %v", err)
+	}
+	for _, bad := range []string{"", "a", "a.b", "a.notanumber.mac", "z.1.mac"} {
+		if err := s.Verify(bad, "stream1", ""); !errors.Is(err, ErrTokenInvalid) {
+			t.Fatalf("%q: expected ErrTokenInvalid, got %v", bad, err)
+		}
+	}
+}
+
+func TestSigner_Expires(t *testing.T) {
+	s := testSigner(t, keyA)
+	now := time.Unix(1_700_000_000, 0)
+	s.now = func() time.Time { return now }
+	token := s.Sign("stream1", "")
+
+	now = now.Add(time.Hour - time.Second)
+	if err := s.Verify(token, "stream1", ""); err != nil {
+		t.Fatalf("expected valid before expiry, got %v", err)
+	}
+	now = now.Add(time.Second)
+	if err := s.Verify(token, "stream1", ""); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("expected ErrTokenExpired, got %v", err)
+	}
+}
+
+func TestSigner_Binding(t *testing.T) {
+	s := testSigner(t, keyA)
+	token := s.Sign("stream1", "203.0.113.7")
+	if err := s.Verify(token, "stream1", "203.0.113.7"); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := s.Verify(token, "stream1", "198.51.100.1"); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("expected another client to be rejected, got %v", err)
+	}
+}
+
+func TestSigner_Rotation(t *testing.T) {
+	old := testSigner(t, keyA)
+	token := old.Sign("stream1", "")
+
+	rotated := testSigner(t, keyB, keyA)
+	if err := rotated.Verify(token, "stream1", ""); err != nil {
+		t.Fatalf("expected tokens of the retiring key to verify, got %v", err)
+	}
+	if got := rotated.Sign("stream1", ""); !strings.HasPrefix(got, "b.") {
+		t.Fatalf("expected the first key to sign, got %q", got)
+	}
+
+	retired := testSigner(t, keyB)
+	if err := retired.Verify(token, "stream1", ""); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("expected a dropped key's tokens to fail, got %v", err)
+	}
+}
+
+func TestNewSigner_RejectsBadKeys(t *testing.T) {
+	for _, keys := range [][]SigningKey{
+		nil,
+		{{ID: "", Secret: keyA.Secret}},
+		{{ID: "a.b", Secret: keyA.Secret}},
+		{{ID: "a", Secret: []byte("short")}},
+		{keyA, keyA},
+	} {
+		if _, err := NewSigner(keys, time.Hour); err == nil {
+			t.Fatalf("expected %+v to be rejected", keys)
+		}
+	}
+}
+
+func TestParseSigningKeys(t *testing.T) {
+	keys, err := ParseSigningKeys([]string{"k1:0123456789abcdef", " k2:secret:with:colons "})
+	if err != nil {
+		t.Fatalf("ParseSigningKeys: %v", err)
+	}
+	if len(keys) != 2 || keys[0].ID != "k1" || keys[1].ID != "k2" || string(keys[1].Secret) != "secret:with:colons" {
+		t.Fatalf("unexpected keys: %+v", keys)
+	}
+	if _, err := ParseSigningKeys([]string{"nosecret"}); err == nil {
+		t.Fatalf("expected an entry without a secret to fail")
+	}
+}
@@ -3,6 +3,10 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sixfeetup/blobtube/internal/transcode"
 )
 
 type Config struct {
@@ -14,9 +18,130 @@ type Config struct {
 
 	LogLevel string
 	DevMode  bool
+	// LogSampleSegments logs one in this many successful segment fetches;
+	// one logs them all.
+	LogSampleSegments int
+	// StderrLogBytes is how much of each ffmpeg and yt-dlp process's
+	// stderr is kept per stream for the admin API; zero disables capture.
+	StderrLogBytes int
 
 	YtDLPPath  string
 	StreamsDir string
+	// CompletedRetention is how long a completed stream's output stays in
+	// StreamsDir after it was last played, restarts included.
+	CompletedRetention time.Duration
+
+	// YtDLPCacheSize enables the yt-dlp metadata cache when positive,
+	// independently of DevMode (which always enables a small one).
+	// Permanent failures are cached for YtDLPCacheNegativeTTL.
+	YtDLPCacheSize        int
+	YtDLPCacheTTL         time.Duration
+	YtDLPCacheNegativeTTL time.Duration
+
+	// YtDLPUpdate is "off", "self" (yt-dlp -U) or "release" (download
+	// YtDLPReleaseURL to YtDLPManagedPath, which then replaces YtDLPPath),
+	// run every YtDLPUpdateInterval. A release download must match the
+	// SHA2-256SUMS file published beside it.
+	YtDLPUpdate         string
+	YtDLPUpdateInterval time.Duration
+	YtDLPReleaseURL     string
+	YtDLPManagedPath    string
+
+	// Passed to every yt-dlp call. YtDLPCookiesFile is a credential and is
+	// never logged. Requests may choose a proxy from YtDLPProxyAllowlist.
+	YtDLPCookiesFile      string
+	YtDLPProxy            string
+	YtDLPProxyAllowlist   []string
+	YtDLPGeoBypassCountry string
+	YtDLPExtractorArgs    []string
+
+	// VideoEncoders lists the codecs each stream is encoded with, in
+	// master playlist order. The first one keeps the plain tier names.
+	VideoEncoders []string
+	// RateControl overrides every tier's rate-control mode: capped_crf,
+	// cbr or two_pass. Two-pass downloads each VOD source in full before
+	// encoding it; live relays fall back to capped CRF.
+	RateControl string
+
+	// MaxStreamDuration caps how much of a source is transcoded (ADR-014).
+	// Sources longer than MaxSourceDuration are rejected rather than
+	// truncated; zero disables that and the MaxSourceBytes check.
+	MaxStreamDuration    int
+	MaxSourceDuration    int
+	MaxSourceBytes       int64
+	AllowAgeRestricted   bool
+	AllowUnknownDuration bool
+
+	// LiveEnabled relays live broadcasts with a sliding window of
+	// LiveWindowSegments segments instead of rejecting them.
+	LiveEnabled        bool
+	LiveWindowSegments int
+
+	// SubtitleLanguages are fetched for every stream unless the request
+	// names its own; empty, the default, disables subtitles. Fetching
+	// them delays the start of the transcode.
+	SubtitleLanguages []string
+
+	// APIKeysFile enables API keys for the stream API when set. Without
+	// PublicPlayback, playlists and segments also need the owner's key.
+	APIKeysFile    string
+	PublicPlayback bool
+
+	// SignedURLs appends expiring HMAC tokens to every playback URL and
+	// requires them. The first of URLSigningKeys ("id:secret") signs, all
+	// verify; at least one is required. URLSigningBindIP ties tokens to
+	// the client IP they were issued to.
+	SignedURLs       bool
+	URLSigningKeys   []string
+	URLSigningTTL    time.Duration
+	URLSigningBindIP bool
+
+	// TrustedProxies are the CIDRs whose X-Forwarded-For is believed when
+	// working out a client's IP; from anyone else it's ignored.
+	TrustedProxies []string
+
+	// Per-client-IP request limits: a sustained rate per minute and the
+	// burst allowed on top. A zero rate disables the limit.
+	CreateRateLimit   RateLimit
+	StatusRateLimit   RateLimit
+	SegmentsRateLimit RateLimit
+
+	// CORSOrigins are the sites allowed to call the stream API and embed
+	// its playback from a browser: exact origins, or "*" for any. Empty
+	// allows same-origin use only. CORSHeaders are the request headers
+	// they may send.
+	CORSOrigins []string
+	CORSHeaders []string
+
+	// AdminAddr enables the admin API on its own listener, never the
+	// public one. Requests must carry AdminToken as a bearer token.
+	AdminAddr  string
+	AdminToken string
+	// AdminCORSOrigins are the exact origins of browser admin consoles.
+	AdminCORSOrigins []string
+
+	// MinFreeBytes is the free space StreamsDir needs for /health/ready to
+	// pass. ReadyMaxInFlight fails readiness once that many streams are
+	// transcoding; zero disables it, since failing readiness also routes
+	// existing viewers away.
+	MinFreeBytes     int64
+	ReadyMaxInFlight int
+
+	// ShutdownGrace is how long in-flight transcodes get to finish on
+	// shutdown before they're canceled.
+	ShutdownGrace time.Duration
+
+	// OutputCacheDir enables the transcoded output cache (ADR-015) when
+	// set. OutputCacheMaxBytes bounds it; zero means unbounded.
+	OutputCacheDir      string
+	OutputCacheMaxBytes int64
+}
+
+// RateLimit is a token bucket: PerMinute requests a minute on average, in
+// bursts of up to Burst.
+type RateLimit struct {
+	PerMinute int
+	Burst     int
 }
 
 func FromEnv() Config {
@@ -33,6 +158,80 @@ func FromEnv() Config {
 		DevMode:     envBool("DEV_MODE", false),
 		YtDLPPath:   envString("YTDLP_PATH", "yt-dlp"),
 		StreamsDir:  envString("STREAMS_DIR", "/tmp/blobtube"),
+
+		CompletedRetention: time.Duration(envInt("COMPLETED_RETENTION_SECONDS", 3600)) * time.Second,
+
+		LogSampleSegments: envInt("LOG_SAMPLE_SEGMENTS", 10),
+		StderrLogBytes:    envInt("STDERR_LOG_BYTES", 16*1024),
+
+		YtDLPCacheSize:        envInt("YTDLP_CACHE_SIZE", 0),
+		YtDLPCacheTTL:         time.Duration(envInt("YTDLP_CACHE_TTL_SECONDS", 300)) * time.Second,
+		YtDLPCacheNegativeTTL: time.Duration(envInt("YTDLP_CACHE_NEGATIVE_TTL_SECONDS", 60)) * time.Second,
+
+		YtDLPUpdate:         envString("YTDLP_UPDATE", "off"),
+		YtDLPUpdateInterval: time.Duration(envInt("YTDLP_UPDATE_INTERVAL_HOURS", 24)) * time.Hour,
+		YtDLPReleaseURL:     envString("YTDLP_RELEASE_URL", transcode.DefaultYtDLPReleaseURL),
+		YtDLPManagedPath:    envString("YTDLP_MANAGED_PATH", "/tmp/blobtube-bin/yt-dlp"),
+
+		YtDLPCookiesFile:      envString("YTDLP_COOKIES_FILE", ""),
+		YtDLPProxy:            envString("YTDLP_PROXY", ""),
+		YtDLPProxyAllowlist:   envList("YTDLP_PROXY_ALLOWLIST", nil),
+		YtDLPGeoBypassCountry: envString("YTDLP_GEO_BYPASS_COUNTRY", ""),
+		// Extractor args contain commas themselves, so this list is
+		// semicolon-separated.
+		YtDLPExtractorArgs: envListSep("YTDLP_EXTRACTOR_ARGS", ";", nil),
+
+		VideoEncoders: envList("VIDEO_ENCODERS", []string{"h264"}),
+		RateControl:   envString("RATE_CONTROL", ""),
+
+		MaxStreamDuration:    envInt("MAX_STREAM_DURATION_SECONDS", 3600),
+		MaxSourceDuration:    envInt("MAX_SOURCE_DURATION_SECONDS", 4*3600),
+		MaxSourceBytes:       int64(envInt("MAX_SOURCE_BYTES", 0)),
+		AllowAgeRestricted:   envBool("ALLOW_AGE_RESTRICTED", false),
+		AllowUnknownDuration: envBool("ALLOW_UNKNOWN_DURATION", false),
+
+		LiveEnabled:        envBool("LIVE_ENABLED", true),
+		LiveWindowSegments: envInt("LIVE_WINDOW_SEGMENTS", 6),
+
+		SubtitleLanguages: envList("SUBTITLE_LANGS", nil),
+
+		APIKeysFile:    envString("API_KEYS_FILE", ""),
+		PublicPlayback: envBool("PUBLIC_PLAYBACK", true),
+
+		SignedURLs:       envBool("SIGNED_URLS", false),
+		URLSigningKeys:   envList("URL_SIGNING_KEYS", nil),
+		URLSigningTTL:    time.Duration(envInt("URL_SIGNING_TTL_SECONDS", 6*3600)) * time.Second,
+		URLSigningBindIP: envBool("URL_SIGNING_BIND_IP", false),
+
+		TrustedProxies: envList("TRUSTED_PROXIES", nil),
+
+		CreateRateLimit: RateLimit{
+			PerMinute: envInt("RATE_LIMIT_CREATE_PER_MINUTE", 6),
+			Burst:     envInt("RATE_LIMIT_CREATE_BURST", 3),
+		},
+		StatusRateLimit: RateLimit{
+			PerMinute: envInt("RATE_LIMIT_STATUS_PER_MINUTE", 120),
+			Burst:     envInt("RATE_LIMIT_STATUS_BURST", 20),
+		},
+		SegmentsRateLimit: RateLimit{
+			PerMinute: envInt("RATE_LIMIT_SEGMENTS_PER_MINUTE", 1200),
+			Burst:     envInt("RATE_LIMIT_SEGMENTS_BURST", 200),
+		},
+
+		CORSOrigins: envList("CORS_ORIGINS", nil),
+		CORSHeaders: envList("CORS_HEADERS", []string{"Authorization", "Content-Type", "X-API-Key", "Range"}),
+
+		AdminAddr:        envString("ADMIN_ADDR", ""),
+		AdminToken:       envString("ADMIN_TOKEN", ""),
+		AdminCORSOrigins: envList("ADMIN_CORS_ORIGINS", nil),
+
+		MinFreeBytes:     int64(envInt("MIN_FREE_DISK_BYTES", 1<<30)),
+		ReadyMaxInFlight: envInt("READY_MAX_IN_FLIGHT", 0),
+
+		ShutdownGrace: time.Duration(envInt("SHUTDOWN_GRACE_SECONDS", 30)) * time.Second,
+
+		OutputCacheDir:      envString("OUTPUT_CACHE_DIR", ""),
+		OutputCacheMaxBytes: int64(envInt("OUTPUT_CACHE_MAX_BYTES", 1<<30)),
 	}
 }
 
@@ -67,3 +266,24 @@ func envInt(key string, def int) int {
 	}
 	return n
 }
+
+func envList(key string, def []string) []string {
+	return envListSep(key, ",", def)
+}
+
+func envListSep(key, sep string, def []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	var out []string
+	for _, item := range strings.Split(v, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	if len(out) == 0 {
+		return def
+	}
+	return out
+}
@@ -1,6 +1,7 @@
 package hls
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"strconv"
@@ -10,17 +11,106 @@ import (
 )
 
 type Variant struct {
-	URI        string
-	Bandwidth  uint32
-	Resolution string
+	URI string
+	// Bandwidth is the peak segment bitrate; AverageBandwidth is optional.
+	Bandwidth        uint32
+	AverageBandwidth uint32
+	Resolution       string
+	Codecs           string
 }
 
-func BuildMasterPlaylist(variants []Variant) ([]byte, error) {
+// MasterOption adds optional tags to a master playlist.
+type MasterOption func(*masterOptions)
+
+type masterOptions struct {
+	sessionData sessionData
+	subtitles   []Rendition
+}
+
+// Rendition is an alternative media playlist, e.g. one subtitle language.
+type Rendition struct {
+	// Language is a BCP 47 tag; Name is what players show.
+	Language string
+	Name     string
+	URI      string
+}
+
+// subtitleGroup is the GROUP-ID every variant references for subtitles.
+const subtitleGroup = "subs"
+
+// WithSubtitles adds EXT-X-MEDIA:TYPE=SUBTITLES renditions available to
+// every variant.
+func WithSubtitles(renditions []Rendition) MasterOption {
+	return func(o *masterOptions) {
+		o.subtitles = append(o.subtitles, renditions...)
+	}
+}
+
+// WithSessionData adds an EXT-X-SESSION-DATA tag. IDs should use reverse
+// DNS notation, e.g. "com.blobtube.live".
+func WithSessionData(id, value string) MasterOption {
+	return func(o *masterOptions) {
+		o.sessionData = append(o.sessionData, [2]string{id, value})
+	}
+}
+
+// sessionData renders every EXT-X-SESSION-DATA tag as one m3u8.CustomTag,
+// since custom tags are keyed by tag name.
+type sessionData [][2]string
+
+func (sessionData) TagName() string { return "#EXT-X-SESSION-DATA:" }
+
+func (d sessionData) Encode() *bytes.Buffer {
+	if len(d) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	for i, kv := range d {
+		if i > 0 {
+			buf.WriteRune('\n')
+		}
+		fmt.Fprintf(&buf, "#EXT-X-SESSION-DATA:DATA-ID=%q,VALUE=%q", kv[0], kv[1])
+	}
+	return &buf
+}
+
+func (d sessionData) String() string {
+	if b := d.Encode(); b != nil {
+		return b.String()
+	}
+	return ""
+}
+
+func BuildMasterPlaylist(variants []Variant, opts ...MasterOption) ([]byte, error) {
 	if len(variants) == 0 {
 		return nil, fmt.Errorf("variants are required")
 	}
+	o := masterOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
 
 	mp := m3u8.NewMasterPlaylist()
+	mp.SetVersion(6)
+	mp.SetIndependentSegments(true)
+	if len(o.sessionData) > 0 {
+		mp.SetCustomTag(o.sessionData)
+	}
+
+	var subs []*m3u8.Alternative
+	for _, r := range o.subtitles {
+		if strings.TrimSpace(r.URI) == "" {
+			return nil, fmt.Errorf("rendition uri is required")
+		}
+		subs = append(subs, &m3u8.Alternative{
+			GroupId:    subtitleGroup,
+			Type:       "SUBTITLES",
+			Language:   r.Language,
+			Name:       r.Name,
+			URI:        r.URI,
+			Autoselect: "YES",
+		})
+	}
 	for _, v := range variants {
 		if strings.TrimSpace(v.URI) == "" {
 			return nil, fmt.Errorf("variant uri is required")
@@ -29,8 +119,14 @@ func BuildMasterPlaylist(variants []Variant) ([]byte, error) {
 			return nil, fmt.Errorf("variant bandwidth is required")
 		}
 		params := m3u8.VariantParams{
-			Bandwidth:  v.Bandwidth,
-			Resolution: v.Resolution,
+			Bandwidth:        v.Bandwidth,
+			AverageBandwidth: v.AverageBandwidth,
+			Resolution:       v.Resolution,
+			Codecs:           v.Codecs,
+		}
+		if len(subs) > 0 {
+			params.Alternatives = subs
+			params.Subtitles = subtitleGroup
 		}
 		mp.Append(v.URI, nil, params)
 	}
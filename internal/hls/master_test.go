@@ -35,3 +35,32 @@ func TestBuildMasterPlaylist(t *testing.T) {
 		t.Fatalf("expected 64x64 uri")
 	}
 }
+
+func TestBuildMasterPlaylist_SessionData(t *testing.T) {
+	b, err := BuildMasterPlaylist([]Variant{
+		{URI: "64x64/index.m3u8", Bandwidth: 50000, Resolution: "64x64"},
+	}, WithSessionData("com.blobtube.live", "true"))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if !strings.Contains(string(b), `#EXT-X-SESSION-DATA:DATA-ID="com.blobtube.live",VALUE="true"`) {
+		t.Fatalf("expected session data tag, got:\n%s", b)
+	}
+}
+
+func TestBuildMasterPlaylist_Subtitles(t *testing.T) {
+	b, err := BuildMasterPlaylist([]Variant{
+		{URI: "64x64/index.m3u8", Bandwidth: 50000, Resolution: "64x64"},
+		{URI: "128x128/index.m3u8", Bandwidth: 100000, Resolution: "128x128"},
+	}, WithSubtitles([]Rendition{{Language: "en", Name: "en", URI: "subs/en/index.m3u8"}}))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	s := string(b)
+	if n := strings.Count(s, "#EXT-X-MEDIA:TYPE=SUBTITLES"); n != 1 {
+		t.Fatalf("expected one subtitle rendition, got %d:\n%s", n, s)
+	}
+	if !strings.Contains(s, `URI="subs/en/index.m3u8"`) || strings.Count(s, `SUBTITLES="subs"`) != 2 {
+		t.Fatalf("expected every variant to reference the subtitle group:\n%s", s)
+	}
+}
@@ -1,8 +1,12 @@
 package hls
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/grafov/m3u8"
@@ -62,3 +66,22 @@ func (m *MediaPlaylist) WriteFile(path string) error {
 	}
 	return os.WriteFile(path, b, 0o644)
 }
+
+// MediaWindow reads the media sequence number of the first segment a media
+// playlist lists, and whether the playlist is closed with EXT-X-ENDLIST.
+// Segments numbered below first have slid out of a live window.
+func MediaWindow(b []byte) (first uint64, ended bool) {
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if v, ok := strings.CutPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"); ok {
+			if n, err := strconv.ParseUint(v, 10, 64); err == nil {
+				first = n
+			}
+		}
+		if line == "#EXT-X-ENDLIST" {
+			ended = true
+		}
+	}
+	return first, ended
+}
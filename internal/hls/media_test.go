@@ -23,3 +23,14 @@ func TestMediaPlaylist_AppendSegment(t *testing.T) {
 		t.Fatalf("expected segment uri")
 	}
 }
+
+func TestMediaWindow(t *testing.T) {
+	live := "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-MEDIA-SEQUENCE:42\n#EXTINF:4.0,\nsegment_00042.m4s\n"
+	if first, ended := MediaWindow([]byte(live)); first != 42 || ended {
+		t.Fatalf("expected window at 42, open; got %d, %v", first, ended)
+	}
+	vod := "#EXTM3U\n#EXTINF:4.0,\nsegment_00000.m4s\n#EXT-X-ENDLIST\n"
+	if first, ended := MediaWindow([]byte(vod)); first != 0 || !ended {
+		t.Fatalf("expected closed playlist from 0; got %d, %v", first, ended)
+	}
+}
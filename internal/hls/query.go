@@ -0,0 +1,48 @@
+package hls
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+var uriAttrRe = regexp.MustCompile(`URI="([^"]*)"`)
+
+// AppendQuery adds query (without "?") to every URI in a playlist: segment
+// lines and URI attributes such as EXT-X-MAP's. Relative URIs don't
+// inherit the playlist's query string, so signed playlists must carry the
+// signature onto each one.
+func AppendQuery(playlist []byte, query string) []byte {
+	if query == "" {
+		return playlist
+	}
+	lines := bytes.Split(playlist, []byte("\n"))
+	for i, line := range lines {
+		s := strings.TrimRight(string(line), "\r")
+		switch {
+		case s == "":
+		case strings.HasPrefix(s, "#"):
+			lines[i] = []byte(uriAttrRe.ReplaceAllStringFunc(s, func(m string) string {
+				uri := uriAttrRe.FindStringSubmatch(m)[1]
+				return `URI="` + WithQuery(uri, query) + `"`
+			}))
+		default:
+			lines[i] = []byte(WithQuery(s, query))
+		}
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
+
+// WithQuery appends query to uri, ahead of any fragment.
+func WithQuery(uri, query string) string {
+	base, frag, hasFrag := strings.Cut(uri, "#")
+	sep := "?"
+	if strings.Contains(base, "?") {
+		sep = "&"
+	}
+	base += sep + query
+	if hasFrag {
+		base += "#" + frag
+	}
+	return base
+}
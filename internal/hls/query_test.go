@@ -0,0 +1,26 @@
+package hls
+
+import "testing"
+
+func TestAppendQuery_SignsSegmentsAndMap(t *testing.T) {
+	in := "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:4.000,\nsegment_00000.m4s\n#EXT-X-ENDLIST\n"
+	want := "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-MAP:URI=\"init.mp4?token=t\"\n#EXTINF:4.000,\nsegment_00000.m4s?token=t\n#EXT-X-ENDLIST\n"
+	if got := string(AppendQuery([]byte(in), "token=t")); got != want {
+		t.Fatalf("unexpected playlist:\n%s", got)
+	}
+	if got := string(AppendQuery([]byte(in), "")); got != in {
+		t.Fatalf("expected an empty query to leave the playlist alone")
+	}
+}
+
+func TestWithQuery(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"a.jpg", "a.jpg?q=1"},
+		{"a.jpg?x=2", "a.jpg?x=2&q=1"},
+		{"a.jpg#xywh=0,0,64,64", "a.jpg?q=1#xywh=0,0,64,64"},
+	} {
+		if got := WithQuery(tc.in, "q=1"); got != tc.want {
+			t.Fatalf("WithQuery(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+// Storyboard is a grid of evenly spaced preview tiles split across sprite
+// sheets, read left to right, top to bottom.
+type Storyboard struct {
+	Interval   time.Duration
+	TileWidth  int
+	TileHeight int
+	Columns    int
+	Rows       int
+	// SheetURI names sheet i, relative to the thumbnails track.
+	SheetURI func(i int) string
+}
+
+// BuildThumbnailTrack renders the WebVTT thumbnails track players use for
+// scrub previews: one cue per tile, pointing into its sheet with a
+// #xywh= media fragment.
+func BuildThumbnailTrack(sb Storyboard, total time.Duration) ([]byte, error) {
+	if sb.Interval <= 0 || sb.TileWidth <= 0 || sb.TileHeight <= 0 || sb.Columns <= 0 || sb.Rows <= 0 {
+		return nil, fmt.Errorf("invalid storyboard geometry")
+	}
+	if sb.SheetURI == nil {
+		return nil, fmt.Errorf("sheet uri is required")
+	}
+	if total <= 0 {
+		return nil, fmt.Errorf("duration must be > 0")
+	}
+
+	perSheet := sb.Columns * sb.Rows
+	n := int(math.Ceil(float64(total) / float64(sb.Interval)))
+
+	var buf bytes.Buffer
+	buf.WriteString("WEBVTT\n")
+	for i := 0; i < n; i++ {
+		from, to := time.Duration(i)*sb.Interval, time.Duration(i+1)*sb.Interval
+		if to > total {
+			to = total
+		}
+		tile := i % perSheet
+		x := tile % sb.Columns * sb.TileWidth
+		y := tile / sb.Columns * sb.TileHeight
+		fmt.Fprintf(&buf, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			formatVTTTime(from), formatVTTTime(to), sb.SheetURI(i/perSheet), x, y, sb.TileWidth, sb.TileHeight)
+	}
+	return buf.Bytes(), nil
+}
+
+// MediaPlaylistDuration sums the segment durations of a media playlist.
+func MediaPlaylistDuration(b []byte) (time.Duration, error) {
+	pl, typ, err := m3u8.DecodeFrom(bytes.NewReader(b), false)
+	if err != nil {
+		return 0, err
+	}
+	if typ != m3u8.MEDIA {
+		return 0, fmt.Errorf("not a media playlist")
+	}
+	var secs float64
+	for _, seg := range pl.(*m3u8.MediaPlaylist).Segments {
+		if seg != nil {
+			secs += seg.Duration
+		}
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
@@ -0,0 +1,44 @@
+package hls
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildThumbnailTrack_WrapsAcrossSheets(t *testing.T) {
+	sb := Storyboard{
+		Interval:   10 * time.Second,
+		TileWidth:  64,
+		TileHeight: 64,
+		Columns:    2,
+		Rows:       2,
+		SheetURI:   func(i int) string { return fmt.Sprintf("sheet_%d.jpg", i) },
+	}
+	b, err := BuildThumbnailTrack(sb, 45*time.Second)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	s := string(b)
+	for _, want := range []string{
+		"00:00:00.000 --> 00:00:10.000\nsheet_0.jpg#xywh=0,0,64,64",
+		"00:00:30.000 --> 00:00:40.000\nsheet_0.jpg#xywh=64,64,64,64",
+		"00:00:40.000 --> 00:00:45.000\nsheet_1.jpg#xywh=0,0,64,64",
+	} {
+		if !strings.Contains(s, want) {
+			t.Fatalf("expected track to contain %q, got:\n%s", want, s)
+		}
+	}
+}
+
+func TestMediaPlaylistDuration(t *testing.T) {
+	pl := "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:4\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:4.000,\nsegment_00000.m4s\n#EXTINF:2.500,\nsegment_00001.m4s\n#EXT-X-ENDLIST\n"
+	d, err := MediaPlaylistDuration([]byte(pl))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if d != 6500*time.Millisecond {
+		t.Fatalf("expected 6.5s, got %v", d)
+	}
+}
@@ -0,0 +1,162 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+// Cue is one WebVTT cue. Times are relative to the start of the video.
+type Cue struct {
+	Start, End time.Duration
+	// Settings is whatever followed the end time, e.g. "align:start".
+	Settings string
+	Text     string
+}
+
+// ParseWebVTT returns the cues of a WebVTT file, skipping the header,
+// NOTE, STYLE and REGION blocks.
+func ParseWebVTT(b []byte) ([]Cue, error) {
+	b = bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))
+	if !bytes.HasPrefix(bytes.TrimPrefix(b, []byte("\xef\xbb\xbf")), []byte("WEBVTT")) {
+		return nil, fmt.Errorf("not a WebVTT file")
+	}
+
+	var cues []Cue
+	for _, block := range strings.Split(string(b), "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		// The timing line is first, or second after a cue identifier.
+		i := 0
+		if len(lines) > 1 && !strings.Contains(lines[0], "-->") {
+			i = 1
+		}
+		if i >= len(lines) || !strings.Contains(lines[i], "-->") {
+			continue
+		}
+		cue, err := parseTiming(lines[i])
+		if err != nil {
+			return nil, err
+		}
+		cue.Text = strings.Join(lines[i+1:], "\n")
+		cues = append(cues, cue)
+	}
+	return cues, nil
+}
+
+func parseTiming(line string) (Cue, error) {
+	start, rest, _ := strings.Cut(line, "-->")
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return Cue{}, fmt.Errorf("bad cue timing %q", line)
+	}
+	s, err := parseVTTTime(strings.TrimSpace(start))
+	if err != nil {
+		return Cue{}, err
+	}
+	e, err := parseVTTTime(fields[0])
+	if err != nil {
+		return Cue{}, err
+	}
+	return Cue{Start: s, End: e, Settings: strings.Join(fields[1:], " ")}, nil
+}
+
+// parseVTTTime parses hh:mm:ss.ttt or mm:ss.ttt.
+func parseVTTTime(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("bad cue time %q", s)
+	}
+	var d time.Duration
+	for _, p := range parts[:len(parts)-1] {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return 0, fmt.Errorf("bad cue time %q", s)
+		}
+		d = d*60 + time.Duration(n)
+	}
+	secs, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad cue time %q", s)
+	}
+	return d*60*time.Second + time.Duration(secs*float64(time.Second)), nil
+}
+
+func formatVTTTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// WriteSegmentedWebVTT splits cues into segmentSeconds-long WebVTT files
+// and a VOD media playlist in dir, so subtitles line up with the video
+// segments. Cues spanning a boundary are repeated in each segment, as the
+// HLS spec allows. total bounds the playlist; zero uses the last cue.
+func WriteSegmentedWebVTT(dir string, cues []Cue, segmentSeconds int, total time.Duration) error {
+	if segmentSeconds <= 0 {
+		return fmt.Errorf("segment duration must be > 0")
+	}
+	if total <= 0 {
+		for _, c := range cues {
+			if c.End > total {
+				total = c.End
+			}
+		}
+	}
+	if total <= 0 {
+		return fmt.Errorf("no cues")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	seg := time.Duration(segmentSeconds) * time.Second
+	n := int(math.Ceil(float64(total) / float64(seg)))
+	pl, err := m3u8.NewMediaPlaylist(0, uint(n))
+	if err != nil {
+		return err
+	}
+	pl.MediaType = m3u8.VOD
+
+	for i := 0; i < n; i++ {
+		from, to := time.Duration(i)*seg, time.Duration(i+1)*seg
+		if to > total {
+			to = total
+		}
+
+		var buf bytes.Buffer
+		w := bufio.NewWriter(&buf)
+		// Video timestamps start at zero, so local and media time agree.
+		fmt.Fprint(w, "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:0,LOCAL:00:00:00.000\n")
+		for _, c := range cues {
+			if c.End <= from || c.Start >= to {
+				continue
+			}
+			fmt.Fprintf(w, "\n%s --> %s", formatVTTTime(c.Start), formatVTTTime(c.End))
+			if c.Settings != "" {
+				fmt.Fprintf(w, " %s", c.Settings)
+			}
+			fmt.Fprintf(w, "\n%s\n", c.Text)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		name := fmt.Sprintf("segment_%05d.vtt", i)
+		if err := os.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0o644); err != nil {
+			return err
+		}
+		if err := pl.Append(name, (to - from).Seconds(), ""); err != nil {
+			return err
+		}
+	}
+	pl.Close()
+
+	return os.WriteFile(filepath.Join(dir, "index.m3u8"), pl.Encode().Bytes(), 0o644)
+}
@@ -0,0 +1,72 @@
+package hls
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sampleVTT = `WEBVTT
+Kind: captions
+Language: en
+
+1
+00:00:01.000 --> 00:00:03.500 align:start position:0%
+hello
+
+00:03.000 --> 00:00:05.000
+across the
+boundary
+
+NOTE this is a comment
+`
+
+func TestParseWebVTT(t *testing.T) {
+	cues, err := ParseWebVTT([]byte(sampleVTT))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if len(cues) != 2 {
+		t.Fatalf("expected 2 cues, got %d", len(cues))
+	}
+	if cues[0].Start != time.Second || cues[0].End != 3500*time.Millisecond || cues[0].Settings != "align:start position:0%" {
+		t.Fatalf("unexpected first cue %+v", cues[0])
+	}
+	if cues[1].Start != 3*time.Second || cues[1].Text != "across the\nboundary" {
+		t.Fatalf("unexpected second cue %+v", cues[1])
+	}
+}
+
+func TestWriteSegmentedWebVTT(t *testing.T) {
+	cues, err := ParseWebVTT([]byte(sampleVTT))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	dir := t.TempDir()
+	if err := WriteSegmentedWebVTT(dir, cues, 4, 0); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	pl, err := os.ReadFile(filepath.Join(dir, "index.m3u8"))
+	if err != nil {
+		t.Fatalf("read playlist: %v", err)
+	}
+	for _, want := range []string{"#EXT-X-PLAYLIST-TYPE:VOD", "segment_00000.vtt", "segment_00001.vtt", "#EXT-X-ENDLIST"} {
+		if !strings.Contains(string(pl), want) {
+			t.Fatalf("expected playlist to contain %q:\n%s", want, pl)
+		}
+	}
+
+	// The second cue spans 3s-5s, so both 4s segments carry it.
+	for _, name := range []string{"segment_00000.vtt", "segment_00001.vtt"} {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if !strings.HasPrefix(string(b), "WEBVTT\nX-TIMESTAMP-MAP=") || !strings.Contains(string(b), "00:00:03.000 --> 00:00:05.000") {
+			t.Fatalf("unexpected %s:\n%s", name, b)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Package lru provides a size-bounded cache with per-entry expiry.
+package lru
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Stats are cumulative counters since the cache was created.
+type Stats struct {
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+	Len         int    `json:"len"`
+}
+
+// Cache holds at most Capacity entries, evicting the least recently used
+// one to make room. Entries also expire after their TTL. It is safe for
+// concurrent use.
+type Cache[K comparable, V any] struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	ll       *list.List
+	items    map[K]*list.Element
+	stats    Stats
+
+	// now is swapped in tests.
+	now func() time.Time
+}
+
+type entry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+// New returns a cache of the given capacity whose entries expire after ttl
+// unless set with SetWithTTL. A capacity below one is treated as one.
+func New[K comparable, V any](capacity int, ttl time.Duration) *Cache[K, V] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &Cache[K, V]{
+		capacity: capacity,
+		ttl:      ttl,
+		ll:       list.New(),
+		items:    map[K]*list.Element{},
+		now:      time.Now,
+	}
+}
+
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		var zero V
+		return zero, false
+	}
+	e := el.Value.(*entry[K, V])
+	if !c.now().Before(e.expires) {
+		c.removeElement(el)
+		c.stats.Expirations++
+		c.stats.Misses++
+		var zero V
+		return zero, false
+	}
+	c.ll.MoveToFront(el)
+	c.stats.Hits++
+	return e.value, true
+}
+
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.SetWithTTL(key, value, c.ttl)
+}
+
+// SetWithTTL stores value with its own expiry, e.g. a shorter one for
+// negative results.
+func (c *Cache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry[K, V])
+		e.value, e.expires = value, now.Add(ttl)
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&entry[K, V]{key: key, value: value, expires: now.Add(ttl)})
+	c.pruneExpired(now)
+	for c.ll.Len() > c.capacity {
+		c.removeElement(c.ll.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *Cache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *Cache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+func (c *Cache[K, V]) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Len = c.ll.Len()
+	return s
+}
+
+// pruneExpired drops expired entries so they don't hold capacity until
+// someone reads them. Entries carry different TTLs, so this walks the
+// whole list; caches here hold hundreds of entries, not millions.
+func (c *Cache[K, V]) pruneExpired(now time.Time) {
+	for el := c.ll.Back(); el != nil; {
+		prev := el.Prev()
+		if !now.Before(el.Value.(*entry[K, V]).expires) {
+			c.removeElement(el)
+			c.stats.Expirations++
+		}
+		el = prev
+	}
+}
+
+func (c *Cache[K, V]) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*entry[K, V]).key)
+}
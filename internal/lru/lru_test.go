@@ -0,0 +1,63 @@
+package lru
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := New[string, int](2, time.Minute)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	if _, ok := c.Get("a"); !ok {
+		t.Fatalf("expected a")
+	}
+	c.Set("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatalf("expected b to be evicted")
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("expected a=1, got %d, %v", v, ok)
+	}
+	if s := c.Stats(); s.Evictions != 1 || s.Len != 2 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestCache_ExpiresEntries(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := New[string, int](10, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.Set("long", 1)
+	c.SetWithTTL("short", 2, time.Second)
+
+	now = now.Add(2 * time.Second)
+	if _, ok := c.Get("short"); ok {
+		t.Fatalf("expected short to expire")
+	}
+	if _, ok := c.Get("long"); !ok {
+		t.Fatalf("expected long to survive")
+	}
+
+	s := c.Stats()
+	if s.Hits != 1 || s.Misses != 1 || s.Expirations != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestCache_SetPrunesExpiredEntries(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := New[string, int](10, time.Second)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	now = now.Add(2 * time.Second)
+	c.Set("c", 3)
+
+	if c.Len() != 1 {
+		t.Fatalf("expected expired entries to be pruned, got len %d", c.Len())
+	}
+}
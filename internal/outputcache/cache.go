@@ -0,0 +1,406 @@
+// Package outputcache keeps completed HLS output on disk so popular videos
+// aren't transcoded again (ADR-015). It is opt-in; ADR-001 still holds for
+// deployments that don't configure a cache root.
+package outputcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const manifestName = "manifest.json"
+
+// Manifest records every file of an entry with its size and SHA-256. Restore
+// checks sizes, which catches truncation cheaply; Scrub checks the hashes in
+// the background.
+type Manifest struct {
+	Key       string               `json:"key"`
+	VideoID   string               `json:"video_id"`
+	CreatedAt time.Time            `json:"created_at"`
+	Files     map[string]FileEntry `json:"files"`
+}
+
+type FileEntry struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func (m Manifest) size() int64 {
+	var n int64
+	for _, f := range m.Files {
+		n += f.Size
+	}
+	return n
+}
+
+// Cache stores one directory per key under Root. Entries are evicted least
+// recently used first once their total size exceeds MaxBytes; the entry
+// directory's mtime is its last use.
+type Cache struct {
+	Root     string
+	MaxBytes int64
+	Logger   zerolog.Logger
+
+	// mu serializes Put, Restore and eviction.
+	mu sync.Mutex
+}
+
+// ScrubInterval is how often RunScrub rehashes every entry.
+const ScrubInterval = 6 * time.Hour
+
+func New(root string, maxBytes int64, logger zerolog.Logger) (*Cache, error) {
+	if root == "" {
+		return nil, fmt.Errorf("cache root is required")
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("create cache root: %w", err)
+	}
+	return &Cache{Root: root, MaxBytes: maxBytes, Logger: logger}, nil
+}
+
+// Key derives the entry key from the video ID and a fingerprint of the
+// encoder settings, so changing the ladder never serves stale output.
+func Key(videoID, settings string) string {
+	sum := sha256.Sum256([]byte(videoID + "\x00" + settings))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Restore copies the entry's files into dst, which must be empty or not
+// exist yet. It reports false when there is no usable entry; an entry
+// whose files don't match the manifest's sizes is removed. Hashes are left
+// to Scrub: reading every segment on each hit would cost what the cache
+// saves.
+//
+// The entry is built beside dst and renamed into place, so dst never holds
+// a partial restore, and eviction waits until it's done. Segments are
+// hardlinked; playlists and init segments, which ffmpeg truncates when it
+// writes into a directory again, are copied so that can't reach the entry.
+func (c *Cache) Restore(key, dst string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dir := c.entryDir(key)
+	m, err := readManifest(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err == nil {
+		err = verifySizes(dir, m)
+	}
+	if err != nil {
+		c.Logger.Warn().Str("key", key).Err(err).Msg("dropping corrupt cache entry")
+		c.remove(key)
+		return false, nil
+	}
+
+	tmp, err := os.MkdirTemp(filepath.Dir(dst), "."+filepath.Base(dst)+"-restore-")
+	if err != nil {
+		return false, fmt.Errorf("create restore dir: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	if err := os.Chmod(tmp, 0o755); err != nil {
+		return false, err
+	}
+
+	for rel := range m.Files {
+		if err := placeFile(filepath.Join(dir, filepath.FromSlash(rel)), filepath.Join(tmp, filepath.FromSlash(rel))); err != nil {
+			return false, fmt.Errorf("restore %s: %w", rel, err)
+		}
+	}
+	// Rename won't replace a directory, even an empty one.
+	if err := os.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("replace %s: %w", dst, err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return false, fmt.Errorf("commit restore: %w", err)
+	}
+
+	now := time.Now()
+	_ = os.Chtimes(dir, now, now)
+	return true, nil
+}
+
+// Put stores the given subdirectories of src, recursively, under key.
+// Existing entries are left alone.
+func (c *Cache) Put(key, videoID, src string, dirs []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	final := c.entryDir(key)
+	if _, err := os.Stat(final); err == nil {
+		return nil
+	}
+
+	// Build the entry beside its final path and rename it into place, so a
+	// crash never leaves a half-written entry under the real key.
+	tmp, err := os.MkdirTemp(c.Root, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("create cache entry: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	m := Manifest{Key: key, VideoID: videoID, CreatedAt: time.Now().UTC(), Files: map[string]FileEntry{}}
+	for _, d := range dirs {
+		err := filepath.WalkDir(filepath.Join(src, d), func(path string, e fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// Two-pass stats are scratch files, not output.
+			if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), "ffmpeg2pass") {
+				return nil
+			}
+			rel, err := filepath.Rel(src, path)
+			if err != nil {
+				return err
+			}
+			if err := placeFile(path, filepath.Join(tmp, rel)); err != nil {
+				return fmt.Errorf("store %s: %w", rel, err)
+			}
+			fe, err := hashFile(filepath.Join(tmp, rel))
+			if err != nil {
+				return err
+			}
+			m.Files[filepath.ToSlash(rel)] = fe
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, manifestName), b, 0o644); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	if err := os.Rename(tmp, final); err != nil {
+		return fmt.Errorf("commit cache entry: %w", err)
+	}
+
+	c.evict(key)
+	return nil
+}
+
+// Scrub rehashes every entry and removes those that no longer match their
+// manifest. Hashing happens without mu held, so restores carry on meanwhile.
+func (c *Cache) Scrub() {
+	dirs, err := os.ReadDir(c.Root)
+	if err != nil {
+		c.Logger.Warn().Err(err).Msg("cache scrub failed")
+		return
+	}
+	for _, d := range dirs {
+		if !d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			continue
+		}
+		dir := filepath.Join(c.Root, d.Name())
+		m, err := readManifest(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err == nil {
+			err = verify(dir, m)
+		}
+		// An entry evicted while it was being hashed fails too; there's
+		// nothing left to remove then.
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			c.Logger.Warn().Str("key", d.Name()).Err(err).Msg("dropping corrupt cache entry")
+			c.mu.Lock()
+			c.remove(d.Name())
+			c.mu.Unlock()
+		}
+	}
+}
+
+// RunScrub scrubs every interval until ctx is done.
+func (c *Cache) RunScrub(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			c.Scrub()
+		}
+	}
+}
+
+// evict removes least recently used entries, never keep, until the cache
+// fits MaxBytes. Callers hold mu.
+func (c *Cache) evict(keep string) {
+	if c.MaxBytes <= 0 {
+		return
+	}
+	type entry struct {
+		key  string
+		size int64
+		used time.Time
+	}
+
+	dirs, err := os.ReadDir(c.Root)
+	if err != nil {
+		return
+	}
+	var entries []entry
+	var total int64
+	for _, d := range dirs {
+		if !d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		m, err := readManifest(filepath.Join(c.Root, d.Name()))
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry{key: d.Name(), size: m.size(), used: info.ModTime()})
+		total += m.size()
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].used.Before(entries[j].used) })
+	for _, e := range entries {
+		if total <= c.MaxBytes {
+			return
+		}
+		if e.key == keep {
+			continue
+		}
+		c.Logger.Debug().Str("key", e.key).Int64("bytes", e.size).Msg("evicting cache entry")
+		c.remove(e.key)
+		total -= e.size
+	}
+}
+
+func (c *Cache) remove(key string) {
+	if err := os.RemoveAll(c.entryDir(key)); err != nil {
+		c.Logger.Warn().Str("key", key).Err(err).Msg("failed to remove cache entry")
+	}
+}
+
+func (c *Cache) entryDir(key string) string {
+	return filepath.Join(c.Root, key)
+}
+
+func readManifest(dir string) (Manifest, error) {
+	b, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		return Manifest{}, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return Manifest{}, fmt.Errorf("parse manifest: %w", err)
+	}
+	return m, nil
+}
+
+func verifySizes(dir string, m Manifest) error {
+	if len(m.Files) == 0 {
+		return fmt.Errorf("empty manifest")
+	}
+	for rel, want := range m.Files {
+		info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil {
+			return err
+		}
+		if info.Size() != want.Size {
+			return fmt.Errorf("%s: size mismatch", rel)
+		}
+	}
+	return nil
+}
+
+func verify(dir string, m Manifest) error {
+	if len(m.Files) == 0 {
+		return fmt.Errorf("empty manifest")
+	}
+	for rel, want := range m.Files {
+		got, err := hashFile(filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil {
+			return err
+		}
+		if got != want {
+			return fmt.Errorf("%s: checksum mismatch", rel)
+		}
+	}
+	return nil
+}
+
+func hashFile(path string) (FileEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return FileEntry{}, fmt.Errorf("hash %s: %w", path, err)
+	}
+	return FileEntry{Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// placeFile puts src at dst, sharing the inode unless ffmpeg rewrites the
+// file in place when it writes into the same directory again: playlists
+// and init segments are truncated and rewritten, segments only created.
+func placeFile(src, dst string) error {
+	if strings.HasSuffix(dst, ".m3u8") || filepath.Base(dst) == "init.mp4" {
+		return copyFile(src, dst)
+	}
+	return linkOrCopy(src, dst)
+}
+
+// linkOrCopy hardlinks src to dst, copying when they are on different
+// filesystems. Only files nothing rewrites in place may share inodes with a
+// stream directory; see placeFile.
+func linkOrCopy(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return copyFile(src, dst)
+}
+
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,200 @@
+package outputcache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func writeTier(t *testing.T, dir, tier string, files map[string]string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(dir, tier), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, tier, name), []byte(body), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+}
+
+func TestCache_PutThenRestore(t *testing.T) {
+	c, err := New(t.TempDir(), 0, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	src := t.TempDir()
+	writeTier(t, src, "64x64", map[string]string{"index.m3u8": "#EXTM3U\n", "segment_00000.m4s": "seg", "ffmpeg2pass-0.log": "stats"})
+
+	key := Key("abc", "ladder")
+	if err := c.Put(key, "abc", src, []string{"64x64"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	// The stream directory goes away; the entry must not.
+	if err := os.RemoveAll(src); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+
+	dst := t.TempDir()
+	hit, err := c.Restore(key, dst)
+	if err != nil || !hit {
+		t.Fatalf("expected hit, got %v, %v", hit, err)
+	}
+	b, err := os.ReadFile(filepath.Join(dst, "64x64", "segment_00000.m4s"))
+	if err != nil || string(b) != "seg" {
+		t.Fatalf("expected restored segment, got %q, %v", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "64x64", "ffmpeg2pass-0.log")); !os.IsNotExist(err) {
+		t.Fatalf("expected two-pass stats to be left out")
+	}
+
+	if hit, _ := c.Restore(Key("abc", "other ladder"), t.TempDir()); hit {
+		t.Fatalf("expected a different ladder to miss")
+	}
+}
+
+func TestCache_RestoreDropsCorruptEntry(t *testing.T) {
+	c, err := New(t.TempDir(), 0, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	src := t.TempDir()
+	writeTier(t, src, "64x64", map[string]string{"segment_00000.m4s": "seg"})
+
+	key := Key("abc", "ladder")
+	if err := c.Put(key, "abc", src, []string{"64x64"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	seg := filepath.Join(c.Root, key, "64x64", "segment_00000.m4s")
+	if err := os.Remove(seg); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := os.WriteFile(seg, []byte("truncated"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	hit, err := c.Restore(key, t.TempDir())
+	if err != nil || hit {
+		t.Fatalf("expected miss, got %v, %v", hit, err)
+	}
+	if _, err := os.Stat(filepath.Join(c.Root, key)); !os.IsNotExist(err) {
+		t.Fatalf("expected corrupt entry to be removed")
+	}
+}
+
+func TestCache_ScrubDropsCorruptEntry(t *testing.T) {
+	c, err := New(t.TempDir(), 0, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	src := t.TempDir()
+	writeTier(t, src, "64x64", map[string]string{"segment_00000.m4s": "seg"})
+	good, bad := Key("abc", "ladder"), Key("def", "ladder")
+	for _, key := range []string{good, bad} {
+		if err := c.Put(key, "abc", src, []string{"64x64"}); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	// Same size, so only the hash tells.
+	seg := filepath.Join(c.Root, bad, "64x64", "segment_00000.m4s")
+	if err := os.Remove(seg); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := os.WriteFile(seg, []byte("bad"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	c.Scrub()
+	if _, err := os.Stat(filepath.Join(c.Root, bad)); !os.IsNotExist(err) {
+		t.Fatalf("expected the corrupt entry to be removed")
+	}
+	if hit, err := c.Restore(good, t.TempDir()); err != nil || !hit {
+		t.Fatalf("expected the intact entry to survive, got %v, %v", hit, err)
+	}
+}
+
+func TestCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c, err := New(t.TempDir(), 10, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	put := func(id string) string {
+		src := t.TempDir()
+		writeTier(t, src, "64x64", map[string]string{"segment_00000.m4s": "123456"})
+		key := Key(id, "ladder")
+		if err := c.Put(key, id, src, []string{"64x64"}); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		return key
+	}
+
+	old := put("old")
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(c.Root, old), past, past); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	recent := put("recent")
+
+	if _, err := os.Stat(filepath.Join(c.Root, old)); !os.IsNotExist(err) {
+		t.Fatalf("expected oldest entry to be evicted")
+	}
+	if _, err := os.Stat(filepath.Join(c.Root, recent)); err != nil {
+		t.Fatalf("expected newest entry to be kept: %v", err)
+	}
+}
+
+func TestCache_RestoredPlaylistsDontShareTheEntry(t *testing.T) {
+	c, err := New(t.TempDir(), 0, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	src := t.TempDir()
+	writeTier(t, src, "64x64", map[string]string{"index.m3u8": "#EXTM3U\n", "init.mp4": "init", "segment_00000.m4s": "seg"})
+	key := Key("abc", "ladder")
+	if err := c.Put(key, "abc", src, []string{"64x64"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "stream")
+	if hit, err := c.Restore(key, dst); err != nil || !hit {
+		t.Fatalf("expected hit, got %v, %v", hit, err)
+	}
+	// ffmpeg truncates these when it writes into the directory again.
+	for _, name := range []string{"index.m3u8", "init.mp4"} {
+		if err := os.WriteFile(filepath.Join(dst, "64x64", name), nil, 0o644); err != nil {
+			t.Fatalf("truncate: %v", err)
+		}
+	}
+	if hit, err := c.Restore(key, filepath.Join(t.TempDir(), "again")); err != nil || !hit {
+		t.Fatalf("expected the entry to survive rewrites of a restored copy, got %v, %v", hit, err)
+	}
+}
+
+func TestCache_RestoreLeavesNothingPartialOnFailure(t *testing.T) {
+	c, err := New(t.TempDir(), 0, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	src := t.TempDir()
+	writeTier(t, src, "64x64", map[string]string{"segment_00000.m4s": "seg"})
+	key := Key("abc", "ladder")
+	if err := c.Put(key, "abc", src, []string{"64x64"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "stream")
+	writeTier(t, dst, "other", map[string]string{"x": "y"})
+	if hit, err := c.Restore(key, dst); err == nil || hit {
+		t.Fatalf("expected a non-empty destination to fail, got %v, %v", hit, err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "64x64")); !os.IsNotExist(err) {
+		t.Fatalf("expected no partial restore in dst")
+	}
+	entries, _ := os.ReadDir(filepath.Dir(dst))
+	if len(entries) != 1 {
+		t.Fatalf("expected the restore dir to be cleaned up, found %d entries", len(entries))
+	}
+}
@@ -0,0 +1,169 @@
+// Package ratelimit limits how often each client may make a request, with
+// one token bucket per client.
+package ratelimit
+
+import (
+	"container/list"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Stats are cumulative counters since the limiter was created.
+type Stats struct {
+	Allowed uint64 `json:"allowed"`
+	Limited uint64 `json:"limited"`
+	Evicted uint64 `json:"evicted"`
+	Buckets int    `json:"buckets"`
+}
+
+// MaxBuckets bounds how many clients a Limiter tracks at once.
+const MaxBuckets = 100_000
+
+// Limiter allows each key perMinute requests a minute on average, in bursts
+// of up to burst. A bucket left alone until it refills holds no state worth
+// keeping, so idle buckets are dropped; memory tracks recently active
+// clients only, and never more than MaxBuckets of them: past that, the
+// least recently used bucket makes room. It is safe for concurrent use.
+//
+// Buckets are kept in a list by last use, so both idle buckets and the
+// least recently used one are found at the back without a scan.
+type Limiter struct {
+	mu         sync.Mutex
+	rate       float64 // tokens per second
+	burst      float64
+	idle       time.Duration
+	ll         *list.List // of *bucket, most recently used first
+	buckets    map[string]*list.Element
+	maxBuckets int
+	stats      Stats
+
+	// now is swapped in tests.
+	now func() time.Time
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// New returns a limiter, or nil, which allows everything, when perMinute
+// is below one. A burst below one is treated as one.
+func New(perMinute, burst int) *Limiter {
+	if perMinute < 1 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	rate := float64(perMinute) / 60
+	return &Limiter{
+		rate:       rate,
+		burst:      float64(burst),
+		idle:       time.Duration(float64(burst) / rate * float64(time.Second)),
+		ll:         list.New(),
+		buckets:    map[string]*list.Element{},
+		maxBuckets: MaxBuckets,
+		now:        time.Now,
+	}
+}
+
+// Allow takes a token from key's bucket. When it's empty, Allow reports how
+// long until the next token.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	var b *bucket
+	if el, ok := l.buckets[key]; ok {
+		b = el.Value.(*bucket)
+		l.ll.MoveToFront(el)
+	} else {
+		if len(l.buckets) >= l.maxBuckets {
+			l.evict(l.ll.Back())
+		}
+		b = &bucket{key: key, tokens: l.burst, last: now}
+		l.buckets[key] = l.ll.PushFront(b)
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		l.stats.Limited++
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	l.stats.Allowed++
+	return true, 0
+}
+
+// sweep drops buckets that have refilled. They're the least recently used,
+// so it stops at the first that hasn't.
+func (l *Limiter) sweep(now time.Time) {
+	for el := l.ll.Back(); el != nil && now.Sub(el.Value.(*bucket).last) >= l.idle; el = l.ll.Back() {
+		l.evict(el)
+	}
+}
+
+func (l *Limiter) evict(el *list.Element) {
+	b := l.ll.Remove(el).(*bucket)
+	delete(l.buckets, b.key)
+	l.stats.Evicted++
+}
+
+func (l *Limiter) Stats() Stats {
+	if l == nil {
+		return Stats{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := l.stats
+	s.Buckets = len(l.buckets)
+	return s
+}
+
+// Middleware limits requests by client IP, taken from r.RemoteAddr; put it
+// behind whatever resolves the client address of proxied requests. IPv6
+// clients are limited per /64, the block a single host is usually given.
+// A nil limiter disables it.
+func Middleware(l *Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if l == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ok, wait := l.Allow(clientIP(r)); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, `{"error":"rate limit exceeded"}`, http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	addr = addr.Unmap()
+	if addr.Is4() {
+		return addr.String()
+	}
+	return netip.PrefixFrom(addr.WithZone(""), 64).Masked().String()
+}
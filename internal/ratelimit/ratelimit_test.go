@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiter_RefillsAtRate(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(60, 2)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("expected burst request %d to pass", i)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok || wait != time.Second {
+		t.Fatalf("expected a 1s wait, got %v, %v", ok, wait)
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Fatalf("expected another client to have its own bucket")
+	}
+
+	now = now.Add(time.Second)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatalf("expected a token after 1s")
+	}
+	if s := l.Stats(); s.Allowed != 4 || s.Limited != 1 || s.Buckets != 2 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestLimiter_EvictsIdleBuckets(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(60, 2)
+	l.now = func() time.Time { return now }
+
+	l.Allow("a")
+	l.Allow("b")
+	// Two seconds refills a burst of two.
+	now = now.Add(2 * time.Second)
+	l.Allow("c")
+
+	if s := l.Stats(); s.Buckets != 1 || s.Evicted != 2 {
+		t.Fatalf("expected idle buckets to be evicted, got %+v", s)
+	}
+}
+
+func TestLimiter_CapsBuckets(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(60, 10)
+	l.now = func() time.Time { return now }
+	l.maxBuckets = 2
+
+	l.Allow("a")
+	now = now.Add(time.Millisecond)
+	l.Allow("b")
+	now = now.Add(time.Millisecond)
+	l.Allow("c")
+
+	if s := l.Stats(); s.Buckets != 2 || s.Evicted != 1 {
+		t.Fatalf("expected the cap to hold, got %+v", s)
+	}
+	if _, ok := l.buckets["a"]; ok {
+		t.Fatalf("expected the least recently used bucket to go")
+	}
+}
+
+func TestLimiter_CapsBuckets_KeepsRecentlyUsed(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(60, 10)
+	l.now = func() time.Time { return now }
+	l.maxBuckets = 2
+
+	for _, key := range []string{"a", "b", "a", "c"} {
+		now = now.Add(time.Millisecond)
+		l.Allow(key)
+	}
+	if _, ok := l.buckets["b"]; ok {
+		t.Fatalf("expected b, not the recently used a, to go")
+	}
+	if _, ok := l.buckets["a"]; !ok {
+		t.Fatalf("expected a to be kept")
+	}
+}
+
+func TestClientIP_GroupsIPv6By64(t *testing.T) {
+	cases := map[string]string{
+		"203.0.113.7:1234":                   "203.0.113.7",
+		"[::ffff:203.0.113.7]:1234":          "203.0.113.7",
+		"[2001:db8:1:2:aaaa::1]:1234":        "2001:db8:1:2::/64",
+		"[2001:db8:1:2:bbbb:cccc:dddd:1]:80": "2001:db8:1:2::/64",
+		"[2001:db8:1:3::1]:80":               "2001:db8:1:3::/64",
+	}
+	for remote, want := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remote
+		if got := clientIP(req); got != want {
+			t.Errorf("clientIP(%s) = %s, want %s", remote, got, want)
+		}
+	}
+}
+
+func TestNew_DisabledWithoutRate(t *testing.T) {
+	l := New(0, 10)
+	if l != nil {
+		t.Fatalf("expected nil limiter")
+	}
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatalf("expected a nil limiter to allow")
+	}
+}
+
+func TestMiddleware_Returns429WithRetryAfter(t *testing.T) {
+	l := New(6, 1)
+	h := Middleware(l)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.RemoteAddr = "203.0.113.7:1234"
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+
+	// Another port, same client.
+	req.RemoteAddr = "203.0.113.7:5678"
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "10" {
+		t.Fatalf("expected Retry-After 10, got %q", got)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/sixfeetup/blobtube/internal/api"
 	"github.com/sixfeetup/blobtube/internal/config"
 	"github.com/sixfeetup/blobtube/internal/stream"
+	"github.com/sixfeetup/blobtube/internal/transcode"
 )
 
 type runOptions struct {
@@ -45,17 +46,35 @@ func Run(ctx context.Context, cfg config.Config, opts ...RunOption) error {
 	defer stop()
 
 	streams := stream.NewManager(5 * time.Minute)
+	streams.SetRetention(cfg.CompletedRetention)
 	resources := stream.NewResources(log.Logger)
+
+	// Readiness only checks StreamsDir, so it has to exist before the first
+	// probe.
+	if err := os.MkdirAll(cfg.StreamsDir, 0o755); err != nil {
+		return fmt.Errorf("create streams dir: %w", err)
+	}
+
+	// Before anything is served, so no request sees a stream mid-recovery.
+	recovered, err := api.RecoverStreams(cfg, streams)
+	if err != nil {
+		return fmt.Errorf("recover streams: %w", err)
+	}
+	log.Info().Int("restored", recovered.Restored).Int("removed", recovered.Removed).Int("skipped", recovered.Skipped).Msg("stream recovery finished")
 	go streams.StartJanitor(ctx, 30*time.Second, func(streamID string) {
 		cleanupCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 		defer cancel()
 		resources.CleanupStream(cleanupCtx, streamID)
+		resources.DropStderrLog(streamID)
 		if err := os.RemoveAll(filepath.Join(cfg.StreamsDir, streamID)); err != nil {
 			log.Warn().Str("stream_id", streamID).Err(err).Msg("failed to remove stream dir")
 		}
 	})
 
-	h, err := api.NewHandler(cfg, streams)
+	ytdlp := startYtDLP(ctx, cfg)
+
+	lifecycle := api.NewLifecycle()
+	h, err := api.NewHandler(cfg, streams, api.WithResources(resources), api.WithYtDLP(ytdlp), api.WithLifecycle(lifecycle))
 	if err != nil {
 		return err
 	}
@@ -73,7 +92,22 @@ func Run(ctx context.Context, cfg config.Config, opts ...RunOption) error {
 		ReadHeaderTimeout: 5 * time.Second,
 	}
 
-	errCh := make(chan error, 2)
+	// The admin API gets its own listener so it's never reachable through
+	// the public one; bind ADMIN_ADDR to loopback or a private network.
+	var adminSrv *http.Server
+	if cfg.AdminAddr != "" {
+		ah, err := api.NewAdminHandler(cfg, streams, api.WithResources(resources))
+		if err != nil {
+			return fmt.Errorf("admin api: %w", err)
+		}
+		adminSrv = &http.Server{
+			Addr:              cfg.AdminAddr,
+			Handler:           ah,
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+	}
+
+	errCh := make(chan error, 3)
 
 	go func() {
 		log.Info().Str("addr", cfg.HTTPSAddr).Msg("https server starting")
@@ -85,24 +119,62 @@ func Run(ctx context.Context, cfg config.Config, opts ...RunOption) error {
 		errCh <- httpRedirectSrv.ListenAndServe()
 	}()
 
+	if adminSrv != nil {
+		go func() {
+			log.Info().Str("addr", cfg.AdminAddr).Msg("admin server starting")
+			errCh <- adminSrv.ListenAndServe()
+		}()
+	}
+
 	select {
 	case <-ctx.Done():
-		log.Info().Msg("shutdown signal received")
+		log.Info().Dur("grace", cfg.ShutdownGrace).Msg("shutdown signal received, draining streams")
+		start := time.Now()
+
+		// Viewers keep playing while transcodes get their grace; only new
+		// streams are refused.
+		drained := lifecycle.Drain(cfg.ShutdownGrace)
+
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
 		_ = httpRedirectSrv.Shutdown(shutdownCtx)
 		_ = httpsSrv.Shutdown(shutdownCtx)
+		if adminSrv != nil {
+			_ = adminSrv.Shutdown(shutdownCtx)
+		}
 
+		// Drain canceled the pipelines; this catches stragglers.
 		cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), 3*time.Second)
 		defer cleanupCancel()
 		resources.CleanupAll(cleanupCtx)
-		for _, id := range streams.IDs() {
-			if err := os.RemoveAll(filepath.Join(cfg.StreamsDir, id)); err != nil {
-				log.Warn().Str("stream_id", id).Err(err).Msg("failed to remove stream dir")
+
+		// Completed streams still within their retention are kept for
+		// RecoverStreams on the next start; nothing else could ever finish
+		// or would outlive the janitor anyway.
+		kept, removed := 0, 0
+		now := time.Now()
+		for _, s := range streams.List() {
+			if streams.Retained(s, now) {
+				kept++
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(cfg.StreamsDir, s.ID)); err != nil {
+				log.Warn().Str("stream_id", s.ID).Err(err).Msg("failed to remove stream dir")
+				continue
 			}
+			removed++
 		}
 
+		log.Info().
+			Int("in_flight", drained.InFlight).
+			Int("finished", drained.Finished).
+			Int("canceled", drained.Canceled).
+			Int("abandoned", drained.Abandoned).
+			Int("dirs_kept", kept).
+			Int("dirs_removed", removed).
+			Dur("took", time.Since(start)).
+			Msg("shutdown complete")
 		return nil
 	case err := <-errCh:
 		if errors.Is(err, http.ErrServerClosed) {
@@ -112,6 +184,33 @@ func Run(ctx context.Context, cfg config.Config, opts ...RunOption) error {
 	}
 }
 
+// startYtDLP builds the yt-dlp runner, logs its version and starts the
+// updater. In release mode the managed binary replaces the configured one,
+// downloading it first if needed.
+func startYtDLP(ctx context.Context, cfg config.Config) *transcode.YtDLP {
+	if cfg.YtDLPUpdate == transcode.UpdateRelease {
+		cfg.YtDLPPath = cfg.YtDLPManagedPath
+	}
+	ytdlp := api.NewYtDLP(cfg)
+	updater := &transcode.Updater{YtDLP: ytdlp, Mode: cfg.YtDLPUpdate, ReleaseURL: cfg.YtDLPReleaseURL}
+
+	versionCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	if _, err := os.Stat(ytdlp.Path); cfg.YtDLPUpdate == transcode.UpdateRelease && err != nil {
+		if err := updater.Update(versionCtx); err != nil {
+			log.Error().Err(err).Msg("failed to install managed yt-dlp")
+		}
+	}
+	if v, err := ytdlp.RefreshVersion(versionCtx); err != nil {
+		log.Warn().Err(err).Str("path", ytdlp.Path).Msg("could not determine yt-dlp version")
+	} else {
+		log.Info().Str("version", v).Str("path", ytdlp.Path).Str("update", cfg.YtDLPUpdate).Object("options", ytdlp.Options).Msg("yt-dlp ready")
+	}
+
+	go updater.Run(ctx, cfg.YtDLPUpdateInterval)
+	return ytdlp
+}
+
 func redirectToHTTPS(cfg config.Config) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		host := r.Host
@@ -5,8 +5,11 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+	"unicode"
 )
 
 type State string
@@ -19,28 +22,119 @@ const (
 	StateTimedOut     State = "timed_out"
 )
 
+// Terminal reports whether a stream in this state is settled for good.
+func (s State) Terminal() bool {
+	return s == StateCompleted || s == StateError || s == StateTimedOut
+}
+
+// ErrorCode is the machine-readable reason a stream failed. Error carries
+// the matching human-readable message; raw tool output never reaches it.
+type ErrorCode string
+
+const (
+	ErrCodeUnsupportedURL    ErrorCode = "unsupported_url"
+	ErrCodeUnavailable       ErrorCode = "unavailable"
+	ErrCodeRegionLocked      ErrorCode = "region_locked"
+	ErrCodeAgeRestricted     ErrorCode = "age_restricted"
+	ErrCodeLiveNotSupported  ErrorCode = "live_not_supported"
+	ErrCodeSourceTooLong     ErrorCode = "source_too_long"
+	ErrCodeSourceTooLarge    ErrorCode = "source_too_large"
+	ErrCodeUnknownDuration   ErrorCode = "unknown_duration"
+	ErrCodePlaylist          ErrorCode = "playlist_not_supported"
+	ErrCodeSourceRateLimited ErrorCode = "source_rate_limited"
+	ErrCodeExtractionFailed  ErrorCode = "extraction_failed"
+	ErrCodeEncoderFailed     ErrorCode = "encoder_failed"
+	ErrCodeDiskFull          ErrorCode = "disk_full"
+	ErrCodeInactiveTimeout   ErrorCode = "inactive_timeout"
+	ErrCodeCanceled          ErrorCode = "canceled"
+	ErrCodeInternal          ErrorCode = "internal"
+)
+
 type Stream struct {
 	ID         string    `json:"id"`
 	Qualities  []string  `json:"qualities"`
 	State      State     `json:"state"`
 	CreatedAt  time.Time `json:"created_at"`
 	LastAccess time.Time `json:"last_access"`
+	// KeepUntil holds off the inactivity timeout, e.g. after an operator
+	// extends a stream's TTL.
+	KeepUntil time.Time `json:"keep_until"`
+	// Owner is the label of the API key that created the stream, if any.
+	Owner string `json:"owner,omitempty"`
+	// Live streams relay a broadcast until viewers stop watching.
+	Live bool `json:"live"`
+	// Subtitles lists the subtitle languages available as renditions.
+	Subtitles []string `json:"subtitles,omitempty"`
+	// Metadata is nil until yt-dlp has described the source.
+	Metadata *Metadata `json:"metadata,omitempty"`
+	// Thumbnail and Storyboard report which seek-preview images exist.
+	Thumbnail  bool      `json:"thumbnail"`
+	Storyboard bool      `json:"storyboard"`
+	ErrorCode  ErrorCode `json:"error_code,omitempty"`
 	Error      string    `json:"error,omitempty"`
 }
 
+// Metadata is the client-facing subset of a source's yt-dlp metadata.
+type Metadata struct {
+	Title   string `json:"title"`
+	Channel string `json:"channel,omitempty"`
+	// DurationSeconds is the source's length, zero when unknown or live.
+	// EffectiveDurationSeconds is what is actually transcoded after the
+	// max duration cap.
+	DurationSeconds          int `json:"duration_seconds"`
+	EffectiveDurationSeconds int `json:"effective_duration_seconds"`
+}
+
+// maxMetadataText bounds titles and channel names, in runes.
+const maxMetadataText = 200
+
+// sanitizeText makes uploader-controlled text safe to hand to clients:
+// valid UTF-8, no control characters, whitespace collapsed and bounded.
+func sanitizeText(s string) string {
+	s = strings.ToValidUTF8(s, "")
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return ' '
+		}
+		return r
+	}, s)
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > maxMetadataText {
+		s = strings.TrimSpace(string(r[:maxMetadataText-1])) + "…"
+	}
+	return s
+}
+
 var defaultQualities = []string{"64x64", "128x128", "256x256"}
 
+// DefaultRetention is how long a completed stream is kept unwatched unless
+// SetRetention says otherwise.
+const DefaultRetention = time.Hour
+
 type Manager struct {
-	mu      sync.Mutex
-	streams map[string]*Stream
-	timeout time.Duration
+	mu        sync.Mutex
+	streams   map[string]*Stream
+	timeout   time.Duration
+	retention time.Duration
 }
 
 func NewManager(inactivityTimeout time.Duration) *Manager {
 	if inactivityTimeout <= 0 {
 		inactivityTimeout = 5 * time.Minute
 	}
-	return &Manager{streams: map[string]*Stream{}, timeout: inactivityTimeout}
+	return &Manager{streams: map[string]*Stream{}, timeout: inactivityTimeout, retention: DefaultRetention}
+}
+
+// SetRetention sets how long a completed stream is kept after it was last
+// played before ExpireInactive times it out. Zero or less keeps the
+// default.
+func (m *Manager) SetRetention(d time.Duration) {
+	if d <= 0 {
+		d = DefaultRetention
+	}
+	m.mu.Lock()
+	m.retention = d
+	m.mu.Unlock()
 }
 
 func (m *Manager) InactivityTimeout() time.Duration {
@@ -88,6 +182,27 @@ func (m *Manager) Register(id string, now time.Time) (Stream, bool) {
 	return *s, true
 }
 
+// Restore adds a stream rebuilt from disk, e.g. after a restart. It
+// reports false, changing nothing, if the ID is already known.
+func (m *Manager) Restore(s Stream) bool {
+	if s.ID == "" {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.streams[s.ID]; ok {
+		return false
+	}
+	s.Qualities = append([]string(nil), s.Qualities...)
+	s.Subtitles = append([]string(nil), s.Subtitles...)
+	if s.Metadata != nil {
+		md := *s.Metadata
+		s.Metadata = &md
+	}
+	m.streams[s.ID] = &s
+	return true
+}
+
 func (m *Manager) Touch(id string, now time.Time) bool {
 	if id == "" {
 		return false
@@ -116,6 +231,48 @@ func (m *Manager) Get(id string) (Stream, bool) {
 	return *s, true
 }
 
+// List returns a snapshot of every stream, oldest first.
+func (m *Manager) List() []Stream {
+	m.mu.Lock()
+	out := make([]Stream, 0, len(m.streams))
+	for _, s := range m.streams {
+		out = append(out, *s)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.Before(out[j].CreatedAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// Delete forgets a stream. Its processes and files are the caller's to
+// clean up.
+func (m *Manager) Delete(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.streams[id]; !ok {
+		return false
+	}
+	delete(m.streams, id)
+	return true
+}
+
+// KeepUntil keeps a stream from timing out for inactivity before until.
+func (m *Manager) KeepUntil(id string, until time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.streams[id]
+	if !ok {
+		return false
+	}
+	s.KeepUntil = until
+	return true
+}
+
 func (m *Manager) IDs() []string {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -126,18 +283,132 @@ func (m *Manager) IDs() []string {
 	return ids
 }
 
+// SetState moves a stream to state. It reports false, changing nothing,
+// when the stream is unknown or already terminal: a stream canceled, failed
+// or timed out by someone else stays that way.
 func (m *Manager) SetState(id string, state State, errMsg string) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	s, ok := m.streams[id]
-	if !ok {
+	if !ok || s.State.Terminal() {
 		return false
 	}
 	s.State = state
 	s.Error = errMsg
+	s.ErrorCode = ""
+	return true
+}
+
+// Fail moves a stream to StateError with a code and client-facing message.
+// Like SetState, it leaves terminal streams alone.
+func (m *Manager) Fail(id string, code ErrorCode, msg string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.streams[id]
+	if !ok || s.State.Terminal() {
+		return false
+	}
+	s.State = StateError
+	s.ErrorCode = code
+	s.Error = msg
 	return true
 }
 
+// SetQualities replaces the tier names advertised for a stream.
+func (m *Manager) SetQualities(id string, qualities []string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.streams[id]
+	if !ok {
+		return false
+	}
+	s.Qualities = append([]string(nil), qualities...)
+	return true
+}
+
+// SetSubtitles replaces the subtitle languages advertised for a stream.
+func (m *Manager) SetSubtitles(id string, langs []string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.streams[id]
+	if !ok {
+		return false
+	}
+	s.Subtitles = append([]string(nil), langs...)
+	return true
+}
+
+// SetMetadata stores the sanitized metadata for a stream.
+func (m *Manager) SetMetadata(id string, md Metadata) bool {
+	md.Title = sanitizeText(md.Title)
+	md.Channel = sanitizeText(md.Channel)
+	if md.DurationSeconds < 0 {
+		md.DurationSeconds = 0
+	}
+	if md.EffectiveDurationSeconds < 0 {
+		md.EffectiveDurationSeconds = 0
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.streams[id]
+	if !ok {
+		return false
+	}
+	s.Metadata = &md
+	return true
+}
+
+// SetPreviews records which preview images have been generated.
+func (m *Manager) SetPreviews(id string, thumbnail, storyboard bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.streams[id]
+	if !ok {
+		return false
+	}
+	s.Thumbnail = thumbnail
+	s.Storyboard = storyboard
+	return true
+}
+
+// SetOwner ties a stream to the API key that created it.
+func (m *Manager) SetOwner(id, owner string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.streams[id]
+	if !ok {
+		return false
+	}
+	s.Owner = owner
+	return true
+}
+
+// SetLive marks a stream as a live relay.
+func (m *Manager) SetLive(id string, live bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.streams[id]
+	if !ok {
+		return false
+	}
+	s.Live = live
+	return true
+}
+
+// Retained reports whether s is a completed stream still inside its
+// retention window at now.
+func (m *Manager) Retained(s Stream, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return s.State == StateCompleted && (now.Sub(s.LastAccess) <= m.retention || now.Before(s.KeepUntil))
+}
+
+// ExpireInactive times out streams nobody has touched within the inactivity
+// timeout. A VOD transcode that is running is left to finish, watched or
+// not, since its output is kept; only live relays are stopped mid-run.
+// Completed streams time out once unplayed for the retention period, so
+// their output doesn't pile up.
 func (m *Manager) ExpireInactive(now time.Time) []string {
 	if now.IsZero() {
 		now = time.Now()
@@ -147,13 +418,18 @@ func (m *Manager) ExpireInactive(now time.Time) []string {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	for _, s := range m.streams {
-		if s.State == StateCompleted || s.State == StateError || s.State == StateTimedOut {
+		limit := m.timeout
+		switch {
+		case s.State == StateCompleted:
+			limit = m.retention
+		case s.State.Terminal(), s.State == StateActive && !s.Live:
 			continue
 		}
-		if now.Sub(s.LastAccess) <= m.timeout {
+		if now.Sub(s.LastAccess) <= limit || now.Before(s.KeepUntil) {
 			continue
 		}
 		s.State = StateTimedOut
+		s.ErrorCode = ErrCodeInactiveTimeout
 		s.Error = "inactive timeout"
 		expired = append(expired, s.ID)
 	}
@@ -39,3 +39,139 @@ func TestManager_ExpireInactive_MarksTimedOut(t *testing.T) {
 		t.Fatalf("expected timed_out, got %q", got.State)
 	}
 }
+
+func TestManager_ExpireInactive_OnlyStopsLiveTranscodes(t *testing.T) {
+	m := NewManager(5 * time.Minute)
+	vod, _ := m.Create(time.Unix(0, 0))
+	m.SetState(vod.ID, StateActive, "")
+	live, _ := m.Create(time.Unix(0, 0))
+	m.SetLive(live.ID, true)
+	m.SetState(live.ID, StateActive, "")
+
+	expired := m.ExpireInactive(time.Unix(0, 0).Add(10 * time.Minute))
+	if len(expired) != 1 || expired[0] != live.ID {
+		t.Fatalf("expected only the live relay to expire, got %v", expired)
+	}
+	if got, _ := m.Get(vod.ID); got.State != StateActive {
+		t.Fatalf("expected the VOD transcode to keep running, got %q", got.State)
+	}
+}
+
+func TestManager_ExpireInactive_DropsCompletedAfterRetention(t *testing.T) {
+	m := NewManager(5 * time.Minute)
+	m.SetRetention(time.Hour)
+	s, _ := m.Create(time.Unix(0, 0))
+	m.SetState(s.ID, StateCompleted, "")
+
+	if expired := m.ExpireInactive(time.Unix(0, 0).Add(30 * time.Minute)); len(expired) != 0 {
+		t.Fatalf("expected completed output to be kept within retention, got %v", expired)
+	}
+	m.Touch(s.ID, time.Unix(0, 0).Add(50*time.Minute))
+	if expired := m.ExpireInactive(time.Unix(0, 0).Add(90 * time.Minute)); len(expired) != 0 {
+		t.Fatalf("expected playback to restart the retention window, got %v", expired)
+	}
+	expired := m.ExpireInactive(time.Unix(0, 0).Add(2 * time.Hour))
+	if len(expired) != 1 || expired[0] != s.ID {
+		t.Fatalf("expected the completed stream to expire, got %v", expired)
+	}
+	if got, _ := m.Get(s.ID); got.State != StateTimedOut {
+		t.Fatalf("expected timed out, got %q", got.State)
+	}
+}
+
+func TestManager_Retained(t *testing.T) {
+	m := NewManager(5 * time.Minute)
+	m.SetRetention(time.Hour)
+	done, _ := m.Create(time.Unix(0, 0))
+	m.SetState(done.ID, StateCompleted, "")
+	failed, _ := m.Create(time.Unix(0, 0))
+	m.Fail(failed.ID, ErrCodeInternal, "boom")
+
+	for _, tc := range []struct {
+		id    string
+		after time.Duration
+		want  bool
+	}{
+		{done.ID, 30 * time.Minute, true},
+		{done.ID, 2 * time.Hour, false},
+		{failed.ID, time.Minute, false},
+	} {
+		s, _ := m.Get(tc.id)
+		if got := m.Retained(s, time.Unix(0, 0).Add(tc.after)); got != tc.want {
+			t.Fatalf("Retained(%s, +%s) = %v, want %v", s.State, tc.after, got, tc.want)
+		}
+	}
+}
+
+func TestManager_TerminalStatesStick(t *testing.T) {
+	m := NewManager(time.Minute)
+	s, _ := m.Create(time.Now())
+	if !m.Fail(s.ID, ErrCodeCanceled, "canceled") {
+		t.Fatalf("expected Fail to settle a running stream")
+	}
+	if m.SetState(s.ID, StateActive, "") || m.SetState(s.ID, StateCompleted, "") {
+		t.Fatalf("expected SetState to refuse leaving a terminal state")
+	}
+	if m.Fail(s.ID, ErrCodeEncoderFailed, "encoder failed") {
+		t.Fatalf("expected Fail to refuse overwriting a terminal state")
+	}
+	if got, _ := m.Get(s.ID); got.State != StateError || got.ErrorCode != ErrCodeCanceled {
+		t.Fatalf("expected the first settlement to stick, got %q/%q", got.State, got.ErrorCode)
+	}
+}
+
+func TestManager_SetMetadata_SanitizesText(t *testing.T) {
+	m := NewManager(5 * time.Minute)
+	s, err := m.Create(time.Unix(0, 0))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	m.SetMetadata(s.ID, Metadata{
+		Title:           "  Hello\x00\n\tworld‮\xff ",
+		Channel:         strings.Repeat("a", 500),
+		DurationSeconds: -1,
+	})
+	got, _ := m.Get(s.ID)
+	if got.Metadata == nil {
+		t.Fatalf("expected metadata")
+	}
+	if got.Metadata.Title != "Hello world" {
+		t.Fatalf("unexpected title %q", got.Metadata.Title)
+	}
+	if n := len([]rune(got.Metadata.Channel)); n != maxMetadataText {
+		t.Fatalf("expected channel capped at %d runes, got %d", maxMetadataText, n)
+	}
+	if got.Metadata.DurationSeconds != 0 {
+		t.Fatalf("expected negative duration to clamp to 0, got %d", got.Metadata.DurationSeconds)
+	}
+}
+
+func TestManager_List_OldestFirst(t *testing.T) {
+	m := NewManager(5 * time.Minute)
+	m.Register("b", time.Unix(20, 0))
+	m.Register("a", time.Unix(10, 0))
+	m.Register("c", time.Unix(20, 0))
+
+	var ids []string
+	for _, s := range m.List() {
+		ids = append(ids, s.ID)
+	}
+	if strings.Join(ids, ",") != "a,b,c" {
+		t.Fatalf("expected a,b,c, got %v", ids)
+	}
+}
+
+func TestManager_RestoreKeepsExistingStreams(t *testing.T) {
+	m := NewManager(time.Minute)
+	now := time.Now()
+	if !m.Restore(Stream{ID: "a", State: StateCompleted, Qualities: []string{"64x64"}, LastAccess: now}) {
+		t.Fatalf("expected restore")
+	}
+	if m.Restore(Stream{ID: "a", State: StateError}) {
+		t.Fatalf("expected a known ID to be left alone")
+	}
+	if s, _ := m.Get("a"); s.State != StateCompleted || len(s.Qualities) != 1 {
+		t.Fatalf("unexpected stream %+v", s)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os/exec"
 	"sync"
 	"syscall"
@@ -13,13 +14,32 @@ import (
 )
 
 type Resources struct {
-	mu     sync.Mutex
-	procs  map[string][]*exec.Cmd
-	logger zerolog.Logger
+	mu      sync.Mutex
+	procs   map[string][]*exec.Cmd
+	cancels map[string][]context.CancelFunc
+	stderr  map[string]io.WriterTo
+	logger  zerolog.Logger
 }
 
 func NewResources(logger zerolog.Logger) *Resources {
-	return &Resources{procs: map[string][]*exec.Cmd{}, logger: logger}
+	return &Resources{
+		procs:   map[string][]*exec.Cmd{},
+		cancels: map[string][]context.CancelFunc{},
+		stderr:  map[string]io.WriterTo{},
+		logger:  logger,
+	}
+}
+
+// RegisterCancel records the cancel func of work running for a stream, so
+// cleanup can stop processes it doesn't hold an *exec.Cmd for, such as the
+// ffmpeg and yt-dlp pipelines started through an Executor.
+func (r *Resources) RegisterCancel(streamID string, cancel context.CancelFunc) {
+	if r == nil || cancel == nil || streamID == "" {
+		return
+	}
+	r.mu.Lock()
+	r.cancels[streamID] = append(r.cancels[streamID], cancel)
+	r.mu.Unlock()
 }
 
 func (r *Resources) RegisterProcess(streamID string, cmd *exec.Cmd) {
@@ -31,6 +51,51 @@ func (r *Resources) RegisterProcess(streamID string, cmd *exec.Cmd) {
 	r.mu.Unlock()
 }
 
+// SetStderrLog keeps the stderr captured from a stream's processes. Unlike
+// the processes themselves it outlives Forget and CleanupStream, so a
+// failed or canceled stream can still be debugged; DropStderrLog ends it.
+func (r *Resources) SetStderrLog(streamID string, l io.WriterTo) {
+	if r == nil || l == nil || streamID == "" {
+		return
+	}
+	r.mu.Lock()
+	r.stderr[streamID] = l
+	r.mu.Unlock()
+}
+
+// StderrLog returns the stderr captured for a stream, or nil.
+func (r *Resources) StderrLog(streamID string) io.WriterTo {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stderr[streamID]
+}
+
+// DropStderrLog forgets the stderr captured for a stream, once the stream
+// itself is gone.
+func (r *Resources) DropStderrLog(streamID string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	delete(r.stderr, streamID)
+	r.mu.Unlock()
+}
+
+// Forget drops what is tracked for a stream without stopping anything, for
+// work that ended on its own.
+func (r *Resources) Forget(streamID string) {
+	if r == nil || streamID == "" {
+		return
+	}
+	r.mu.Lock()
+	delete(r.procs, streamID)
+	delete(r.cancels, streamID)
+	r.mu.Unlock()
+}
+
 func (r *Resources) CleanupStream(ctx context.Context, streamID string) {
 	if r == nil || streamID == "" {
 		return
@@ -38,9 +103,14 @@ func (r *Resources) CleanupStream(ctx context.Context, streamID string) {
 
 	r.mu.Lock()
 	cmds := append([]*exec.Cmd(nil), r.procs[streamID]...)
+	cancels := r.cancels[streamID]
 	delete(r.procs, streamID)
+	delete(r.cancels, streamID)
 	r.mu.Unlock()
 
+	for _, cancel := range cancels {
+		cancel()
+	}
 	for _, cmd := range cmds {
 		r.stopCmd(ctx, streamID, cmd)
 	}
@@ -53,9 +123,17 @@ func (r *Resources) CleanupAll(ctx context.Context) {
 
 	r.mu.Lock()
 	all := r.procs
+	cancels := r.cancels
 	r.procs = map[string][]*exec.Cmd{}
+	r.cancels = map[string][]context.CancelFunc{}
 	r.mu.Unlock()
 
+	for _, fns := range cancels {
+		for _, cancel := range fns {
+			cancel()
+		}
+	}
+
 	for id, cmds := range all {
 		for _, cmd := range cmds {
 			r.stopCmd(ctx, id, cmd)
@@ -113,11 +191,36 @@ func (r *Resources) logWaitResult(streamID string, pid int, err error) {
 	r.logger.Warn().Str("stream_id", streamID).Int("pid", pid).Err(err).Msg("process wait failed")
 }
 
+// Work describes what is tracked for one stream.
+type Work struct {
+	// PIDs lists processes registered directly; Pipelines counts work
+	// tracked by its cancel func, such as a yt-dlp → ffmpeg ladder.
+	PIDs      []int `json:"pids"`
+	Pipelines int   `json:"pipelines"`
+}
+
+// Tracked reports the work registered for a stream.
+func (r *Resources) Tracked(streamID string) Work {
+	w := Work{PIDs: []int{}}
+	if r == nil {
+		return w
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, cmd := range r.procs[streamID] {
+		if cmd != nil && cmd.Process != nil {
+			w.PIDs = append(w.PIDs, cmd.Process.Pid)
+		}
+	}
+	w.Pipelines = len(r.cancels[streamID])
+	return w
+}
+
 func (r *Resources) DebugCounts() string {
 	if r == nil {
 		return ""
 	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	return fmt.Sprintf("streams=%d", len(r.procs))
+	return fmt.Sprintf("streams=%d cancels=%d", len(r.procs), len(r.cancels))
 }
@@ -1,6 +1,7 @@
 package stream
 
 import (
+	"bytes"
 	"context"
 	"os/exec"
 	"runtime"
@@ -32,3 +33,34 @@ func TestResources_CleanupStream_StopsProcess(t *testing.T) {
 		t.Fatalf("expected process to be stopped")
 	}
 }
+
+func TestResources_CleanupStream_CallsCancel(t *testing.T) {
+	r := NewResources(zerolog.Nop())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.RegisterCancel("s", cancel)
+	r.RegisterCancel("other", func() { t.Fatalf("unexpected cancel of other stream") })
+
+	r.CleanupStream(context.Background(), "s")
+
+	if ctx.Err() == nil {
+		t.Fatalf("expected stream context to be cancelled")
+	}
+}
+
+func TestResources_StderrLogOutlivesCleanup(t *testing.T) {
+	r := NewResources(zerolog.Nop())
+	l := bytes.NewBufferString("ffmpeg exited\n")
+	r.SetStderrLog("s", l)
+
+	r.Forget("s")
+	r.CleanupStream(context.Background(), "s")
+	if r.StderrLog("s") != l {
+		t.Fatalf("expected stderr log to be kept after cleanup")
+	}
+
+	r.DropStderrLog("s")
+	if r.StderrLog("s") != nil {
+		t.Fatalf("expected stderr log to be dropped")
+	}
+}
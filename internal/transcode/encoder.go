@@ -0,0 +1,178 @@
+package transcode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Encoder builds the video codec arguments for one tier and describes the
+// stream it produces so the master playlist can advertise it.
+type Encoder interface {
+	// Name identifies the encoder in config and in non-default tier names.
+	Name() string
+
+	// VideoArgs returns the codec options placed after the video filter.
+	VideoArgs(opts EncodeOptions) []string
+
+	// Codec is the RFC 6381 codec string for the video track.
+	Codec() string
+
+	// Library is the ffmpeg encoder VideoArgs selects, so a readiness
+	// check can confirm the ffmpeg build has it.
+	Library() string
+
+	// SegmentType is the HLS segment container the codec is muxed into.
+	// VP9 and AV1 are only defined for fMP4 in HLS, so every encoder here
+	// reports "fmp4"; the method keeps the arg builder honest if an
+	// MPEG-TS-only encoder is ever added.
+	SegmentType() string
+}
+
+// EncodeOptions are the codec-independent quality knobs of an HLSRequest.
+// Zero values select the encoder's defaults.
+type EncodeOptions struct {
+	// Preset is a 1 (fastest) to 9 (slowest) speed scale that each encoder
+	// maps onto its own preset range.
+	Preset int
+	// CRF only applies to RateControlCappedCRF.
+	CRF         int
+	RateControl RateControl
+}
+
+const (
+	EncoderH264 = "h264"
+	EncoderVP9  = "vp9"
+	EncoderAV1  = "av1"
+)
+
+// EncoderByName returns the encoder registered under name.
+func EncoderByName(name string) (Encoder, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case EncoderH264, "libx264", "avc":
+		return H264Encoder{}, nil
+	case EncoderVP9, "libvpx-vp9":
+		return VP9Encoder{}, nil
+	case EncoderAV1, "libsvtav1":
+		return AV1Encoder{}, nil
+	default:
+		return nil, fmt.Errorf("unknown encoder %q", name)
+	}
+}
+
+// H264Encoder encodes with libx264 Baseline profile (ADR-009a), the one
+// codec every HLS client can play.
+type H264Encoder struct{}
+
+var x264Presets = map[int]string{
+	1: "ultrafast", 2: "superfast", 3: "veryfast", 4: "faster",
+	5: "fast", 6: "medium", 7: "slow", 8: "slower", 9: "veryslow",
+}
+
+func (H264Encoder) Name() string        { return EncoderH264 }
+func (H264Encoder) Codec() string       { return "avc1.42E01E" }
+func (H264Encoder) SegmentType() string { return "fmp4" }
+func (H264Encoder) Library() string     { return "libx264" }
+
+func (H264Encoder) VideoArgs(opts EncodeOptions) []string {
+	preset := x264Presets[opts.Preset]
+	if preset == "" {
+		preset = "medium"
+	}
+	crf := opts.CRF
+	if crf == 0 {
+		crf = 28 // Higher CRF for smaller files, still good quality
+	}
+	args := []string{
+		"-c:v", "libx264",
+		"-preset", preset,
+	}
+	args = append(args, rateArgs(opts.RateControl, crf)...)
+	// Scene-cut keyframes would break GOP alignment between tiers; only
+	// x264 and x265 know the option, the others place keyframes as told.
+	return append(args,
+		"-sc_threshold", "0",
+		"-profile:v", "baseline",
+		"-level", "3.0",
+		"-pix_fmt", "yuv420p",
+	)
+}
+
+// VP9Encoder encodes with libvpx-vp9, Profile 0 8-bit.
+type VP9Encoder struct{}
+
+func (VP9Encoder) Name() string        { return EncoderVP9 }
+func (VP9Encoder) Codec() string       { return "vp09.00.10.08" }
+func (VP9Encoder) SegmentType() string { return "fmp4" }
+func (VP9Encoder) Library() string     { return "libvpx-vp9" }
+
+func (VP9Encoder) VideoArgs(opts EncodeOptions) []string {
+	// cpu-used runs 0 (slowest) to 8 (fastest), the reverse of our scale.
+	cpuUsed := 4
+	if opts.Preset >= 1 && opts.Preset <= 9 {
+		cpuUsed = 9 - opts.Preset
+		if cpuUsed > 8 {
+			cpuUsed = 8
+		}
+	}
+	crf := opts.CRF
+	if crf == 0 {
+		crf = 36
+	}
+	args := []string{
+		"-c:v", "libvpx-vp9",
+		"-deadline", "good",
+		"-cpu-used", strconv.Itoa(cpuUsed),
+		"-row-mt", "1",
+	}
+
+	// libvpx treats -b:v next to -crf as the constrained-quality ceiling
+	// rather than a target, and needs -b:v 0 for unconstrained CRF.
+	rc := opts.RateControl
+	switch rc.mode() {
+	case RateControlCBR:
+		b := strings.TrimSpace(rc.Bitrate)
+		args = append(args, "-b:v", b, "-minrate", b, "-maxrate", b)
+	case RateControlTwoPass:
+		args = append(args, "-b:v", strings.TrimSpace(rc.Bitrate))
+	default:
+		ceiling := rc.maxRate()
+		if ceiling == "" {
+			ceiling = "0"
+		}
+		args = append(args, "-crf", strconv.Itoa(crf), "-b:v", ceiling)
+	}
+	return append(args, "-pix_fmt", "yuv420p")
+}
+
+// AV1Encoder encodes with SVT-AV1, Main profile 8-bit (ADR-009). Only
+// offered next to H.264 variants since browser support is still partial.
+type AV1Encoder struct{}
+
+func (AV1Encoder) Name() string        { return EncoderAV1 }
+func (AV1Encoder) Codec() string       { return "av01.0.00M.08" }
+func (AV1Encoder) SegmentType() string { return "fmp4" }
+func (AV1Encoder) Library() string     { return "libsvtav1" }
+
+func (AV1Encoder) VideoArgs(opts EncodeOptions) []string {
+	// SVT-AV1 presets run 0 (slowest) to 13 (fastest); map our 1-9 scale
+	// onto the practical 12-4 range.
+	preset := 8
+	if opts.Preset >= 1 && opts.Preset <= 9 {
+		preset = 13 - opts.Preset
+	}
+	crf := opts.CRF
+	if crf == 0 {
+		crf = 35
+	}
+	args := []string{
+		"-c:v", "libsvtav1",
+		"-preset", strconv.Itoa(preset),
+	}
+	args = append(args, rateArgs(opts.RateControl, crf)...)
+	if opts.RateControl.mode() == RateControlCBR {
+		// SVT-AV1 only does CBR with the low-delay prediction structure.
+		args = append(args, "-svtav1-params", "rc=2:pred-struct=1")
+	}
+	return append(args, "-pix_fmt", "yuv420p")
+}
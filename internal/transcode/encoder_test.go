@@ -0,0 +1,72 @@
+package transcode
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestEncoders_VideoArgs(t *testing.T) {
+	cases := []struct {
+		enc  Encoder
+		opts EncodeOptions
+		want []string
+	}{
+		{
+			enc:  H264Encoder{},
+			opts: EncodeOptions{Preset: 5},
+			want: []string{"-c:v", "libx264", "-preset", "fast", "-crf", "28", "-sc_threshold", "0", "-profile:v", "baseline", "-level", "3.0", "-pix_fmt", "yuv420p"},
+		},
+		{
+			enc:  VP9Encoder{},
+			opts: EncodeOptions{Preset: 5, CRF: 40},
+			want: []string{"-c:v", "libvpx-vp9", "-deadline", "good", "-cpu-used", "4", "-row-mt", "1", "-crf", "40", "-b:v", "0", "-pix_fmt", "yuv420p"},
+		},
+		{
+			enc:  AV1Encoder{},
+			opts: EncodeOptions{Preset: 5},
+			want: []string{"-c:v", "libsvtav1", "-preset", "8", "-crf", "35", "-pix_fmt", "yuv420p"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.enc.Name(), func(t *testing.T) {
+			got := tc.enc.VideoArgs(tc.opts)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("unexpected args:\n got %v\nwant %v", got, tc.want)
+			}
+			if tc.enc.SegmentType() != "fmp4" {
+				t.Fatalf("expected fmp4 segments, got %q", tc.enc.SegmentType())
+			}
+		})
+	}
+}
+
+func TestFFmpeg_TranscodeHLS_UsesRequestEncoder(t *testing.T) {
+	f := NewFFmpeg("ffmpeg", zerolog.Nop())
+
+	var gotArgs []string
+	f.Exec = ExecFunc(func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		_ = ctx
+		_ = name
+		gotArgs = append([]string(nil), args...)
+		return nil, nil, nil
+	})
+
+	_, err := f.TranscodeHLS(context.Background(), HLSRequest{InputURL: "u", OutputDir: t.TempDir(), Encoder: AV1Encoder{}})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	assertHasArgPair(t, gotArgs, "-c:v", "libsvtav1")
+	assertHasArgPair(t, gotArgs, "-hls_segment_type", "fmp4")
+	assertNoArg(t, gotArgs, "libx264")
+	assertNoArg(t, gotArgs, "-sc_threshold")
+}
+
+func TestEncoderByName_RejectsUnknown(t *testing.T) {
+	if _, err := EncoderByName("mpeg2"); err == nil {
+		t.Fatalf("expected error")
+	}
+}
@@ -0,0 +1,165 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// Command is one process invocation. Stdin and Stdout are optional
+// streams; when Stdout is nil the output is buffered and returned by Run.
+// Stderr is always buffered and returned, and also copied to Stderr when
+// one is set.
+type Command struct {
+	Name   string
+	Args   []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Executor runs a Command to completion. FFmpeg runs every process through
+// one, so tests can stand in for ffmpeg and yt-dlp, including consuming or
+// producing the piped video stream.
+type Executor interface {
+	Run(ctx context.Context, cmd Command) (stdout []byte, stderr []byte, err error)
+}
+
+type ExecFunc func(ctx context.Context, name string, args ...string) (stdout []byte, stderr []byte, err error)
+
+// Run adapts an ExecFunc to Executor. Stdin is ignored; stdout is copied to
+// cmd.Stdout when one is set, as a process would have written it there,
+// and stderr to cmd.Stderr.
+func (fn ExecFunc) Run(ctx context.Context, cmd Command) ([]byte, []byte, error) {
+	stdout, stderr, err := fn(ctx, cmd.Name, cmd.Args...)
+	if cmd.Stderr != nil && len(stderr) > 0 {
+		_, _ = cmd.Stderr.Write(stderr)
+	}
+	if cmd.Stdout != nil && len(stdout) > 0 {
+		if _, werr := cmd.Stdout.Write(stdout); werr != nil && err == nil {
+			err = werr
+		}
+		stdout = nil
+	}
+	return stdout, stderr, err
+}
+
+// OSExecutor runs commands as child processes.
+type OSExecutor struct{}
+
+func (OSExecutor) Run(ctx context.Context, c Command) ([]byte, []byte, error) {
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	cmd.Stdin = c.Stdin
+
+	var stdout, stderr bytes.Buffer
+	if c.Stdout != nil {
+		cmd.Stdout = c.Stdout
+	} else {
+		cmd.Stdout = &stdout
+	}
+	cmd.Stderr = &stderr
+	if c.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(&stderr, c.Stderr)
+	}
+
+	err := cmd.Run()
+	return stdout.Bytes(), stderr.Bytes(), err
+}
+
+// PipelineError reports a failed producer → ffmpeg pipeline with the
+// outcome of both processes. Err is the failure that brought the pipeline
+// down; the other side was cancelled in response.
+type PipelineError struct {
+	Err error
+	// ProducerFailed is set when the producer failed first.
+	ProducerFailed bool
+	// Reason classifies the failure with a sentinel such as ErrDiskFull,
+	// ErrEncoderFailed or one of the yt-dlp errors; it may be nil.
+	Reason error
+
+	Producer       string
+	ProducerExit   int
+	ProducerStderr string
+
+	FFmpegExit   int
+	FFmpegStderr string
+}
+
+// stderrTailBytes bounds the stderr kept on a PipelineError.
+const stderrTailBytes = 2048
+
+func newPipelineError(p *producer, producerFailed bool, ffmpegStderr []byte, ffmpegErr error) *PipelineError {
+	e := &PipelineError{
+		Err:            ffmpegErr,
+		ProducerFailed: producerFailed,
+		Producer:       p.name,
+		ProducerExit:   exitCode(p.err),
+		ProducerStderr: stderrTail(p.stderr),
+		FFmpegExit:     exitCode(ffmpegErr),
+		FFmpegStderr:   stderrTail(ffmpegStderr),
+	}
+	if producerFailed {
+		e.Err = p.err
+		e.Reason = ytdlpReason(p.stderr)
+	} else {
+		e.Reason = ffmpegReason(ffmpegStderr)
+	}
+	return e
+}
+
+func (e *PipelineError) Error() string {
+	name, stderr := "ffmpeg", e.FFmpegStderr
+	if e.ProducerFailed {
+		name, stderr = e.Producer, e.ProducerStderr
+	}
+	if stderr != "" {
+		return fmt.Sprintf("%s failed: %s", name, stderr)
+	}
+	return fmt.Sprintf("%s failed: %v", name, e.Err)
+}
+
+func (e *PipelineError) Unwrap() []error {
+	if e.Reason == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Err, e.Reason}
+}
+
+// ffmpegReason tells a full disk apart from any other encoder failure.
+func ffmpegReason(stderr []byte) error {
+	if strings.Contains(strings.ToLower(string(stderr)), "no space left on device") {
+		return ErrDiskFull
+	}
+	return ErrEncoderFailed
+}
+
+// exitCode returns a process's exit status: 0 on success, -1 when it didn't
+// exit normally (killed, never started, or a fake in tests).
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		return ee.ExitCode()
+	}
+	return -1
+}
+
+// stderrTail keeps the last stderrTailBytes of output, starting on a line
+// boundary where possible; the end is where tools print the actual error.
+func stderrTail(stderr []byte) string {
+	s := strings.TrimSpace(string(stderr))
+	if len(s) <= stderrTailBytes {
+		return s
+	}
+	s = s[len(s)-stderrTailBytes:]
+	if i := strings.IndexByte(s, '\n'); i >= 0 && i < len(s)-1 {
+		s = s[i+1:]
+	}
+	return s
+}
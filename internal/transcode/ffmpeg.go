@@ -1,12 +1,9 @@
 package transcode
 
 import (
-	"bytes"
 	"context"
-	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -16,12 +13,16 @@ import (
 
 type FFmpeg struct {
 	Path               string
-	Exec               ExecFunc
+	Exec               Executor
 	Logger             zerolog.Logger
 	MaxDurationSeconds int
+	// LiveWindowSegments is the playlist length of live relays.
+	LiveWindowSegments int
 }
 
 type HLSRequest struct {
+	// Input is the source; when nil, InputURL is opened by ffmpeg directly.
+	Input    Input
 	InputURL string
 
 	// OutputDir defaults to a temp dir under os.TempDir (usually /tmp).
@@ -32,19 +33,46 @@ type HLSRequest struct {
 
 	PlaylistName           string
 	SegmentDurationSeconds int
-	VideoPreset            int
-	VideoCRF               int
-	VideoBitrate           string
-	DisableAudio           bool
-	AudioBitrate           string
-	ExtraArgs              []string
+
+	// MaxFPS caps the output frame rate via an fps filter; zero keeps the
+	// source rate.
+	MaxFPS int
+	// GOPSeconds is the keyframe interval. It must divide
+	// SegmentDurationSeconds so every tier cuts segments on the same
+	// boundaries; otherwise (or when zero) the segment duration is used.
+	GOPSeconds int
+
+	// Encoder selects the video codec; nil means H.264.
+	Encoder Encoder
+
+	VideoPreset  int
+	VideoCRF     int
+	VideoBitrate string
+
+	// RateControl selects capped CRF (default), CBR or two-pass. The caps
+	// default to VideoBitrate; see RateControl.
+	RateControl  RateControlMode
+	VideoMaxRate string
+	VideoBufSize string
+
+	// Live produces a sliding-window playlist, deletes segments that fall
+	// out of it and ignores the max duration: a live relay runs until its
+	// context is cancelled or the broadcast ends.
+	Live bool
+
+	DisableAudio bool
+	AudioBitrate string
+	ExtraArgs    []string
 }
 
 type HLSResult struct {
 	OutputDir    string
 	PlaylistPath string
-	Stdout       []byte
-	Stderr       []byte
+	// Truncated is set when ffmpeg stopped at MaxDurationSeconds before a
+	// piped source had finished.
+	Truncated bool
+	Stdout    []byte
+	Stderr    []byte
 }
 
 func NewFFmpeg(path string, logger zerolog.Logger) *FFmpeg {
@@ -53,7 +81,7 @@ func NewFFmpeg(path string, logger zerolog.Logger) *FFmpeg {
 		Logger:             logger,
 		MaxDurationSeconds: 3600,
 	}
-	f.Exec = f.defaultExec
+	f.Exec = OSExecutor{}
 	return f
 }
 
@@ -63,179 +91,109 @@ func (f *FFmpeg) TranscodeHLSFromYtDlpPipe(ctx context.Context, youtubeURL strin
 	if youtubeURL == "" {
 		return HLSResult{}, fmt.Errorf("youtube url is required")
 	}
-	if ytdlpPath == "" {
-		ytdlpPath = "yt-dlp"
-	}
-
-	width := req.Width
-	height := req.Height
-	if width <= 0 {
-		width = 128
-	}
-	if height <= 0 {
-		height = 128
-	}
-
-	segmentDuration := req.SegmentDurationSeconds
-	if segmentDuration <= 0 {
-		segmentDuration = 4
-	}
-
-	preset := req.VideoPreset
-	if preset == 0 {
-		preset = 6 // "medium" preset for H.264
-	}
-
-	crf := req.VideoCRF
-	if crf == 0 {
-		crf = 28 // Higher CRF for smaller files, still good quality
-	}
-
-	playlistName := req.PlaylistName
-	if playlistName == "" {
-		playlistName = "index.m3u8"
-	}
+	req.Input = YtDLPInput{Path: ytdlpPath, URL: youtubeURL}
+	return f.TranscodeHLS(ctx, req)
+}
 
-	outDir := req.OutputDir
-	if outDir == "" {
-		tmp, err := os.MkdirTemp("", "blobtube-hls-")
-		if err != nil {
-			return HLSResult{}, fmt.Errorf("create temp output dir: %w", err)
-		}
-		outDir = tmp
-	} else {
-		if err := os.MkdirAll(outDir, 0o755); err != nil {
-			return HLSResult{}, fmt.Errorf("create output dir: %w", err)
+func (f *FFmpeg) TranscodeHLS(ctx context.Context, req HLSRequest) (HLSResult, error) {
+	input := req.Input
+	if input == nil {
+		if req.InputURL == "" {
+			return HLSResult{}, fmt.Errorf("input url is required")
 		}
+		input = URLInput(req.InputURL)
 	}
 
-	playlistPath := filepath.Join(outDir, playlistName)
-	segmentPattern := filepath.Join(outDir, "segment_%05d.m4s")
-
-	// yt-dlp command to download and output to stdout
-	ytdlpCmd := exec.CommandContext(ctx, ytdlpPath,
-		"--quiet",
-		"--no-warnings",
-		"--format", "best[acodec!=none][vcodec!=none]/best",
-		"--output", "-",
-		youtubeURL,
-	)
-
-	// Map preset number to H.264 preset string
-	presetMap := map[int]string{
-		1: "ultrafast", 2: "superfast", 3: "veryfast", 4: "faster",
-		5: "fast", 6: "medium", 7: "slow", 8: "slower", 9: "veryslow",
-	}
-	presetStr := presetMap[preset]
-	if presetStr == "" {
-		presetStr = "fast"
-	}
-
-	// FFmpeg command to read from stdin
-	ffmpegArgs := []string{
-		"-hide_banner",
-		"-y",
-		"-i", "pipe:0", // Read from stdin
-		"-t", strconv.Itoa(f.maxDurationSeconds()),
-		"-vf", fmt.Sprintf("scale=%d:%d:flags=lanczos", width, height),
-		"-c:v", "libx264",
-		"-preset", presetStr,
-		"-crf", strconv.Itoa(crf),
-		"-profile:v", "baseline",
-		"-level", "3.0",
-		"-pix_fmt", "yuv420p",
-		"-sc_threshold", "0",
-		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", segmentDuration),
-	}
-
-	if strings.TrimSpace(req.VideoBitrate) != "" {
-		ffmpegArgs = append(ffmpegArgs, "-b:v", strings.TrimSpace(req.VideoBitrate))
+	job, err := f.buildHLS(req, input)
+	if err != nil {
+		return HLSResult{}, err
 	}
+	res := HLSResult{OutputDir: job.outDir, PlaylistPath: job.playlistPath}
 
-	if req.DisableAudio {
-		ffmpegArgs = append(ffmpegArgs, "-an")
-	} else {
-		bitrate := strings.TrimSpace(req.AudioBitrate)
-		if bitrate == "" {
-			bitrate = "48k"
+	if job.firstPass != nil {
+		defer os.RemoveAll(job.passDir)
+		if stdout, stderr, err := f.Exec.Run(ctx, Command{Name: f.Path, Args: job.firstPass}); err != nil {
+			res.Stdout, res.Stderr = stdout, stderr
+			return res, ffmpegErr("ffmpeg first pass failed", stderr, err)
 		}
-		ffmpegArgs = append(ffmpegArgs, "-c:a", "aac", "-b:a", bitrate)
-	}
-
-	ffmpegArgs = append(ffmpegArgs,
-		"-f", "hls",
-		"-hls_time", strconv.Itoa(segmentDuration),
-		"-hls_list_size", "0",
-		"-hls_segment_type", "fmp4",
-		"-hls_fmp4_init_filename", "init.mp4",
-		"-hls_flags", "independent_segments",
-		"-movflags", "+frag_keyframe+empty_moov+default_base_moof",
-		"-hls_segment_filename", segmentPattern,
-	)
-
-	if len(req.ExtraArgs) > 0 {
-		ffmpegArgs = append(ffmpegArgs, req.ExtraArgs...)
 	}
 
-	ffmpegArgs = append(ffmpegArgs, playlistPath)
-
-	ffmpegCmd := exec.CommandContext(ctx, f.Path, ffmpegArgs...)
+	// Each side gets its own context so a failure on one can stop the
+	// other without touching the caller's.
+	producerCtx, cancelProducer := context.WithCancel(ctx)
+	defer cancelProducer()
+	ffmpegCtx, cancelFFmpeg := context.WithCancel(ctx)
+	defer cancelFFmpeg()
 
-	// Pipe yt-dlp stdout to FFmpeg stdin
-	pipe, err := ytdlpCmd.StdoutPipe()
+	p, err := input.start(producerCtx, f.Exec)
 	if err != nil {
-		return HLSResult{OutputDir: outDir, PlaylistPath: playlistPath}, fmt.Errorf("create pipe: %w", err)
-	}
-	ffmpegCmd.Stdin = pipe
-
-	// Capture stderr from both commands
-	var ytdlpStderr, ffmpegStderr bytes.Buffer
-	ytdlpCmd.Stderr = &ytdlpStderr
-	ffmpegCmd.Stderr = &ffmpegStderr
-
-	// Start yt-dlp
-	if err := ytdlpCmd.Start(); err != nil {
-		return HLSResult{OutputDir: outDir, PlaylistPath: playlistPath}, fmt.Errorf("start yt-dlp: %w", err)
-	}
-
-	// Start FFmpeg
-	if err := ffmpegCmd.Start(); err != nil {
-		ytdlpCmd.Process.Kill() // Kill yt-dlp if FFmpeg fails to start
-		return HLSResult{OutputDir: outDir, PlaylistPath: playlistPath}, fmt.Errorf("start ffmpeg: %w", err)
+		return res, err
 	}
-
-	// Wait for both processes
-	ytdlpErr := ytdlpCmd.Wait()
-	ffmpegErr := ffmpegCmd.Wait()
-
-	// Check for errors
-	if ytdlpErr != nil {
-		trimmed := strings.TrimSpace(ytdlpStderr.String())
-		if trimmed != "" {
-			return HLSResult{OutputDir: outDir, PlaylistPath: playlistPath, Stderr: ffmpegStderr.Bytes()},
-				fmt.Errorf("yt-dlp failed: %s", trimmed)
+	if p == nil {
+		stdout, stderr, err := f.Exec.Run(ffmpegCtx, Command{Name: f.Path, Args: job.args})
+		res.Stdout, res.Stderr = stdout, stderr
+		if err != nil {
+			return res, ffmpegErr("ffmpeg failed", stderr, err)
 		}
-		return HLSResult{OutputDir: outDir, PlaylistPath: playlistPath, Stderr: ffmpegStderr.Bytes()},
-			fmt.Errorf("yt-dlp failed: %w", ytdlpErr)
+		return res, nil
 	}
 
-	if ffmpegErr != nil {
-		trimmed := strings.TrimSpace(ffmpegStderr.String())
-		if trimmed != "" {
-			return HLSResult{OutputDir: outDir, PlaylistPath: playlistPath, Stderr: ffmpegStderr.Bytes()},
-				fmt.Errorf("ffmpeg failed: %s", trimmed)
+	// A producer that dies mid-stream would leave ffmpeg to finalize a
+	// silently short output; stop it instead.
+	go func() {
+		<-p.done
+		if p.err != nil {
+			cancelFFmpeg()
 		}
-		return HLSResult{OutputDir: outDir, PlaylistPath: playlistPath, Stderr: ffmpegStderr.Bytes()}, ffmpegErr
-	}
-
-	return HLSResult{OutputDir: outDir, PlaylistPath: playlistPath, Stderr: ffmpegStderr.Bytes()}, nil
+	}()
+
+	stdout, stderr, ffErr := f.Exec.Run(ffmpegCtx, Command{Name: f.Path, Args: job.args, Stdin: p.stdin})
+	res.Stdout, res.Stderr = stdout, stderr
+
+	// Our read end is still open, so a producer blocked on a full pipe
+	// can't have failed because ffmpeg exited: whatever state it's in now
+	// predates ffmpeg's exit.
+	producerFinished := false
+	select {
+	case <-p.done:
+		producerFinished = true
+	default:
+	}
+
+	p.release()
+	if !producerFinished {
+		cancelProducer()
+	}
+	<-p.done
+
+	switch {
+	case producerFinished && p.err != nil:
+		return res, newPipelineError(p, true, stderr, ffErr)
+	case ffErr != nil:
+		return res, newPipelineError(p, false, stderr, ffErr)
+	case !producerFinished:
+		// ffmpeg stopped reading and exited cleanly while the source was
+		// still flowing: it hit the -t cap.
+		res.Truncated = true
+		f.Logger.Debug().Str("producer", p.name).Msg("input truncated at max duration")
+	}
+	return res, nil
 }
 
-func (f *FFmpeg) TranscodeHLS(ctx context.Context, req HLSRequest) (HLSResult, error) {
-	if req.InputURL == "" {
-		return HLSResult{}, fmt.Errorf("input url is required")
-	}
+// hlsJob is an HLSRequest resolved into ffmpeg argv.
+type hlsJob struct {
+	outDir       string
+	playlistPath string
+	args         []string
+	// firstPass is the analysis run for two-pass encoding, otherwise nil;
+	// it leaves its stats in passDir for the second pass.
+	firstPass []string
+	passDir   string
+}
 
+// buildHLS applies the request defaults, creates the output directory and
+// builds the argv shared by every input kind.
+func (f *FFmpeg) buildHLS(req HLSRequest, input Input) (hlsJob, error) {
 	width := req.Width
 	height := req.Height
 	if width <= 0 {
@@ -250,14 +208,17 @@ func (f *FFmpeg) TranscodeHLS(ctx context.Context, req HLSRequest) (HLSResult, e
 		segmentDuration = 4
 	}
 
-	preset := req.VideoPreset
-	if preset == 0 {
-		preset = 6 // "medium" preset for H.264
+	enc := req.Encoder
+	if enc == nil {
+		enc = H264Encoder{}
 	}
 
-	crf := req.VideoCRF
-	if crf == 0 {
-		crf = 28 // Higher CRF for smaller files, still good quality
+	rc := req.rateControl()
+	if err := rc.validate(); err != nil {
+		return hlsJob{}, err
+	}
+	if rc.mode() == RateControlTwoPass && (req.Live || !input.seekable()) {
+		return hlsJob{}, fmt.Errorf("two-pass encoding needs a seekable input, not a pipe or live source")
 	}
 
 	playlistName := req.PlaylistName
@@ -269,62 +230,31 @@ func (f *FFmpeg) TranscodeHLS(ctx context.Context, req HLSRequest) (HLSResult, e
 	if outDir == "" {
 		tmp, err := os.MkdirTemp("", "blobtube-hls-")
 		if err != nil {
-			return HLSResult{}, fmt.Errorf("create temp output dir: %w", err)
+			return hlsJob{}, fmt.Errorf("create temp output dir: %w", err)
 		}
 		outDir = tmp
 	} else {
 		if err := os.MkdirAll(outDir, 0o755); err != nil {
-			return HLSResult{}, fmt.Errorf("create output dir: %w", err)
+			return hlsJob{}, fmt.Errorf("create output dir: %w", err)
 		}
 	}
 
 	playlistPath := filepath.Join(outDir, playlistName)
 	segmentPattern := filepath.Join(outDir, "segment_%05d.m4s")
 
-	// Map preset number to H.264 preset string
-	presetMap := map[int]string{
-		1: "ultrafast", 2: "superfast", 3: "veryfast", 4: "faster",
-		5: "fast", 6: "medium", 7: "slow", 8: "slower", 9: "veryslow",
-	}
-	presetStr := presetMap[preset]
-	if presetStr == "" {
-		presetStr = "medium"
-	}
-
-	args := []string{
+	videoArgs := []string{
 		"-hide_banner",
 		"-y",
-		"-i",
-		req.InputURL,
-		"-t",
-		strconv.Itoa(f.maxDurationSeconds()),
-		"-vf",
-		fmt.Sprintf("scale=%d:%d:flags=lanczos", width, height),
-		"-c:v",
-		"libx264",
-		"-preset",
-		presetStr,
-		"-crf",
-		strconv.Itoa(crf),
-		"-profile:v",
-		"baseline",
-		"-level",
-		"3.0",
-		"-pix_fmt",
-		"yuv420p",
-		"-sc_threshold",
-		"0",
-		"-force_key_frames",
-		fmt.Sprintf("expr:gte(t,n_forced*%d)", segmentDuration),
+		"-i", input.ffmpegArg(),
 	}
-
-	if strings.TrimSpace(req.VideoBitrate) != "" {
-		args = append(args,
-			"-b:v",
-			strings.TrimSpace(req.VideoBitrate),
-		)
+	if !req.Live {
+		videoArgs = append(videoArgs, "-t", strconv.Itoa(f.maxDurationSeconds()))
 	}
+	videoArgs = append(videoArgs, "-vf", videoFilter(width, height, req.MaxFPS))
+	videoArgs = append(videoArgs, enc.VideoArgs(EncodeOptions{Preset: req.VideoPreset, CRF: req.VideoCRF, RateControl: rc})...)
+	videoArgs = append(videoArgs, keyframeArgs(req.MaxFPS, req.GOPSeconds, segmentDuration)...)
 
+	args := append([]string(nil), videoArgs...)
 	if req.DisableAudio {
 		args = append(args, "-an")
 	} else {
@@ -332,47 +262,92 @@ func (f *FFmpeg) TranscodeHLS(ctx context.Context, req HLSRequest) (HLSResult, e
 		if bitrate == "" {
 			bitrate = "48k"
 		}
-		args = append(args,
-			"-c:a", "aac",
-			"-b:a", bitrate,
-		)
+		args = append(args, "-c:a", "aac", "-b:a", bitrate)
+	}
+
+	listSize, flags := "0", "independent_segments"
+	if req.Live {
+		listSize, flags = strconv.Itoa(f.liveWindowSegments()), "independent_segments+delete_segments"
 	}
 
 	args = append(args,
-		"-f",
-		"hls",
-		"-hls_time",
-		strconv.Itoa(segmentDuration),
-		"-hls_list_size",
-		"0",
-		"-hls_segment_type",
-		"fmp4",
-		"-hls_fmp4_init_filename",
-		"init.mp4",
-		"-hls_flags",
-		"independent_segments",
-		"-movflags",
-		"+frag_keyframe+empty_moov+default_base_moof",
-		"-hls_segment_filename",
-		segmentPattern,
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(segmentDuration),
+		"-hls_list_size", listSize,
+		"-hls_segment_type", enc.SegmentType(),
+		"-hls_fmp4_init_filename", "init.mp4",
+		"-hls_flags", flags,
+		"-movflags", "+frag_keyframe+empty_moov+default_base_moof",
+		"-hls_segment_filename", segmentPattern,
 	)
 
+	job := hlsJob{outDir: outDir, playlistPath: playlistPath}
+
+	if rc.mode() == RateControlTwoPass {
+		// The second pass reads the stats written by the first. They are
+		// kept out of outDir, which is served and cached as it is.
+		passDir, err := os.MkdirTemp("", "blobtube-2pass-")
+		if err != nil {
+			return hlsJob{}, fmt.Errorf("create pass log dir: %w", err)
+		}
+		job.passDir = passDir
+		passLog := filepath.Join(passDir, "ffmpeg2pass")
+		job.firstPass = append(videoArgs, "-pass", "1", "-passlogfile", passLog, "-an", "-f", "null", os.DevNull)
+		args = append(args, "-pass", "2", "-passlogfile", passLog)
+	}
+
 	if len(req.ExtraArgs) > 0 {
 		args = append(args, req.ExtraArgs...)
 	}
 
-	args = append(args, playlistPath)
+	job.args = append(args, playlistPath)
+	return job, nil
+}
 
-	stdout, stderr, err := f.Exec(ctx, f.Path, args...)
-	if err != nil {
-		trimmed := strings.TrimSpace(string(stderr))
-		if trimmed == "" {
-			return HLSResult{OutputDir: outDir, PlaylistPath: playlistPath, Stdout: stdout, Stderr: stderr}, err
-		}
-		return HLSResult{OutputDir: outDir, PlaylistPath: playlistPath, Stdout: stdout, Stderr: stderr}, fmt.Errorf("ffmpeg failed: %s", trimmed)
+// ffmpegErr prefers ffmpeg's own diagnostics over a bare exit status and
+// wraps the sentinel classifying the failure.
+func ffmpegErr(prefix string, stderr []byte, err error) error {
+	trimmed := strings.TrimSpace(string(stderr))
+	if trimmed == "" {
+		return fmt.Errorf("%s: %w: %w", prefix, ffmpegReason(stderr), err)
 	}
+	return fmt.Errorf("%s: %s: %w", prefix, trimmed, ffmpegReason(stderr))
+}
+
+// videoFilter builds the -vf chain: an optional frame-rate cap followed by
+// the scale to the tier resolution.
+func videoFilter(width, height, maxFPS int) string {
+	scale := fmt.Sprintf("scale=%d:%d:flags=lanczos", width, height)
+	if maxFPS <= 0 {
+		return scale
+	}
+	return fmt.Sprintf("fps=%d,%s", maxFPS, scale)
+}
 
-	return HLSResult{OutputDir: outDir, PlaylistPath: playlistPath, Stdout: stdout, Stderr: stderr}, nil
+// keyframeArgs forces a keyframe every GOP so segments start on the same
+// timestamps in every tier, which keeps ABR switches on segment boundaries.
+// With a fixed frame rate the GOP is also pinned in frames.
+func keyframeArgs(maxFPS, gopSeconds, segmentDuration int) []string {
+	gop := gopSeconds
+	if gop <= 0 || segmentDuration%gop != 0 {
+		gop = segmentDuration
+	}
+
+	args := []string{"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", gop)}
+	if maxFPS > 0 {
+		frames := strconv.Itoa(maxFPS * gop)
+		args = append(args, "-g", frames, "-keyint_min", frames)
+	}
+	return args
+}
+
+func (req HLSRequest) rateControl() RateControl {
+	return RateControl{
+		Mode:    req.RateControl,
+		Bitrate: req.VideoBitrate,
+		MaxRate: req.VideoMaxRate,
+		BufSize: req.VideoBufSize,
+	}
 }
 
 func (f *FFmpeg) maxDurationSeconds() int {
@@ -382,21 +357,9 @@ func (f *FFmpeg) maxDurationSeconds() int {
 	return f.MaxDurationSeconds
 }
 
-func (f *FFmpeg) defaultExec(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
-	cmd := exec.CommandContext(ctx, name, args...)
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	err := cmd.Run()
-	if err == nil {
-		return stdout.Bytes(), stderr.Bytes(), nil
-	}
-
-	var ee *exec.ExitError
-	if errors.As(err, &ee) {
-		return stdout.Bytes(), stderr.Bytes(), err
+func (f *FFmpeg) liveWindowSegments() int {
+	if f.LiveWindowSegments <= 0 {
+		return 6
 	}
-	return stdout.Bytes(), stderr.Bytes(), err
+	return f.LiveWindowSegments
 }
@@ -22,8 +22,8 @@ func TestFFmpeg_TranscodeHLS_GeneratesPlaylistAndSegments(t *testing.T) {
 
 	// Best-effort check that the encoder exists, otherwise this test is noisy.
 	encOut, _ := exec.Command(ffmpegPath, "-hide_banner", "-encoders").CombinedOutput()
-	if !strings.Contains(string(encOut), "libsvtav1") {
-		t.Skip("ffmpeg missing libsvtav1 encoder")
+	if !strings.Contains(string(encOut), "libx264") {
+		t.Skip("ffmpeg missing libx264 encoder")
 	}
 
 	_, thisFile, _, ok := runtime.Caller(0)
@@ -48,7 +48,7 @@ func TestFFmpeg_TranscodeHLS_GeneratesPlaylistAndSegments(t *testing.T) {
 	if _, statErr := os.Stat(res.PlaylistPath); statErr != nil {
 		t.Fatalf("expected playlist to exist: %v", statErr)
 	}
-	segments, gerr := filepath.Glob(filepath.Join(outDir, "segment_*.ts"))
+	segments, gerr := filepath.Glob(filepath.Join(outDir, "segment_*.m4s"))
 	if gerr != nil {
 		t.Fatalf("glob segments: %v", gerr)
 	}
@@ -3,10 +3,12 @@ package transcode
 import (
 	"context"
 	"errors"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
 )
@@ -15,14 +17,14 @@ func TestFFmpeg_TranscodeHLS_BuildsCommandDefaults(t *testing.T) {
 	f := NewFFmpeg("ffmpeg", zerolog.Nop())
 
 	var gotArgs []string
-	f.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+	f.Exec = ExecFunc(func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
 		_ = ctx
 		if name != "ffmpeg" {
 			t.Fatalf("expected ffmpeg binary, got %q", name)
 		}
 		gotArgs = append([]string(nil), args...)
 		return []byte("ok"), []byte("warn"), nil
-	}
+	})
 
 	res, err := f.TranscodeHLS(context.Background(), HLSRequest{InputURL: "https://example/video"})
 	if err != nil {
@@ -41,26 +43,82 @@ func TestFFmpeg_TranscodeHLS_BuildsCommandDefaults(t *testing.T) {
 		t.Fatalf("expected playlist to be in output dir")
 	}
 
-	assertHasArgPair(t, gotArgs, "-c:v", "libsvtav1")
-	assertHasArgPair(t, gotArgs, "-preset", "8")
-	assertHasArgPair(t, gotArgs, "-crf", "35")
+	assertHasArgPair(t, gotArgs, "-c:v", "libx264")
+	assertHasArgPair(t, gotArgs, "-preset", "medium")
+	assertHasArgPair(t, gotArgs, "-crf", "28")
 	assertHasArgPair(t, gotArgs, "-t", "3600")
 	assertHasArgPair(t, gotArgs, "-vf", "scale=128:128:flags=lanczos")
 	assertHasArgPair(t, gotArgs, "-f", "hls")
 	assertHasArgPair(t, gotArgs, "-hls_time", "4")
 	assertHasArgPair(t, gotArgs, "-c:a", "aac")
+	assertHasArgPair(t, gotArgs, "-force_key_frames", "expr:gte(t,n_forced*4)")
+	assertNoArg(t, gotArgs, "-g")
 }
 
-func TestFFmpeg_TranscodeHLS_UsesProvidedOutputDirAndDisablesAudio(t *testing.T) {
+func TestFFmpeg_TranscodeHLS_CapsFrameRateAndAlignsGOP(t *testing.T) {
 	f := NewFFmpeg("ffmpeg", zerolog.Nop())
 
 	var gotArgs []string
-	f.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+	f.Exec = ExecFunc(func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
 		_ = ctx
 		_ = name
 		gotArgs = append([]string(nil), args...)
 		return nil, nil, nil
+	})
+
+	_, err := f.TranscodeHLS(context.Background(), HLSRequest{
+		InputURL:               "u",
+		OutputDir:              t.TempDir(),
+		Width:                  64,
+		Height:                 64,
+		MaxFPS:                 10,
+		GOPSeconds:             2,
+		SegmentDurationSeconds: 4,
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	assertHasArgPair(t, gotArgs, "-vf", "fps=10,scale=64:64:flags=lanczos")
+	assertHasArgPair(t, gotArgs, "-force_key_frames", "expr:gte(t,n_forced*2)")
+	assertHasArgPair(t, gotArgs, "-g", "20")
+	assertHasArgPair(t, gotArgs, "-keyint_min", "20")
+}
+
+func TestFFmpeg_TranscodeHLS_LiveUsesSlidingWindow(t *testing.T) {
+	f := NewFFmpeg("ffmpeg", zerolog.Nop())
+	f.LiveWindowSegments = 5
+
+	var gotArgs []string
+	f.Exec = ExecFunc(func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		gotArgs = append([]string(nil), args...)
+		return nil, nil, nil
+	})
+
+	_, err := f.TranscodeHLS(context.Background(), HLSRequest{InputURL: "u", OutputDir: t.TempDir(), Live: true})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
 	}
+	assertHasArgPair(t, gotArgs, "-hls_list_size", "5")
+	assertHasArgPair(t, gotArgs, "-hls_flags", "independent_segments+delete_segments")
+	assertNoArg(t, gotArgs, "-t")
+}
+
+func TestKeyframeArgs_FallsBackToSegmentDurationWhenGOPMisaligned(t *testing.T) {
+	args := keyframeArgs(15, 3, 4)
+	assertHasArgPair(t, args, "-force_key_frames", "expr:gte(t,n_forced*4)")
+	assertHasArgPair(t, args, "-g", "60")
+}
+
+func TestFFmpeg_TranscodeHLS_UsesProvidedOutputDirAndDisablesAudio(t *testing.T) {
+	f := NewFFmpeg("ffmpeg", zerolog.Nop())
+
+	var gotArgs []string
+	f.Exec = ExecFunc(func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		_ = ctx
+		_ = name
+		gotArgs = append([]string(nil), args...)
+		return nil, nil, nil
+	})
 
 	outDir := t.TempDir()
 	res, err := f.TranscodeHLS(context.Background(), HLSRequest{InputURL: "u", OutputDir: outDir, DisableAudio: true, PlaylistName: "out.m3u8"})
@@ -78,12 +136,12 @@ func TestFFmpeg_TranscodeHLS_UsesProvidedOutputDirAndDisablesAudio(t *testing.T)
 
 func TestFFmpeg_TranscodeHLS_PropagatesStderrOnError(t *testing.T) {
 	f := NewFFmpeg("ffmpeg", zerolog.Nop())
-	f.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+	f.Exec = ExecFunc(func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
 		_ = ctx
 		_ = name
 		_ = args
 		return []byte("stdout"), []byte("something bad\n"), errors.New("exit status 1")
-	}
+	})
 
 	res, err := f.TranscodeHLS(context.Background(), HLSRequest{InputURL: "u", OutputDir: t.TempDir()})
 	if err == nil {
@@ -110,6 +168,15 @@ func assertHasArgPair(t *testing.T, args []string, k, v string) {
 	t.Fatalf("expected args to include %q %q; got %v", k, v, args)
 }
 
+func assertNoArg(t *testing.T, args []string, unwanted string) {
+	t.Helper()
+	for _, a := range args {
+		if a == unwanted {
+			t.Fatalf("expected args not to include %q; got %v", unwanted, args)
+		}
+	}
+}
+
 func assertHasArg(t *testing.T, args []string, want string) {
 	t.Helper()
 	for _, a := range args {
@@ -119,3 +186,206 @@ func assertHasArg(t *testing.T, args []string, want string) {
 	}
 	t.Fatalf("expected args to include %q; got %v", want, args)
 }
+
+// executorFunc lets tests fake processes that use stdin and stdout.
+type executorFunc func(ctx context.Context, cmd Command) ([]byte, []byte, error)
+
+func (fn executorFunc) Run(ctx context.Context, cmd Command) ([]byte, []byte, error) {
+	return fn(ctx, cmd)
+}
+
+func TestFFmpeg_TranscodeHLSFromYtDlpPipe_StreamsYtDlpIntoFFmpeg(t *testing.T) {
+	f := NewFFmpeg("ffmpeg", zerolog.Nop())
+
+	var ytdlpArgs, ffmpegArgs []string
+	var piped []byte
+	f.Exec = executorFunc(func(ctx context.Context, cmd Command) ([]byte, []byte, error) {
+		switch cmd.Name {
+		case "yt-dlp":
+			ytdlpArgs = cmd.Args
+			_, err := cmd.Stdout.Write([]byte("video bytes"))
+			return nil, nil, err
+		case "ffmpeg":
+			ffmpegArgs = cmd.Args
+			b, err := io.ReadAll(cmd.Stdin)
+			piped = b
+			return nil, nil, err
+		}
+		t.Fatalf("unexpected command %q", cmd.Name)
+		return nil, nil, nil
+	})
+
+	res, err := f.TranscodeHLSFromYtDlpPipe(context.Background(), "https://youtube.example/watch?v=abc", "", HLSRequest{OutputDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if string(piped) != "video bytes" {
+		t.Fatalf("expected ffmpeg stdin to carry yt-dlp output, got %q", piped)
+	}
+	assertHasArgPair(t, ytdlpArgs, "--output", "-")
+	assertHasArg(t, ytdlpArgs, "https://youtube.example/watch?v=abc")
+	assertHasArgPair(t, ffmpegArgs, "-i", "pipe:0")
+	// Same defaults as TranscodeHLS, from the shared builder.
+	assertHasArgPair(t, ffmpegArgs, "-preset", "medium")
+	assertHasArgPair(t, ffmpegArgs, "-hls_time", "4")
+	if ffmpegArgs[len(ffmpegArgs)-1] != res.PlaylistPath {
+		t.Fatalf("expected playlist path as last arg")
+	}
+}
+
+func TestFFmpeg_TranscodeHLSFromYtDlpPipe_PropagatesYtDlpError(t *testing.T) {
+	f := NewFFmpeg("ffmpeg", zerolog.Nop())
+	f.Exec = executorFunc(func(ctx context.Context, cmd Command) ([]byte, []byte, error) {
+		if cmd.Name == "yt-dlp" {
+			return nil, []byte("ERROR: Video unavailable\n"), errors.New("exit status 1")
+		}
+		_, err := io.Copy(io.Discard, cmd.Stdin)
+		return nil, nil, err
+	})
+
+	_, err := f.TranscodeHLSFromYtDlpPipe(context.Background(), "https://youtube.example/watch?v=abc", "yt-dlp", HLSRequest{OutputDir: t.TempDir()})
+	if err == nil || !strings.Contains(err.Error(), "yt-dlp failed: ERROR: Video unavailable") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestFFmpeg_TranscodeHLS_ReaderInput(t *testing.T) {
+	f := NewFFmpeg("ffmpeg", zerolog.Nop())
+
+	var piped []byte
+	f.Exec = executorFunc(func(ctx context.Context, cmd Command) ([]byte, []byte, error) {
+		b, err := io.ReadAll(cmd.Stdin)
+		piped = b
+		return nil, nil, err
+	})
+
+	_, err := f.TranscodeHLS(context.Background(), HLSRequest{Input: ReaderInput{R: strings.NewReader("abc")}, OutputDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if string(piped) != "abc" {
+		t.Fatalf("expected reader to be piped, got %q", piped)
+	}
+}
+
+// endlessYtDlp fakes a yt-dlp that keeps writing until its context is
+// cancelled or the pipe breaks.
+func endlessYtDlp(ctx context.Context, cmd Command) ([]byte, []byte, error) {
+	chunk := make([]byte, 32*1024)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, []byte("interrupted"), err
+		}
+		if _, err := cmd.Stdout.Write(chunk); err != nil {
+			return nil, []byte("broken pipe"), err
+		}
+	}
+}
+
+func TestFFmpeg_TranscodeHLSFromYtDlpPipe_FFmpegFailureStopsYtDlp(t *testing.T) {
+	f := NewFFmpeg("ffmpeg", zerolog.Nop())
+	f.Exec = executorFunc(func(ctx context.Context, cmd Command) ([]byte, []byte, error) {
+		if cmd.Name == "yt-dlp" {
+			return endlessYtDlp(ctx, cmd)
+		}
+		// Bad input: ffmpeg gives up without draining stdin.
+		return nil, []byte("pipe:0: Invalid data found when processing input\n"), errors.New("exit status 1")
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := f.TranscodeHLSFromYtDlpPipe(context.Background(), "https://youtube.example/watch?v=abc", "yt-dlp", HLSRequest{OutputDir: t.TempDir()})
+		done <- err
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("pipeline did not stop yt-dlp after ffmpeg failed")
+	}
+
+	var pe *PipelineError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected PipelineError, got %v", err)
+	}
+	if pe.ProducerFailed {
+		t.Fatalf("expected ffmpeg to be reported as the cause")
+	}
+	if !strings.Contains(pe.FFmpegStderr, "Invalid data") {
+		t.Fatalf("expected ffmpeg stderr tail, got %q", pe.FFmpegStderr)
+	}
+	if !strings.Contains(err.Error(), "ffmpeg failed: pipe:0: Invalid data") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestFFmpeg_TranscodeHLSFromYtDlpPipe_YtDlpFailureCancelsFFmpeg(t *testing.T) {
+	f := NewFFmpeg("ffmpeg", zerolog.Nop())
+	f.Exec = executorFunc(func(ctx context.Context, cmd Command) ([]byte, []byte, error) {
+		if cmd.Name == "yt-dlp" {
+			return nil, []byte("ERROR: HTTP Error 403: Forbidden\n"), errors.New("exit status 1")
+		}
+		// A stuck ffmpeg only ends when cancelled.
+		<-ctx.Done()
+		return nil, nil, ctx.Err()
+	})
+
+	_, err := f.TranscodeHLSFromYtDlpPipe(context.Background(), "https://youtube.example/watch?v=abc", "yt-dlp", HLSRequest{OutputDir: t.TempDir()})
+	var pe *PipelineError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected PipelineError, got %v", err)
+	}
+	if !pe.ProducerFailed || pe.Producer != "yt-dlp" {
+		t.Fatalf("expected yt-dlp to be reported as the cause, got %+v", pe)
+	}
+	if pe.ProducerExit != -1 || pe.FFmpegExit != -1 {
+		t.Fatalf("unexpected exit codes %d/%d", pe.ProducerExit, pe.FFmpegExit)
+	}
+	if !strings.Contains(err.Error(), "yt-dlp failed: ERROR: HTTP Error 403") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestFFmpeg_TranscodeHLSFromYtDlpPipe_DurationCapIsTruncation(t *testing.T) {
+	f := NewFFmpeg("ffmpeg", zerolog.Nop())
+	f.Exec = executorFunc(func(ctx context.Context, cmd Command) ([]byte, []byte, error) {
+		if cmd.Name == "yt-dlp" {
+			return endlessYtDlp(ctx, cmd)
+		}
+		// Read a little, then stop as ffmpeg does at the -t cap.
+		_, err := io.ReadFull(cmd.Stdin, make([]byte, 1024))
+		return nil, nil, err
+	})
+
+	res, err := f.TranscodeHLSFromYtDlpPipe(context.Background(), "https://youtube.example/watch?v=abc", "yt-dlp", HLSRequest{OutputDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if !res.Truncated {
+		t.Fatalf("expected result to be marked truncated")
+	}
+}
+
+func TestStderrTail_KeepsEndOnLineBoundary(t *testing.T) {
+	long := strings.Repeat("noise line\n", 400) + "the real error"
+	got := stderrTail([]byte(long))
+	if len(got) > stderrTailBytes {
+		t.Fatalf("expected at most %d bytes, got %d", stderrTailBytes, len(got))
+	}
+	if !strings.HasPrefix(got, "noise line\n") || !strings.HasSuffix(got, "the real error") {
+		t.Fatalf("unexpected tail %q", got)
+	}
+}
+
+func TestFFmpeg_TranscodeHLS_ClassifiesDiskFull(t *testing.T) {
+	f := NewFFmpeg("ffmpeg", zerolog.Nop())
+	f.Exec = ExecFunc(func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		return nil, []byte("segment_00003.m4s: No space left on device\n"), errors.New("exit status 1")
+	})
+
+	_, err := f.TranscodeHLS(context.Background(), HLSRequest{InputURL: "u", OutputDir: t.TempDir()})
+	if !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("expected ErrDiskFull, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package transcode
+
+import (
+	"context"
+	"sync"
+)
+
+// flightGroup collapses concurrent calls with the same key into one, in
+// the manner of golang.org/x/sync/singleflight, for the one result type
+// this package needs.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done chan struct{}
+	info StreamInfo
+	err  error
+
+	// waiters counts the callers still waiting; when the last gives up,
+	// cancel stops the run. Both are guarded by flightGroup.mu.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// do runs fn once per key at a time; callers arriving while it runs wait
+// for and share its result. shared reports whether the result came from
+// another caller's run.
+//
+// fn runs on a context of its own, carrying the first caller's values but
+// not its cancellation, so one caller going away doesn't fail the others.
+// Each caller stops waiting when its own ctx is done; fn is canceled once
+// nobody is left waiting.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(context.Context) (StreamInfo, error)) (info StreamInfo, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*flightCall{}
+	}
+	c, shared := g.calls[key]
+	if !shared {
+		runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &flightCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = c
+		go func() {
+			defer cancel()
+			c.info, c.err = fn(runCtx)
+			g.mu.Lock()
+			g.forget(key, c)
+			g.mu.Unlock()
+			close(c.done)
+		}()
+	}
+	c.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.info, c.err, shared
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			c.cancel()
+			g.forget(key, c)
+		}
+		g.mu.Unlock()
+		return StreamInfo{}, ctx.Err(), shared
+	}
+}
+
+// forget lets the next caller for key start a new run. Callers hold mu.
+func (g *flightGroup) forget(key string, c *flightCall) {
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+}
@@ -0,0 +1,162 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Input is the source ffmpeg reads from: a URL or file path it opens
+// itself, or a stream fed to its stdin.
+type Input interface {
+	// ffmpegArg is the value of ffmpeg's -i option.
+	ffmpegArg() string
+
+	// seekable reports whether ffmpeg can read the input twice, which
+	// two-pass encoding needs.
+	seekable() bool
+
+	// start launches whatever feeds ffmpeg's stdin. It returns nil when
+	// ffmpeg opens the input itself.
+	start(ctx context.Context, ex Executor) (*producer, error)
+}
+
+// producer feeds ffmpeg's stdin, possibly from a process of its own.
+type producer struct {
+	// name is the producing process, empty when there is none.
+	name  string
+	stdin io.Reader
+
+	// done is closed once the producer has finished; err and stderr are
+	// valid after that.
+	done   chan struct{}
+	err    error
+	stderr []byte
+
+	// release closes the consumer's end of the stream so a producer still
+	// writing fails instead of blocking.
+	release func()
+}
+
+// URLInput is a URL or local path ffmpeg opens directly.
+type URLInput string
+
+func (u URLInput) ffmpegArg() string { return string(u) }
+func (u URLInput) seekable() bool    { return true }
+
+func (u URLInput) start(context.Context, Executor) (*producer, error) {
+	return nil, nil
+}
+
+// ReaderInput streams an already open reader into ffmpeg's stdin.
+type ReaderInput struct {
+	R io.Reader
+}
+
+func (in ReaderInput) ffmpegArg() string { return "pipe:0" }
+func (in ReaderInput) seekable() bool    { return false }
+
+func (in ReaderInput) start(context.Context, Executor) (*producer, error) {
+	if in.R == nil {
+		return nil, fmt.Errorf("input reader is required")
+	}
+	done := make(chan struct{})
+	close(done)
+	return &producer{stdin: in.R, done: done, release: func() {}}, nil
+}
+
+// YtDLPInput downloads a video with yt-dlp and pipes it into ffmpeg. Passing
+// YouTube stream URLs straight to ffmpeg gets 403s (TODO.md, lesson 2).
+type YtDLPInput struct {
+	// Path defaults to "yt-dlp".
+	Path string
+	URL  string
+	// Args are extra options placed before the URL; see YtDLP.Input.
+	Args []string
+
+	// secrets are scrubbed from the producer's stderr.
+	secrets []string
+}
+
+func (in YtDLPInput) ffmpegArg() string { return "pipe:0" }
+func (in YtDLPInput) seekable() bool    { return false }
+
+// command is the yt-dlp invocation writing the video to stdout.
+func (in YtDLPInput) command() Command {
+	path := in.Path
+	if path == "" {
+		path = "yt-dlp"
+	}
+	args := []string{
+		"--quiet",
+		"--no-warnings",
+		"--format", "best[acodec!=none][vcodec!=none]/best",
+		"--output", "-",
+	}
+	args = append(args, in.Args...)
+	return Command{Name: path, Args: append(args, in.URL)}
+}
+
+func (in YtDLPInput) start(ctx context.Context, ex Executor) (*producer, error) {
+	if in.URL == "" {
+		return nil, fmt.Errorf("youtube url is required")
+	}
+
+	// A real OS pipe rather than io.Pipe: child processes get the file
+	// descriptors directly, and a write after ffmpeg has gone away fails
+	// with EPIPE instead of blocking a copy goroutine.
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("create pipe: %w", err)
+	}
+
+	p := &producer{
+		name:    "yt-dlp",
+		stdin:   r,
+		done:    make(chan struct{}),
+		release: func() { _ = r.Close() },
+	}
+	go func() {
+		cmd := in.command()
+		cmd.Stdout = w
+		var stderr []byte
+		_, stderr, p.err = ex.Run(ctx, cmd)
+		p.stderr = redact(stderr, in.secrets)
+		// Mark the producer done before closing our write end, so by the
+		// time ffmpeg can see EOF the outcome is already visible.
+		close(p.done)
+		_ = w.Close()
+	}()
+	return p, nil
+}
+
+// download saves the whole video to path, for encodes that need to read
+// it more than once. A failure is reported like a failed pipe producer.
+func (in YtDLPInput) download(ctx context.Context, ex Executor, path string) error {
+	if in.URL == "" {
+		return fmt.Errorf("youtube url is required")
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create source file: %w", err)
+	}
+	cmd := in.command()
+	cmd.Stdout = f
+	_, stderr, err := ex.Run(ctx, cmd)
+	if cerr := f.Close(); err == nil && cerr != nil {
+		return fmt.Errorf("write source file: %w", cerr)
+	}
+	if err != nil {
+		stderr = redact(stderr, in.secrets)
+		return &PipelineError{
+			Err:            err,
+			ProducerFailed: true,
+			Reason:         ytdlpReason(stderr),
+			Producer:       "yt-dlp",
+			ProducerExit:   exitCode(err),
+			ProducerStderr: stderrTail(stderr),
+		}
+	}
+	return nil
+}
@@ -3,10 +3,14 @@ package transcode
 import (
 	"context"
 	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/rs/zerolog"
+
+	"github.com/sixfeetup/blobtube/internal/hls"
 )
 
 type QualityTier string
@@ -22,6 +26,70 @@ type VariantConfig struct {
 	Width        int
 	Height       int
 	VideoBitrate string
+
+	// MaxFPS caps the tier frame rate; zero keeps the source rate. Tiny
+	// tiers spend their bits better on detail than on motion.
+	MaxFPS int
+	// GOPSeconds is the keyframe interval; zero means one per segment.
+	GOPSeconds int
+
+	// Encoder selects the codec; nil means H.264. CRF zero uses the
+	// encoder's default, since CRF scales differ between codecs.
+	Encoder Encoder
+	CRF     int
+
+	// RateControl defaults to capped CRF with VideoBitrate as the cap.
+	RateControl RateControlMode
+	MaxRate     string
+	BufSize     string
+}
+
+const (
+	// variantSegmentSeconds and variantAudioBitrate are shared by every
+	// tier of a multi-quality transcode.
+	variantSegmentSeconds = 4
+	variantAudioBitrate   = "32k"
+)
+
+// Bandwidth returns the peak and average bits per second of the tier,
+// audio included, for the master playlist's BANDWIDTH and
+// AVERAGE-BANDWIDTH. The peak follows from the rate-control mode rather
+// than the nominal target, so clients on 50 kbps links don't pick a tier
+// whose segments they can't fetch in time.
+func (v VariantConfig) Bandwidth() (peak, average uint32, err error) {
+	audio, err := hls.ParseBitrate(variantAudioBitrate)
+	if err != nil {
+		return 0, 0, err
+	}
+	target, err := hls.ParseBitrate(v.VideoBitrate)
+	if err != nil {
+		return 0, 0, fmt.Errorf("tier %s: %w", v.Tier, err)
+	}
+	videoPeak, err := v.rateControl().PeakBitrate(variantSegmentSeconds)
+	if err != nil {
+		return 0, 0, fmt.Errorf("tier %s: %w", v.Tier, err)
+	}
+	if videoPeak < target {
+		videoPeak = target
+	}
+	return videoPeak + audio, target + audio, nil
+}
+
+func (v VariantConfig) rateControl() RateControl {
+	return RateControl{Mode: v.RateControl, Bitrate: v.VideoBitrate, MaxRate: v.MaxRate, BufSize: v.BufSize}
+}
+
+// Codecs returns the RFC 6381 CODECS value for the tier, including the AAC
+// audio track every tier carries.
+func (v VariantConfig) Codecs() string {
+	return v.encoder().Codec() + ",mp4a.40.2"
+}
+
+func (v VariantConfig) encoder() Encoder {
+	if v.Encoder == nil {
+		return H264Encoder{}
+	}
+	return v.Encoder
 }
 
 type MultiQualityResult struct {
@@ -32,10 +100,72 @@ type MultiQualityResult struct {
 
 func DefaultVariantConfigs() []VariantConfig {
 	return []VariantConfig{
-		{Tier: Quality64, Width: 64, Height: 64, VideoBitrate: "50k"},
-		{Tier: Quality128, Width: 128, Height: 128, VideoBitrate: "100k"},
-		{Tier: Quality256, Width: 256, Height: 256, VideoBitrate: "200k"},
+		{Tier: Quality64, Width: 64, Height: 64, VideoBitrate: "50k", MaxFPS: 10, RateControl: RateControlCappedCRF},
+		{Tier: Quality128, Width: 128, Height: 128, VideoBitrate: "100k", MaxFPS: 15, RateControl: RateControlCappedCRF},
+		{Tier: Quality256, Width: 256, Height: 256, VideoBitrate: "200k", RateControl: RateControlCappedCRF},
+	}
+}
+
+// VariantLadder repeats the default tiers for each named encoder. The first
+// encoder keeps the plain tier names (and so the existing URLs); the others
+// are suffixed, e.g. "64x64-av1", so several codecs can share one master
+// playlist and clients pick the best one they can decode.
+func VariantLadder(encoders []string) ([]VariantConfig, error) {
+	if len(encoders) == 0 {
+		return DefaultVariantConfigs(), nil
+	}
+
+	var ladder []VariantConfig
+	seen := map[string]bool{}
+	for i, name := range encoders {
+		enc, err := EncoderByName(name)
+		if err != nil {
+			return nil, err
+		}
+		if seen[enc.Name()] {
+			continue
+		}
+		seen[enc.Name()] = true
+
+		for _, v := range DefaultVariantConfigs() {
+			v.Encoder = enc
+			if i > 0 {
+				v.Tier = QualityTier(string(v.Tier) + "-" + enc.Name())
+			}
+			ladder = append(ladder, v)
+		}
+	}
+	return ladder, nil
+}
+
+// WithRateControl returns a copy of ladder with every tier encoded in mode;
+// an empty mode keeps the tiers' own.
+func WithRateControl(ladder []VariantConfig, mode RateControlMode) ([]VariantConfig, error) {
+	if mode == "" {
+		return ladder, nil
+	}
+	out := append([]VariantConfig(nil), ladder...)
+	for i := range out {
+		out[i].RateControl = mode
+		if err := out[i].rateControl().validate(); err != nil {
+			return nil, fmt.Errorf("tier %s: %w", out[i].Tier, err)
+		}
+	}
+	return out, nil
+}
+
+// LadderFingerprint describes every setting that affects the encoded output
+// of a ladder, for keying cached output. Two ladders with the same
+// fingerprint produce interchangeable files.
+func LadderFingerprint(ladder []VariantConfig) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "seg=%d audio=%s\n", variantSegmentSeconds, variantAudioBitrate)
+	for _, v := range ladder {
+		fmt.Fprintf(&b, "%s %dx%d enc=%s crf=%d rc=%s b=%s max=%s buf=%s fps=%d gop=%d\n",
+			v.Tier, v.Width, v.Height, v.encoder().Name(), v.CRF, v.rateControl().mode(),
+			v.VideoBitrate, v.MaxRate, v.BufSize, v.MaxFPS, v.GOPSeconds)
 	}
+	return b.String()
 }
 
 func TranscodeMultiQualityHLS(ctx context.Context, logger zerolog.Logger, ff *FFmpeg, inputURL string, outputDir string, variants []VariantConfig) (MultiQualityResult, error) {
@@ -48,75 +178,85 @@ func TranscodeMultiQualityHLS(ctx context.Context, logger zerolog.Logger, ff *FF
 	if outputDir == "" {
 		return MultiQualityResult{}, fmt.Errorf("output dir is required")
 	}
-	if len(variants) == 0 {
-		variants = DefaultVariantConfigs()
+
+	return transcodeVariants(ctx, logger, ff, URLInput(inputURL), outputDir, variants, false), nil
+}
+
+// TranscodeMultiQualityHLSFromYouTube transcodes a YouTube video by piping from yt-dlp to FFmpeg.
+// This avoids the 403 Forbidden errors that occur when passing YouTube stream URLs directly to FFmpeg.
+// Build source with YtDLP.Input so the download uses the same options as the metadata lookup.
+//
+// Two-pass tiers have to read the source twice, so when the ladder has any
+// the whole video is downloaded into outputDir first and every tier
+// encodes from that file, which is removed afterwards. Playback then only
+// starts once the download has finished.
+func TranscodeMultiQualityHLSFromYouTube(ctx context.Context, logger zerolog.Logger, ff *FFmpeg, source YtDLPInput, outputDir string, variants []VariantConfig) (MultiQualityResult, error) {
+	if err := validateYouTubeSource(ff, source, outputDir); err != nil {
+		return MultiQualityResult{}, err
+	}
+	if !needsSeekableInput(variants) {
+		return transcodeVariants(ctx, logger, ff, source, outputDir, variants, false), nil
 	}
 
-	res := MultiQualityResult{
-		OutputDir: outputDir,
-		Results:   map[QualityTier]HLSResult{},
-		Errors:    map[QualityTier]error{},
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return MultiQualityResult{}, fmt.Errorf("create output dir: %w", err)
+	}
+	path := filepath.Join(outputDir, sourceFile)
+	defer os.Remove(path)
+	logger.Debug().Str("path", path).Msg("downloading source for two-pass encoding")
+	if err := source.download(ctx, ff.Exec, path); err != nil {
+		return MultiQualityResult{}, err
 	}
+	return transcodeVariants(ctx, logger, ff, URLInput(path), outputDir, variants, false), nil
+}
 
-	var mu sync.Mutex
-	var wg sync.WaitGroup
+// sourceFile is where a source is spooled for two-pass encoding.
+const sourceFile = "source.download"
 
+func needsSeekableInput(variants []VariantConfig) bool {
 	for _, v := range variants {
-		v := v
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-
-			out := filepath.Join(outputDir, string(v.Tier))
-			logger.Debug().Str("tier", string(v.Tier)).Str("dir", out).Msg("ffmpeg transcode starting")
-
-			hlsRes, err := ff.TranscodeHLS(ctx, HLSRequest{
-				InputURL:               inputURL,
-				OutputDir:              out,
-				Width:                  v.Width,
-				Height:                 v.Height,
-				VideoBitrate:           v.VideoBitrate,
-				PlaylistName:           "index.m3u8",
-				DisableAudio:           false,
-				AudioBitrate:           "32k",
-				VideoPreset:            5, // "fast" preset for H.264
-				VideoCRF:               28,
-				SegmentDurationSeconds: 4,
-			})
-
-			mu.Lock()
-			defer mu.Unlock()
-			if err != nil {
-				res.Errors[v.Tier] = err
-				res.Results[v.Tier] = hlsRes
-				logger.Error().Str("tier", string(v.Tier)).Err(err).Msg("ffmpeg transcode failed")
-				return
-			}
-			res.Results[v.Tier] = hlsRes
-			logger.Debug().Str("tier", string(v.Tier)).Msg("ffmpeg transcode completed")
-		}()
+		if v.rateControl().mode() == RateControlTwoPass {
+			return true
+		}
 	}
+	return false
+}
 
-	wg.Wait()
+// TranscodeLiveHLSFromYouTube relays a live broadcast as sliding-window HLS.
+// It returns when the broadcast ends or ctx is cancelled; the latter is how
+// a relay nobody is watching gets stopped.
+func TranscodeLiveHLSFromYouTube(ctx context.Context, logger zerolog.Logger, ff *FFmpeg, source YtDLPInput, outputDir string, variants []VariantConfig) (MultiQualityResult, error) {
+	if err := validateYouTubeSource(ff, source, outputDir); err != nil {
+		return MultiQualityResult{}, err
+	}
 
-	return res, nil
+	// Two-pass can't run on a live source; fall back to the default.
+	variants = append([]VariantConfig(nil), variants...)
+	for i := range variants {
+		if variants[i].RateControl == RateControlTwoPass {
+			variants[i].RateControl = RateControlCappedCRF
+		}
+	}
+	return transcodeVariants(ctx, logger, ff, source, outputDir, variants, true), nil
 }
 
-// TranscodeMultiQualityHLSFromYouTube transcodes a YouTube video by piping from yt-dlp to FFmpeg.
-// This avoids the 403 Forbidden errors that occur when passing YouTube stream URLs directly to FFmpeg.
-func TranscodeMultiQualityHLSFromYouTube(ctx context.Context, logger zerolog.Logger, ff *FFmpeg, ytdlp *YtDLP, youtubeURL string, outputDir string, variants []VariantConfig) (MultiQualityResult, error) {
+func validateYouTubeSource(ff *FFmpeg, source YtDLPInput, outputDir string) error {
 	if ff == nil {
-		return MultiQualityResult{}, fmt.Errorf("ffmpeg is required")
+		return fmt.Errorf("ffmpeg is required")
 	}
-	if ytdlp == nil {
-		return MultiQualityResult{}, fmt.Errorf("ytdlp is required")
-	}
-	if youtubeURL == "" {
-		return MultiQualityResult{}, fmt.Errorf("youtube url is required")
+	if source.URL == "" {
+		return fmt.Errorf("youtube url is required")
 	}
 	if outputDir == "" {
-		return MultiQualityResult{}, fmt.Errorf("output dir is required")
+		return fmt.Errorf("output dir is required")
 	}
+	return nil
+}
+
+// transcodeVariants encodes every tier concurrently into its own
+// subdirectory of outputDir. Each tier opens its own input, so a pipe input
+// runs one producer per tier. A failing tier doesn't stop the others.
+func transcodeVariants(ctx context.Context, logger zerolog.Logger, ff *FFmpeg, input Input, outputDir string, variants []VariantConfig, live bool) MultiQualityResult {
 	if len(variants) == 0 {
 		variants = DefaultVariantConfigs()
 	}
@@ -137,36 +277,48 @@ func TranscodeMultiQualityHLSFromYouTube(ctx context.Context, logger zerolog.Log
 			defer wg.Done()
 
 			out := filepath.Join(outputDir, string(v.Tier))
-			logger.Debug().Str("tier", string(v.Tier)).Str("dir", out).Msg("yt-dlp + ffmpeg transcode starting")
-
-			// Use pipe:1 as input for FFmpeg, and pipe the video from yt-dlp
-			hlsRes, err := ff.TranscodeHLSFromYtDlpPipe(ctx, youtubeURL, ytdlp.Path, HLSRequest{
-				OutputDir:              out,
-				Width:                  v.Width,
-				Height:                 v.Height,
-				VideoBitrate:           v.VideoBitrate,
-				PlaylistName:           "index.m3u8",
-				DisableAudio:           false,
-				AudioBitrate:           "32k",
-				VideoPreset:            5, // "fast" preset for H.264
-				VideoCRF:               28,
-				SegmentDurationSeconds: 4,
-			})
+			logger.Debug().Str("tier", string(v.Tier)).Str("dir", out).Msg("ffmpeg transcode starting")
+
+			req := v.hlsRequest(input, out)
+			req.Live = live
+			hlsRes, err := ff.TranscodeHLS(ctx, req)
 
 			mu.Lock()
 			defer mu.Unlock()
 			if err != nil {
 				res.Errors[v.Tier] = err
 				res.Results[v.Tier] = hlsRes
-				logger.Error().Str("tier", string(v.Tier)).Err(err).Msg("yt-dlp + ffmpeg transcode failed")
+				logger.Error().Str("tier", string(v.Tier)).Err(err).Msg("ffmpeg transcode failed")
 				return
 			}
 			res.Results[v.Tier] = hlsRes
-			logger.Debug().Str("tier", string(v.Tier)).Msg("yt-dlp + ffmpeg transcode completed")
+			logger.Debug().Str("tier", string(v.Tier)).Msg("ffmpeg transcode completed")
 		}()
 	}
 
 	wg.Wait()
 
-	return res, nil
+	return res
+}
+
+func (v VariantConfig) hlsRequest(input Input, outputDir string) HLSRequest {
+	return HLSRequest{
+		Input:                  input,
+		OutputDir:              outputDir,
+		Width:                  v.Width,
+		Height:                 v.Height,
+		VideoBitrate:           v.VideoBitrate,
+		MaxFPS:                 v.MaxFPS,
+		GOPSeconds:             v.GOPSeconds,
+		RateControl:            v.RateControl,
+		VideoMaxRate:           v.MaxRate,
+		VideoBufSize:           v.BufSize,
+		PlaylistName:           "index.m3u8",
+		DisableAudio:           false,
+		AudioBitrate:           variantAudioBitrate,
+		VideoPreset:            5, // "fast" preset for H.264
+		VideoCRF:               v.CRF,
+		Encoder:                v.Encoder,
+		SegmentDurationSeconds: variantSegmentSeconds,
+	}
 }
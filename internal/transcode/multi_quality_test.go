@@ -16,7 +16,7 @@ func TestTranscodeMultiQualityHLS_SpawnsAllVariants(t *testing.T) {
 
 	mu := sync.Mutex{}
 	calls := 0
-	ff.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+	ff.Exec = ExecFunc(func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
 		_ = ctx
 		_ = name
 		_ = args
@@ -24,7 +24,7 @@ func TestTranscodeMultiQualityHLS_SpawnsAllVariants(t *testing.T) {
 		calls++
 		mu.Unlock()
 		return nil, nil, nil
-	}
+	})
 
 	outDir := t.TempDir()
 	res, err := TranscodeMultiQualityHLS(context.Background(), zerolog.Nop(), ff, "input", outDir, nil)
@@ -45,7 +45,7 @@ func TestTranscodeMultiQualityHLS_SpawnsAllVariants(t *testing.T) {
 func TestTranscodeMultiQualityHLS_ContinuesOnFailure(t *testing.T) {
 	ff := NewFFmpeg("ffmpeg", zerolog.Nop())
 
-	ff.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+	ff.Exec = ExecFunc(func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
 		_ = ctx
 		_ = name
 		playlist := args[len(args)-1]
@@ -53,7 +53,7 @@ func TestTranscodeMultiQualityHLS_ContinuesOnFailure(t *testing.T) {
 			return nil, []byte("nope"), errors.New("exit status 1")
 		}
 		return nil, nil, nil
-	}
+	})
 
 	outDir := t.TempDir()
 	res, err := TranscodeMultiQualityHLS(context.Background(), zerolog.Nop(), ff, "input", outDir, nil)
@@ -76,14 +76,14 @@ func TestTranscodeMultiQualityHLS_RunsVariantsConcurrently(t *testing.T) {
 
 	started := make(chan struct{}, 3)
 	release := make(chan struct{})
-	ff.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+	ff.Exec = ExecFunc(func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
 		_ = ctx
 		_ = name
 		_ = args
 		started <- struct{}{}
 		<-release
 		return nil, nil, nil
-	}
+	})
 
 	outDir := t.TempDir()
 	resCh := make(chan MultiQualityResult, 1)
@@ -108,3 +108,40 @@ func TestTranscodeMultiQualityHLS_RunsVariantsConcurrently(t *testing.T) {
 		t.Fatalf("expected 3 results, got %d", len(res.Results))
 	}
 }
+
+func TestVariantLadder_SuffixesSecondaryEncoders(t *testing.T) {
+	ladder, err := VariantLadder([]string{"h264", "av1"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if len(ladder) != 6 {
+		t.Fatalf("expected 6 variants, got %d", len(ladder))
+	}
+	if ladder[0].Tier != Quality64 || ladder[0].Codecs() != "avc1.42E01E,mp4a.40.2" {
+		t.Fatalf("unexpected primary variant %+v", ladder[0])
+	}
+	if ladder[3].Tier != "64x64-av1" || ladder[3].Codecs() != "av01.0.00M.08,mp4a.40.2" {
+		t.Fatalf("unexpected av1 variant %+v", ladder[3])
+	}
+}
+
+func TestTranscodeLiveHLSFromYouTube_LeavesCallerLadderAlone(t *testing.T) {
+	ff := NewFFmpeg("ffmpeg", zerolog.Nop())
+	ff.Exec = ExecFunc(func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		return nil, nil, nil
+	})
+
+	ladder, err := WithRateControl(DefaultVariantConfigs(), RateControlTwoPass)
+	if err != nil {
+		t.Fatalf("WithRateControl: %v", err)
+	}
+	source := YtDLPInput{Path: "yt-dlp", URL: "https://youtube.com/watch?v=x"}
+	if _, err := TranscodeLiveHLSFromYouTube(context.Background(), zerolog.Nop(), ff, source, t.TempDir(), ladder); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	for _, v := range ladder {
+		if v.RateControl != RateControlTwoPass {
+			t.Fatalf("expected caller's ladder to keep two-pass, got %q for %s", v.RateControl, v.Tier)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package transcode
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnknownDuration      = errors.New("unknown duration")
+	ErrPlaylistNotSupported = errors.New("playlist not supported")
+	ErrSourceTooLarge       = errors.New("source too large")
+)
+
+// Policy decides, from yt-dlp metadata alone, whether a video is worth
+// transcoding. It runs before any ffmpeg process is started.
+type Policy struct {
+	// MaxStreamSeconds is the ffmpeg -t cap (ADR-014). Longer sources are
+	// accepted but truncated.
+	MaxStreamSeconds int
+	// MaxSourceSeconds rejects sources outright, since truncating a
+	// ten-hour video to its first hour is rarely what anyone wanted.
+	// Zero disables the check.
+	MaxSourceSeconds int
+	// MaxSourceBytes rejects sources whose approximate size is larger.
+	// Zero disables the check.
+	MaxSourceBytes int64
+
+	AllowUnknownDuration bool
+	AllowAgeRestricted   bool
+	// AllowLive admits live broadcasts, which are relayed rather than
+	// transcoded to completion. Premieres are rejected either way.
+	AllowLive bool
+}
+
+// Preflight is the outcome of an accepted Policy check.
+type Preflight struct {
+	// EffectiveSeconds is how much of the source will be streamed, or zero
+	// when the duration is unknown.
+	EffectiveSeconds int
+	Truncated        bool
+	// Live is set for broadcasts admitted by AllowLive.
+	Live bool
+}
+
+// Check rejects sources the server shouldn't transcode and reports how the
+// rest will be adapted.
+func (p Policy) Check(info StreamInfo) (Preflight, error) {
+	if info.IsPlaylist {
+		return Preflight{}, ErrPlaylistNotSupported
+	}
+	if !p.AllowAgeRestricted && info.AgeLimit >= 18 {
+		return Preflight{}, fmt.Errorf("%w: age limit %d", ErrAgeRestricted, info.AgeLimit)
+	}
+
+	switch {
+	case info.LiveStatus == "is_upcoming":
+		return Preflight{}, fmt.Errorf("%w: premiere or scheduled stream", ErrLiveNotSupported)
+	case info.LiveStatus == "post_live":
+		return Preflight{}, fmt.Errorf("%w: live recording still processing", ErrLiveNotSupported)
+	case info.IsLive || info.LiveStatus == "is_live":
+		if !p.AllowLive {
+			return Preflight{}, ErrLiveNotSupported
+		}
+		// Duration and size are meaningless for a broadcast in progress.
+		return Preflight{Live: true}, nil
+	}
+
+	if p.MaxSourceBytes > 0 && info.FilesizeApprox > p.MaxSourceBytes {
+		return Preflight{}, fmt.Errorf("%w: about %d bytes", ErrSourceTooLarge, info.FilesizeApprox)
+	}
+
+	if info.Duration <= 0 {
+		if !p.AllowUnknownDuration {
+			return Preflight{}, ErrUnknownDuration
+		}
+		return Preflight{}, nil
+	}
+	if p.MaxSourceSeconds > 0 && info.Duration > p.MaxSourceSeconds {
+		return Preflight{}, fmt.Errorf("%w: %ds exceeds %ds", ErrSourceTooLong, info.Duration, p.MaxSourceSeconds)
+	}
+
+	pf := Preflight{EffectiveSeconds: info.Duration}
+	if p.MaxStreamSeconds > 0 && info.Duration > p.MaxStreamSeconds {
+		pf.EffectiveSeconds = p.MaxStreamSeconds
+		pf.Truncated = true
+	}
+	return pf, nil
+}
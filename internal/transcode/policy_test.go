@@ -0,0 +1,66 @@
+package transcode
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPolicy_Check_Rejections(t *testing.T) {
+	p := Policy{MaxStreamSeconds: 3600, MaxSourceSeconds: 7200, MaxSourceBytes: 1 << 30}
+
+	cases := []struct {
+		name string
+		info StreamInfo
+		want error
+	}{
+		{"playlist", StreamInfo{IsPlaylist: true}, ErrPlaylistNotSupported},
+		{"live", StreamInfo{IsLive: true, Duration: 60}, ErrLiveNotSupported},
+		{"live status", StreamInfo{LiveStatus: "is_live"}, ErrLiveNotSupported},
+		{"premiere", StreamInfo{LiveStatus: "is_upcoming", Duration: 60}, ErrLiveNotSupported},
+		{"post live", StreamInfo{LiveStatus: "post_live", Duration: 60}, ErrLiveNotSupported},
+		{"age gated", StreamInfo{AgeLimit: 18, Duration: 60}, ErrAgeRestricted},
+		{"too large", StreamInfo{FilesizeApprox: 2 << 30, Duration: 60}, ErrSourceTooLarge},
+		{"unknown duration", StreamInfo{}, ErrUnknownDuration},
+		{"too long", StreamInfo{Duration: 7201}, ErrSourceTooLong},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := p.Check(tc.info)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestPolicy_Check_Adapts(t *testing.T) {
+	p := Policy{MaxStreamSeconds: 3600, MaxSourceSeconds: 7200}
+
+	pf, err := p.Check(StreamInfo{Duration: 600, LiveStatus: "was_live"})
+	if err != nil || pf.Truncated || pf.EffectiveSeconds != 600 {
+		t.Fatalf("expected untouched 600s source, got %+v, %v", pf, err)
+	}
+
+	pf, err = p.Check(StreamInfo{Duration: 5400})
+	if err != nil || !pf.Truncated || pf.EffectiveSeconds != 3600 {
+		t.Fatalf("expected truncation to 3600s, got %+v, %v", pf, err)
+	}
+
+	p.AllowUnknownDuration = true
+	p.AllowAgeRestricted = true
+	if _, err := p.Check(StreamInfo{AgeLimit: 18}); err != nil {
+		t.Fatalf("expected allowed source, got %v", err)
+	}
+}
+
+func TestPolicy_Check_AllowsLiveButNotPremieres(t *testing.T) {
+	p := Policy{AllowLive: true}
+
+	pf, err := p.Check(StreamInfo{IsLive: true, LiveStatus: "is_live"})
+	if err != nil || !pf.Live {
+		t.Fatalf("expected live preflight, got %+v, %v", pf, err)
+	}
+	if _, err := p.Check(StreamInfo{LiveStatus: "is_upcoming"}); !errors.Is(err, ErrLiveNotSupported) {
+		t.Fatalf("expected premiere rejection, got %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sixfeetup/blobtube/internal/hls"
+)
+
+// Seek previews are sized for 50 kbps links: the thumbnail is a few KB and
+// each sprite sheet covers four minutes of video in roughly 10 KB.
+const (
+	ThumbnailFile      = "thumbnail.jpg"
+	StoryboardTrack    = "storyboard.vtt"
+	thumbnailWidth     = 160
+	storyboardInterval = 10 * time.Second
+	storyboardTile     = 64
+	storyboardColumns  = 5
+	storyboardRows     = 5
+	// previewQuality is ffmpeg's -q:v for JPEG, from 2 (best) to 31.
+	previewQuality = 10
+	// maxThumbnailBytes bounds the source thumbnail download.
+	maxThumbnailBytes = 2 << 20
+)
+
+// StoryboardSheet names sprite sheet i.
+func StoryboardSheet(i int) string {
+	return fmt.Sprintf("storyboard_%03d.jpg", i)
+}
+
+// thumbnailClient fetches source thumbnails. The URL comes from yt-dlp's
+// metadata, which the video's page controls, so only YouTube's image hosts
+// are fetched and the connection must reach a public address; tests relax
+// both.
+type thumbnailClient struct {
+	allowHost func(host string) bool
+	allowAddr func(addr netip.Addr) bool
+}
+
+var defaultThumbnailClient = thumbnailClient{allowHost: youtubeImageHost, allowAddr: publicAddr}
+
+// FetchThumbnail downloads a source thumbnail through the same proxy as
+// yt-dlp, so the request leaves from where the video is fetched. Only
+// YouTube image hosts are fetched, and redirects aren't followed.
+func FetchThumbnail(ctx context.Context, thumbURL, proxy string) ([]byte, error) {
+	return defaultThumbnailClient.fetch(ctx, thumbURL, proxy)
+}
+
+func (c thumbnailClient) fetch(ctx context.Context, thumbURL, proxy string) ([]byte, error) {
+	u, err := url.Parse(thumbURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid thumbnail url")
+	}
+	if !c.allowHost(u.Hostname()) {
+		return nil, fmt.Errorf("thumbnail host %q not allowed", u.Hostname())
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "" {
+		pu, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(pu)
+	} else {
+		// The check runs on the address actually dialed, after DNS, so a
+		// name rebound to an internal address is caught too. Through a
+		// proxy the dial goes to the operator's proxy instead.
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: c.control}
+		transport.DialContext = dialer.DialContext
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   15 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch thumbnail: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch thumbnail: %s", resp.Status)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxThumbnailBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetch thumbnail: %w", err)
+	}
+	if len(b) > maxThumbnailBytes {
+		return nil, fmt.Errorf("thumbnail larger than %d bytes", maxThumbnailBytes)
+	}
+	return b, nil
+}
+
+func (c thumbnailClient) control(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("thumbnail address %q: %w", address, err)
+	}
+	if !c.allowAddr(ap.Addr().Unmap()) {
+		return fmt.Errorf("thumbnail address %s not allowed", ap.Addr())
+	}
+	return nil
+}
+
+// youtubeImageHost reports whether host serves YouTube thumbnails:
+// i.ytimg.com and its numbered siblings, or img.youtube.com.
+func youtubeImageHost(host string) bool {
+	host = strings.ToLower(host)
+	return host == "img.youtube.com" || strings.HasSuffix(host, ".ytimg.com")
+}
+
+// publicAddr rejects loopback, private, link-local, shared (CGNAT),
+// multicast and unspecified addresses.
+func publicAddr(a netip.Addr) bool {
+	return a.IsGlobalUnicast() && !a.IsPrivate() && !sharedAddrSpace.Contains(a)
+}
+
+var sharedAddrSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// Thumbnail downscales a source image (JPEG, WebP or PNG) to a small JPEG
+// at dst. The source is downloaded from the network, so ffmpeg is told
+// which image decoder to use rather than left to probe it: anything else is
+// rejected before ffmpeg runs.
+func (f *FFmpeg) Thumbnail(ctx context.Context, src []byte, dst string) error {
+	codec := imageCodec(src)
+	if codec == "" {
+		return fmt.Errorf("thumbnail is not a JPEG, PNG or WebP image")
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	args := []string{
+		"-hide_banner", "-loglevel", "error", "-y",
+		"-f", "image2pipe", "-c:v", codec,
+		"-i", "pipe:0",
+		"-vf", fmt.Sprintf("scale=%d:-2:flags=lanczos", thumbnailWidth),
+		"-frames:v", "1",
+		"-q:v", fmt.Sprint(previewQuality),
+		dst,
+	}
+	if _, stderr, err := f.Exec.Run(ctx, Command{Name: f.Path, Args: args, Stdin: bytes.NewReader(src)}); err != nil {
+		return ffmpegErr("ffmpeg thumbnail failed", stderr, err)
+	}
+	return nil
+}
+
+// imageCodec names ffmpeg's decoder for src from its magic bytes, or
+// returns "" for anything that isn't a JPEG, PNG or WebP image.
+func imageCodec(src []byte) string {
+	switch {
+	case bytes.HasPrefix(src, []byte("\xff\xd8\xff")):
+		return "mjpeg"
+	case bytes.HasPrefix(src, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case len(src) >= 12 && string(src[:4]) == "RIFF" && string(src[8:12]) == "WEBP":
+		return "webp"
+	}
+	return ""
+}
+
+// Storyboard samples a transcoded tier into sprite sheets in dir and
+// writes the WebVTT thumbnails track pointing into them.
+func (f *FFmpeg) Storyboard(ctx context.Context, playlistPath, dir string) error {
+	b, err := os.ReadFile(playlistPath)
+	if err != nil {
+		return err
+	}
+	total, err := hls.MediaPlaylistDuration(b)
+	if err != nil {
+		return fmt.Errorf("storyboard: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	args := []string{
+		"-hide_banner", "-loglevel", "error", "-y",
+		"-i", playlistPath,
+		"-an",
+		"-vf", fmt.Sprintf("fps=1/%d,scale=%d:%d,tile=%dx%d",
+			int(storyboardInterval.Seconds()), storyboardTile, storyboardTile, storyboardColumns, storyboardRows),
+		"-q:v", fmt.Sprint(previewQuality),
+		"-start_number", "0",
+		filepath.Join(dir, "storyboard_%03d.jpg"),
+	}
+	if _, stderr, err := f.Exec.Run(ctx, Command{Name: f.Path, Args: args}); err != nil {
+		return ffmpegErr("ffmpeg storyboard failed", stderr, err)
+	}
+
+	track, err := hls.BuildThumbnailTrack(hls.Storyboard{
+		Interval:   storyboardInterval,
+		TileWidth:  storyboardTile,
+		TileHeight: storyboardTile,
+		Columns:    storyboardColumns,
+		Rows:       storyboardRows,
+		SheetURI:   StoryboardSheet,
+	}, total)
+	if err != nil {
+		return fmt.Errorf("storyboard: %w", err)
+	}
+	return os.WriteFile(filepath.Join(dir, StoryboardTrack), track, 0o644)
+}
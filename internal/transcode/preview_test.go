@@ -0,0 +1,139 @@
+package transcode
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+// anyHost lets tests fetch from httptest servers on loopback.
+var anyHost = thumbnailClient{
+	allowHost: func(string) bool { return true },
+	allowAddr: func(netip.Addr) bool { return true },
+}
+
+func TestFetchThumbnail_DownloadsAndBoundsSize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/big.jpg" {
+			_, _ = w.Write(make([]byte, maxThumbnailBytes+1))
+			return
+		}
+		_, _ = w.Write([]byte("jpeg"))
+	}))
+	defer srv.Close()
+
+	b, err := anyHost.fetch(context.Background(), srv.URL+"/maxres.jpg", "")
+	if err != nil || string(b) != "jpeg" {
+		t.Fatalf("expected thumbnail bytes, got %q, %v", b, err)
+	}
+	if _, err := anyHost.fetch(context.Background(), srv.URL+"/big.jpg", ""); err == nil {
+		t.Fatalf("expected oversized thumbnail to fail")
+	}
+	if _, err := FetchThumbnail(context.Background(), "file:///etc/passwd", ""); err == nil {
+		t.Fatalf("expected non-http url to fail")
+	}
+}
+
+func TestFetchThumbnail_RefusesInternalTargets(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/maxres.jpg", http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte("jpeg"))
+	}))
+	defer srv.Close()
+
+	if _, err := FetchThumbnail(context.Background(), "http://169.254.169.254/latest/meta-data", ""); err == nil {
+		t.Fatalf("expected a non-YouTube host to be refused")
+	}
+	ytHostOnly := thumbnailClient{allowHost: func(string) bool { return true }, allowAddr: publicAddr}
+	if _, err := ytHostOnly.fetch(context.Background(), srv.URL+"/maxres.jpg", ""); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Fatalf("expected a loopback address to be refused, got %v", err)
+	}
+	if _, err := anyHost.fetch(context.Background(), srv.URL+"/redirect", ""); err == nil {
+		t.Fatalf("expected a redirect not to be followed")
+	}
+
+	for host, want := range map[string]bool{"i.ytimg.com": true, "i9.ytimg.com": true, "img.youtube.com": true, "ytimg.com.evil.example": false, "localhost": false} {
+		if got := youtubeImageHost(host); got != want {
+			t.Errorf("youtubeImageHost(%q) = %v, want %v", host, got, want)
+		}
+	}
+	for addr, want := range map[string]bool{"142.250.1.1": true, "127.0.0.1": false, "10.0.0.1": false, "169.254.169.254": false, "100.64.0.1": false, "::1": false, "fe80::1": false, "fd00::1": false} {
+		if got := publicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("publicAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestFFmpeg_Thumbnail_PipesSourceImage(t *testing.T) {
+	f := NewFFmpeg("ffmpeg", zerolog.Nop())
+	var stdin []byte
+	var gotArgs []string
+	f.Exec = executorFunc(func(ctx context.Context, cmd Command) ([]byte, []byte, error) {
+		gotArgs = cmd.Args
+		stdin, _ = io.ReadAll(cmd.Stdin)
+		return nil, nil, nil
+	})
+
+	dst := filepath.Join(t.TempDir(), "preview", ThumbnailFile)
+	webp := []byte("RIFF\x00\x00\x00\x00WEBPVP8 ")
+	if err := f.Thumbnail(context.Background(), webp, dst); err != nil {
+		t.Fatalf("Thumbnail: %v", err)
+	}
+	if string(stdin) != string(webp) {
+		t.Fatalf("expected source on stdin, got %q", stdin)
+	}
+	if args := strings.Join(gotArgs, " "); !strings.Contains(args, "-f image2pipe -c:v webp -i pipe:0") || !strings.HasSuffix(args, dst) {
+		t.Fatalf("unexpected args: %s", args)
+	}
+
+	gotArgs = nil
+	if err := f.Thumbnail(context.Background(), []byte("#EXTM3U\n"), dst); err == nil || gotArgs != nil {
+		t.Fatalf("expected a non-image source to be refused before ffmpeg runs, got %v", err)
+	}
+}
+
+func TestFFmpeg_Storyboard_WritesThumbnailsTrack(t *testing.T) {
+	dir := t.TempDir()
+	playlist := filepath.Join(dir, "index.m3u8")
+	pl := "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:4\n"
+	for i := 0; i < 8; i++ {
+		pl += "#EXTINF:4.000,\nsegment.m4s\n"
+	}
+	pl += "#EXT-X-ENDLIST\n"
+	if err := os.WriteFile(playlist, []byte(pl), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	f := NewFFmpeg("ffmpeg", zerolog.Nop())
+	var gotArgs []string
+	f.Exec = executorFunc(func(ctx context.Context, cmd Command) ([]byte, []byte, error) {
+		gotArgs = cmd.Args
+		return nil, nil, nil
+	})
+
+	out := filepath.Join(dir, "preview")
+	if err := f.Storyboard(context.Background(), playlist, out); err != nil {
+		t.Fatalf("Storyboard: %v", err)
+	}
+	if !strings.Contains(strings.Join(gotArgs, " "), "tile=5x5") {
+		t.Fatalf("expected tile filter, got %v", gotArgs)
+	}
+	track, err := os.ReadFile(filepath.Join(out, StoryboardTrack))
+	if err != nil {
+		t.Fatalf("read track: %v", err)
+	}
+	if n := strings.Count(string(track), "#xywh="); n != 4 {
+		t.Fatalf("expected 4 cues for 32s, got %d:\n%s", n, track)
+	}
+}
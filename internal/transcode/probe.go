@@ -0,0 +1,52 @@
+package transcode
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Encoders lists the encoders the ffmpeg binary was built with, from
+// `ffmpeg -encoders`.
+func (f *FFmpeg) Encoders(ctx context.Context) (map[string]bool, error) {
+	stdout, stderr, err := f.Exec.Run(ctx, Command{Name: f.Path, Args: []string{"-hide_banner", "-encoders"}})
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg -encoders: %w: %s", err, strings.TrimSpace(string(stderr)))
+	}
+	return parseEncoders(stdout), nil
+}
+
+// parseEncoders reads the table after the legend's "------" line: a flags
+// column, then the encoder name.
+func parseEncoders(out []byte) map[string]bool {
+	encoders := map[string]bool{}
+	inTable := false
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if !inTable {
+			inTable = strings.HasPrefix(line, "------")
+			continue
+		}
+		if fields := strings.Fields(line); len(fields) >= 2 {
+			encoders[fields[1]] = true
+		}
+	}
+	return encoders
+}
+
+// LadderLibraries lists the ffmpeg encoders a ladder needs, each once.
+func LadderLibraries(ladder []VariantConfig) []string {
+	var libs []string
+	seen := map[string]bool{}
+	for _, v := range ladder {
+		lib := v.encoder().Library()
+		if !seen[lib] {
+			seen[lib] = true
+			libs = append(libs, lib)
+		}
+	}
+	return libs
+}
@@ -0,0 +1,43 @@
+package transcode
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+const encodersOutput = `Encoders:
+ V..... = Video
+ A..... = Audio
+ ------
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC (codec h264)
+ V....D libsvtav1            SVT-AV1(Scalable Video Technology for AV1) encoder (codec av1)
+ A....D aac                  AAC (Advanced Audio Coding)
+`
+
+func TestFFmpeg_Encoders(t *testing.T) {
+	f := NewFFmpeg("ffmpeg", zerolog.Nop())
+	f.Exec = ExecFunc(func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		return []byte(encodersOutput), nil, nil
+	})
+	got, err := f.Encoders(context.Background())
+	if err != nil {
+		t.Fatalf("Encoders: %v", err)
+	}
+	want := map[string]bool{"libx264": true, "libsvtav1": true, "aac": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestLadderLibraries(t *testing.T) {
+	ladder, err := VariantLadder([]string{"h264", "av1"})
+	if err != nil {
+		t.Fatalf("VariantLadder: %v", err)
+	}
+	if got := LadderLibraries(ladder); !reflect.DeepEqual(got, []string{"libx264", "libsvtav1"}) {
+		t.Fatalf("unexpected libraries %v", got)
+	}
+}
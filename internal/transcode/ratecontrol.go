@@ -0,0 +1,116 @@
+package transcode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sixfeetup/blobtube/internal/hls"
+)
+
+// RateControlMode selects how an encoder trades quality against bitrate.
+type RateControlMode string
+
+const (
+	// RateControlCappedCRF encodes at constant quality but caps the peak
+	// with -maxrate/-bufsize, so easy scenes stay small and hard scenes
+	// never exceed the tier's link budget. This is the default.
+	RateControlCappedCRF RateControlMode = "capped_crf"
+
+	// RateControlCBR pins the bitrate, for links that can't absorb any
+	// variation.
+	RateControlCBR RateControlMode = "cbr"
+
+	// RateControlTwoPass runs an analysis pass before a capped VBR pass.
+	// It needs a seekable input, so it only suits library content.
+	RateControlTwoPass RateControlMode = "two_pass"
+)
+
+// RateControl carries the bitrate settings an encoder turns into argv.
+// MaxRate defaults to Bitrate and BufSize to MaxRate.
+type RateControl struct {
+	Mode    RateControlMode
+	Bitrate string
+	MaxRate string
+	BufSize string
+}
+
+func (rc RateControl) mode() RateControlMode {
+	if rc.Mode == "" {
+		return RateControlCappedCRF
+	}
+	return rc.Mode
+}
+
+func (rc RateControl) maxRate() string {
+	if s := strings.TrimSpace(rc.MaxRate); s != "" {
+		return s
+	}
+	return strings.TrimSpace(rc.Bitrate)
+}
+
+func (rc RateControl) bufSize() string {
+	if s := strings.TrimSpace(rc.BufSize); s != "" {
+		return s
+	}
+	return rc.maxRate()
+}
+
+func (rc RateControl) validate() error {
+	switch rc.mode() {
+	case RateControlCappedCRF:
+		return nil
+	case RateControlCBR, RateControlTwoPass:
+		if strings.TrimSpace(rc.Bitrate) == "" {
+			return fmt.Errorf("rate control %s requires a video bitrate", rc.mode())
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown rate control mode %q", rc.Mode)
+	}
+}
+
+// PeakBitrate is the highest per-segment video bitrate the mode allows. A
+// VBV-constrained encoder can exceed maxrate by at most one buffer over
+// any window, so a segment peaks at maxrate + bufsize/segment. It returns
+// zero when the mode is uncapped.
+func (rc RateControl) PeakBitrate(segmentSeconds int) (uint32, error) {
+	if rc.maxRate() == "" {
+		return 0, nil
+	}
+	if segmentSeconds <= 0 {
+		segmentSeconds = 1
+	}
+	maxRate, err := hls.ParseBitrate(rc.maxRate())
+	if err != nil {
+		return 0, err
+	}
+	buf, err := hls.ParseBitrate(rc.bufSize())
+	if err != nil {
+		return 0, err
+	}
+	return maxRate + buf/uint32(segmentSeconds), nil
+}
+
+// vbvArgs is the -maxrate/-bufsize pair shared by the capped modes.
+func (rc RateControl) vbvArgs() []string {
+	if rc.maxRate() == "" {
+		return nil
+	}
+	return []string{"-maxrate", rc.maxRate(), "-bufsize", rc.bufSize()}
+}
+
+// rateArgs covers encoders that honour -crf together with -maxrate as
+// capped CRF (libx264, libsvtav1). Passing -b:v alongside -crf would make
+// x264 ignore the CRF and fall back to ABR.
+func rateArgs(rc RateControl, crf int) []string {
+	switch rc.mode() {
+	case RateControlCBR:
+		b := strings.TrimSpace(rc.Bitrate)
+		return []string{"-b:v", b, "-minrate", b, "-maxrate", b, "-bufsize", rc.bufSize()}
+	case RateControlTwoPass:
+		return append([]string{"-b:v", strings.TrimSpace(rc.Bitrate)}, rc.vbvArgs()...)
+	default:
+		return append([]string{"-crf", strconv.Itoa(crf)}, rc.vbvArgs()...)
+	}
+}
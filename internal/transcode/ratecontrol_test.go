@@ -0,0 +1,196 @@
+package transcode
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestEncoders_RateControlArgs(t *testing.T) {
+	capped := RateControl{Mode: RateControlCappedCRF, Bitrate: "50k"}
+	cbr := RateControl{Mode: RateControlCBR, Bitrate: "50k"}
+
+	cases := []struct {
+		name string
+		enc  Encoder
+		rc   RateControl
+		want []string
+	}{
+		{
+			name: "h264 capped",
+			enc:  H264Encoder{},
+			rc:   capped,
+			want: []string{"-c:v", "libx264", "-preset", "medium", "-crf", "28", "-maxrate", "50k", "-bufsize", "50k", "-sc_threshold", "0", "-profile:v", "baseline", "-level", "3.0", "-pix_fmt", "yuv420p"},
+		},
+		{
+			name: "h264 cbr",
+			enc:  H264Encoder{},
+			rc:   cbr,
+			want: []string{"-c:v", "libx264", "-preset", "medium", "-b:v", "50k", "-minrate", "50k", "-maxrate", "50k", "-bufsize", "50k", "-sc_threshold", "0", "-profile:v", "baseline", "-level", "3.0", "-pix_fmt", "yuv420p"},
+		},
+		{
+			name: "vp9 capped",
+			enc:  VP9Encoder{},
+			rc:   capped,
+			want: []string{"-c:v", "libvpx-vp9", "-deadline", "good", "-cpu-used", "4", "-row-mt", "1", "-crf", "36", "-b:v", "50k", "-pix_fmt", "yuv420p"},
+		},
+		{
+			name: "av1 cbr",
+			enc:  AV1Encoder{},
+			rc:   cbr,
+			want: []string{"-c:v", "libsvtav1", "-preset", "8", "-b:v", "50k", "-minrate", "50k", "-maxrate", "50k", "-bufsize", "50k", "-svtav1-params", "rc=2:pred-struct=1", "-pix_fmt", "yuv420p"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.enc.VideoArgs(EncodeOptions{RateControl: tc.rc})
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("unexpected args:\n got %v\nwant %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFFmpeg_TranscodeHLS_TwoPassRunsAnalysisFirst(t *testing.T) {
+	f := NewFFmpeg("ffmpeg", zerolog.Nop())
+
+	var calls [][]string
+	f.Exec = ExecFunc(func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		_ = ctx
+		_ = name
+		calls = append(calls, append([]string(nil), args...))
+		return nil, nil, nil
+	})
+
+	outDir := t.TempDir()
+	_, err := f.TranscodeHLS(context.Background(), HLSRequest{
+		InputURL:     "library.mp4",
+		OutputDir:    outDir,
+		VideoBitrate: "100k",
+		RateControl:  RateControlTwoPass,
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 ffmpeg runs, got %d", len(calls))
+	}
+	assertHasArgPair(t, calls[0], "-pass", "1")
+	assertHasArgPair(t, calls[0], "-f", "null")
+	assertHasArgPair(t, calls[1], "-pass", "2")
+	assertHasArgPair(t, calls[1], "-b:v", "100k")
+	assertNoArg(t, calls[1], "-crf")
+
+	// The pass log stays out of the served output and is cleaned up.
+	passLog := argAfter(calls[1], "-passlogfile")
+	if strings.HasPrefix(passLog, outDir) {
+		t.Fatalf("expected pass log outside %s, got %s", outDir, passLog)
+	}
+	if _, err := os.Stat(filepath.Dir(passLog)); !os.IsNotExist(err) {
+		t.Fatalf("expected pass log dir to be removed, got %v", err)
+	}
+}
+
+func argAfter(args []string, k string) string {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == k {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+func TestTranscodeMultiQualityHLSFromYouTube_TwoPassSpoolsSource(t *testing.T) {
+	f := NewFFmpeg("ffmpeg", zerolog.Nop())
+	outDir := t.TempDir()
+
+	var mu sync.Mutex
+	downloads := 0
+	var inputs []string
+	f.Exec = executorFunc(func(ctx context.Context, cmd Command) ([]byte, []byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if cmd.Name == "yt-dlp" {
+			downloads++
+			_, err := cmd.Stdout.Write([]byte("video"))
+			return nil, nil, err
+		}
+		inputs = append(inputs, argAfter(cmd.Args, "-i"))
+		return nil, nil, nil
+	})
+
+	ladder, err := WithRateControl(DefaultVariantConfigs(), RateControlTwoPass)
+	if err != nil {
+		t.Fatalf("WithRateControl: %v", err)
+	}
+	res, err := TranscodeMultiQualityHLSFromYouTube(context.Background(), zerolog.Nop(), f, YtDLPInput{URL: "https://youtube.example/watch?v=abc"}, outDir, ladder)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if len(res.Errors) != 0 {
+		t.Fatalf("unexpected tier errors %v", res.Errors)
+	}
+	if downloads != 1 {
+		t.Fatalf("expected one download shared by every tier, got %d", downloads)
+	}
+	// Two passes per tier, all from the spooled file.
+	if len(inputs) != 2*len(ladder) {
+		t.Fatalf("expected %d ffmpeg runs, got %d", 2*len(ladder), len(inputs))
+	}
+	for _, in := range inputs {
+		if in != filepath.Join(outDir, sourceFile) {
+			t.Fatalf("expected ffmpeg to read the spooled source, got %q", in)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outDir, sourceFile)); !os.IsNotExist(err) {
+		t.Fatalf("expected the spooled source to be removed, got %v", err)
+	}
+}
+
+func TestWithRateControl_CopiesLadder(t *testing.T) {
+	ladder := DefaultVariantConfigs()
+	cbr, err := WithRateControl(ladder, RateControlCBR)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if cbr[0].RateControl != RateControlCBR || ladder[0].RateControl != RateControlCappedCRF {
+		t.Fatalf("expected a modified copy, got %q and original %q", cbr[0].RateControl, ladder[0].RateControl)
+	}
+	if _, err := WithRateControl(ladder, "vbr"); err == nil {
+		t.Fatalf("expected an unknown mode to be rejected")
+	}
+}
+
+func TestFFmpeg_TranscodeHLSFromYtDlpPipe_RejectsTwoPass(t *testing.T) {
+	f := NewFFmpeg("ffmpeg", zerolog.Nop())
+	_, err := f.TranscodeHLSFromYtDlpPipe(context.Background(), "https://youtube.example/watch?v=abc", "yt-dlp", HLSRequest{
+		OutputDir:    t.TempDir(),
+		VideoBitrate: "100k",
+		RateControl:  RateControlTwoPass,
+	})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestVariantConfig_BandwidthReflectsPeak(t *testing.T) {
+	v := VariantConfig{Tier: Quality64, VideoBitrate: "50k", RateControl: RateControlCappedCRF}
+	peak, average, err := v.Bandwidth()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	// 50k cap + 50k buffer over a 4s segment + 32k audio.
+	if peak != 94500 {
+		t.Fatalf("expected peak 94500, got %d", peak)
+	}
+	if average != 82000 {
+		t.Fatalf("expected average 82000, got %d", average)
+	}
+}
@@ -0,0 +1,301 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// maxStderrProcesses bounds how many processes one StderrLog keeps, since
+// a live relay restarts its producers for as long as the broadcast runs.
+const maxStderrProcesses = 32
+
+// StderrLog keeps the stderr of the processes run for one stream, for
+// debugging once the process is gone: the last limit bytes of each, redacted
+// line by line as it is written. A nil StderrLog captures nothing.
+type StderrLog struct {
+	limit  int
+	logger zerolog.Logger
+
+	mu    sync.Mutex
+	procs []*processLog
+	// droppedProcs counts the processes evicted to stay under
+	// maxStderrProcesses.
+	droppedProcs int
+}
+
+// processLog is one process's share of a StderrLog, guarded by its mutex.
+type processLog struct {
+	log     *StderrLog
+	command string
+	secrets []string
+	started time.Time
+	ended   time.Time
+	exit    int
+	done    bool
+
+	// buf holds the retained tail; dropped counts what fell out of it.
+	buf     []byte
+	dropped int
+	// line is the unterminated line being written, progress the last line
+	// ended by a carriage return.
+	line     []byte
+	progress []byte
+}
+
+// secretFlags are the options whose value is a credential, or a path to
+// one, and so is scrubbed from the command line and the output.
+var secretFlags = map[string]bool{
+	"--cookies":  true,
+	"--proxy":    true,
+	"--username": true,
+	"--password": true,
+	"-cookies":   true,
+	"-headers":   true,
+}
+
+// urlRe matches URLs in command lines and tool output. Signed source URLs
+// carry their credentials in the path as well as the query, so only the
+// scheme and host are kept.
+var urlRe = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s'"<>]+`)
+
+// NewStderrLog keeps up to limit bytes per process; a limit of zero or less
+// disables capture. Each process's stderr tail is also logged to logger
+// when it exits.
+func NewStderrLog(limit int, logger zerolog.Logger) *StderrLog {
+	if limit <= 0 {
+		return nil
+	}
+	return &StderrLog{limit: limit, logger: logger}
+}
+
+// CaptureStderr wraps an Executor so every process it runs writes its
+// stderr to l as well.
+func CaptureStderr(next Executor, l *StderrLog) Executor {
+	if l == nil {
+		return next
+	}
+	return stderrCapture{next: next, log: l}
+}
+
+// WithStderrLog returns a copy of f whose processes, the yt-dlp producers
+// included, write their stderr to l. YtDLP's own calls are captured through
+// ContextWithStderrLog instead.
+func (f *FFmpeg) WithStderrLog(l *StderrLog) *FFmpeg {
+	if l == nil {
+		return f
+	}
+	captured := *f
+	captured.Exec = CaptureStderr(f.Exec, l)
+	return &captured
+}
+
+type stderrLogKey struct{}
+
+// ContextWithStderrLog returns ctx carrying l, so the yt-dlp metadata and
+// subtitle calls made under it write their stderr to l. An extraction
+// shared with other streams is captured in the log of the one that started
+// it.
+func ContextWithStderrLog(ctx context.Context, l *StderrLog) context.Context {
+	if l == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, stderrLogKey{}, l)
+}
+
+func stderrLogFrom(ctx context.Context) *StderrLog {
+	l, _ := ctx.Value(stderrLogKey{}).(*StderrLog)
+	return l
+}
+
+type stderrCapture struct {
+	next Executor
+	log  *StderrLog
+}
+
+func (c stderrCapture) Run(ctx context.Context, cmd Command) ([]byte, []byte, error) {
+	p := c.log.start(cmd)
+	if cmd.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, p)
+	} else {
+		cmd.Stderr = p
+	}
+	stdout, stderr, err := c.next.Run(ctx, cmd)
+	c.log.finish(ctx, p, filepath.Base(cmd.Name), err)
+	return stdout, stderr, err
+}
+
+func (l *StderrLog) start(cmd Command) *processLog {
+	command, secrets := redactCommand(cmd)
+	p := &processLog{log: l, command: command, secrets: secrets, started: time.Now()}
+	l.mu.Lock()
+	l.procs = append(l.procs, p)
+	if len(l.procs) > maxStderrProcesses {
+		l.evict()
+	}
+	l.mu.Unlock()
+	return p
+}
+
+// evict drops the oldest finished process, or the oldest of all when every
+// one is still running. Callers hold mu.
+func (l *StderrLog) evict() {
+	i := 0
+	for j, p := range l.procs {
+		if p.done {
+			i = j
+			break
+		}
+	}
+	l.procs = append(l.procs[:i], l.procs[i+1:]...)
+	l.droppedProcs++
+}
+
+func (l *StderrLog) finish(ctx context.Context, p *processLog, name string, err error) {
+	l.mu.Lock()
+	p.flush()
+	p.done = true
+	p.ended = time.Now()
+	p.exit = exitCode(err)
+	tail := stderrTail(p.buf)
+	l.mu.Unlock()
+
+	// A canceled process was stopped on purpose; its exit says nothing.
+	ev := l.logger.Debug()
+	if err != nil && ctx.Err() == nil {
+		ev = l.logger.Warn()
+	}
+	ev.Str("process", name).Int("exit", p.exit).Str("stderr", tail).Msg("process exited")
+}
+
+// Write takes stderr as the process prints it. Lines are redacted whole,
+// so a secret is never split across writes; carriage-return progress
+// updates overwrite each other, as on a terminal, rather than crowding real
+// messages out of the buffer.
+func (p *processLog) Write(b []byte) (int, error) {
+	n := len(b)
+	p.log.mu.Lock()
+	defer p.log.mu.Unlock()
+	for len(b) > 0 {
+		i := bytes.IndexAny(b, "\r\n")
+		if i < 0 {
+			p.line = append(p.line, b...)
+			if len(p.line) >= p.log.limit {
+				p.emit(p.line)
+				p.line = p.line[:0]
+			}
+			break
+		}
+		p.line = append(p.line, b[:i]...)
+		if b[i] == '\r' {
+			p.progress = append(p.progress[:0], p.line...)
+		} else {
+			if len(p.line) == 0 {
+				p.emit(p.progress)
+			} else {
+				p.emit(p.line)
+			}
+			p.progress = p.progress[:0]
+		}
+		p.line = p.line[:0]
+		b = b[i+1:]
+	}
+	return n, nil
+}
+
+// flush keeps whatever was left unterminated when the process exited.
+func (p *processLog) flush() {
+	if len(p.line) > 0 {
+		p.emit(p.line)
+	} else {
+		p.emit(p.progress)
+	}
+	p.line, p.progress = nil, nil
+}
+
+func (p *processLog) emit(line []byte) {
+	if len(line) == 0 {
+		return
+	}
+	p.buf = append(p.buf, redactOutput(line, p.secrets)...)
+	p.buf = append(p.buf, '\n')
+	if over := len(p.buf) - p.log.limit; over > 0 {
+		p.buf = append(p.buf[:0], p.buf[over:]...)
+		p.dropped += over
+	}
+}
+
+// WriteTo writes every kept process's command line, outcome and stderr
+// tail, oldest process first.
+func (l *StderrLog) WriteTo(w io.Writer) (int64, error) {
+	var out bytes.Buffer
+	now := time.Now()
+	l.mu.Lock()
+	if l.droppedProcs > 0 {
+		fmt.Fprintf(&out, "[%d earlier processes dropped]\n", l.droppedProcs)
+	}
+	for _, p := range l.procs {
+		status := fmt.Sprintf("running, %s", now.Sub(p.started).Round(time.Millisecond))
+		if p.done {
+			status = fmt.Sprintf("exit %d, %s", p.exit, p.ended.Sub(p.started).Round(time.Millisecond))
+		}
+		fmt.Fprintf(&out, "=== %s (%s)\n", p.command, status)
+		if p.dropped > 0 {
+			fmt.Fprintf(&out, "[%d bytes dropped]\n", p.dropped)
+		}
+		out.Write(p.buf)
+		if pending := p.line; len(pending) > 0 || len(p.progress) > 0 {
+			if len(pending) == 0 {
+				pending = p.progress
+			}
+			out.Write(redactOutput(pending, p.secrets))
+			out.WriteByte('\n')
+		}
+	}
+	l.mu.Unlock()
+	return out.WriteTo(w)
+}
+
+// redactCommand renders a command line for display, and returns the
+// credentials found on it so they can be scrubbed from the output too.
+func redactCommand(cmd Command) (string, []string) {
+	parts := []string{cmd.Name}
+	var secrets []string
+	for i := 0; i < len(cmd.Args); i++ {
+		arg := cmd.Args[i]
+		parts = append(parts, redactURLs(arg))
+		if secretFlags[arg] && i+1 < len(cmd.Args) {
+			i++
+			secrets = append(secrets, cmd.Args[i])
+			parts = append(parts, "[redacted]")
+		}
+	}
+	return strings.Join(parts, " "), secrets
+}
+
+func redactOutput(line []byte, secrets []string) []byte {
+	return []byte(redactURLs(string(redact(line, secrets))))
+}
+
+func redactURLs(s string) string {
+	return urlRe.ReplaceAllStringFunc(s, func(raw string) string {
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			return "[redacted url]"
+		}
+		short := u.Scheme + "://" + u.Host
+		if short == raw || short+"/" == raw {
+			return raw
+		}
+		return short + "/[redacted]"
+	})
+}
@@ -0,0 +1,151 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func stderrLogText(t *testing.T, l *StderrLog) string {
+	t.Helper()
+	var b strings.Builder
+	if _, err := l.WriteTo(&b); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+	return b.String()
+}
+
+func TestStderrLog_CapturesEveryProcessRedacted(t *testing.T) {
+	var logs bytes.Buffer
+	l := NewStderrLog(4096, zerolog.New(&logs))
+	ex := CaptureStderr(executorFunc(func(ctx context.Context, cmd Command) ([]byte, []byte, error) {
+		if cmd.Name == "yt-dlp" {
+			cmd.Stderr.Write([]byte("ERROR: cannot load cookies from /secret/cookies.txt\n"))
+			cmd.Stderr.Write([]byte("ERROR: fetching https://rr1.googlevideo.com/videoplayback/sig/abc?expire=1&signature=xyz failed\n"))
+			return nil, nil, errors.New("exit status 1")
+		}
+		cmd.Stderr.Write([]byte("frame=1\rframe=2\rframe=3\rpipe:0: End of file\n"))
+		return nil, nil, nil
+	}), l)
+
+	ctx := context.Background()
+	ex.Run(ctx, Command{Name: "yt-dlp", Args: []string{"--cookies", "/secret/cookies.txt", "--proxy", "http://user:pw@proxy:8080", "https://www.youtube.com/watch?v=abc"}})
+	ex.Run(ctx, Command{Name: "ffmpeg", Args: []string{"-i", "pipe:0"}})
+
+	got := stderrLogText(t, l)
+	for _, secret := range []string{"/secret/cookies.txt", "user:pw", "signature=xyz", "/sig/abc", "watch?v=abc"} {
+		if strings.Contains(got, secret) || strings.Contains(logs.String(), secret) {
+			t.Fatalf("expected %q to be redacted; got %q and logs %q", secret, got, logs.String())
+		}
+	}
+	for _, want := range []string{
+		"=== yt-dlp --cookies [redacted] --proxy [redacted] https://www.youtube.com/[redacted] (exit -1,",
+		"cannot load cookies from [redacted]\n",
+		"fetching https://rr1.googlevideo.com/[redacted] failed\n",
+		"=== ffmpeg -i pipe:0 (exit 0,",
+		"pipe:0: End of file\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected %q in log; got %q", want, got)
+		}
+	}
+	if strings.Contains(got, "frame=") {
+		t.Fatalf("expected progress updates to be overwritten; got %q", got)
+	}
+	if !strings.Contains(logs.String(), `"level":"warn"`) || !strings.Contains(logs.String(), `"process":"yt-dlp"`) {
+		t.Fatalf("expected the failed process to be logged; got %q", logs.String())
+	}
+}
+
+func TestStderrLog_KeepsTailPerProcess(t *testing.T) {
+	l := NewStderrLog(32, zerolog.Nop())
+	ex := CaptureStderr(ExecFunc(func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		return nil, []byte(strings.Repeat("noise line\n", 10) + "the actual error\n"), nil
+	}), l)
+	ex.Run(context.Background(), Command{Name: "ffmpeg"})
+
+	got := stderrLogText(t, l)
+	if !strings.HasSuffix(got, "the actual error\n") {
+		t.Fatalf("expected the last line kept; got %q", got)
+	}
+	if !strings.Contains(got, "bytes dropped]") {
+		t.Fatalf("expected dropped bytes to be reported; got %q", got)
+	}
+	if body := got[strings.Index(got, "]\n")+2:]; len(body) > 32 {
+		t.Fatalf("expected at most 32 bytes kept; got %d", len(body))
+	}
+}
+
+func TestStderrLog_ShowsRunningProcess(t *testing.T) {
+	l := NewStderrLog(1024, zerolog.Nop())
+	release := make(chan struct{})
+	running := make(chan struct{})
+	ex := CaptureStderr(executorFunc(func(ctx context.Context, cmd Command) ([]byte, []byte, error) {
+		cmd.Stderr.Write([]byte("Input #0, mpegts, from 'pipe:0':\nframe=10\r"))
+		close(running)
+		<-release
+		return nil, nil, nil
+	}), l)
+	go ex.Run(context.Background(), Command{Name: "ffmpeg"})
+	<-running
+
+	got := stderrLogText(t, l)
+	close(release)
+	if !strings.Contains(got, "(running,") || !strings.HasSuffix(got, "Input #0, mpegts, from 'pipe:0':\nframe=10\n") {
+		t.Fatalf("expected the running process with its latest progress; got %q", got)
+	}
+}
+
+func TestStderrLog_KeepsRecentProcesses(t *testing.T) {
+	l := NewStderrLog(1024, zerolog.Nop())
+	ex := CaptureStderr(ExecFunc(func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		return nil, []byte("restarting\n"), nil
+	}), l)
+	for i := 0; i < maxStderrProcesses+3; i++ {
+		ex.Run(context.Background(), Command{Name: "yt-dlp", Args: []string{strconv.Itoa(i)}})
+	}
+
+	got := stderrLogText(t, l)
+	if n := strings.Count(got, "=== "); n != maxStderrProcesses {
+		t.Fatalf("expected %d processes kept, got %d", maxStderrProcesses, n)
+	}
+	if !strings.HasPrefix(got, "[3 earlier processes dropped]\n=== yt-dlp 3 (") {
+		t.Fatalf("expected the oldest processes dropped; got %q", got[:80])
+	}
+}
+
+func TestYtDLP_CapturesStderrFromContext(t *testing.T) {
+	l := NewStderrLog(1024, zerolog.Nop())
+	y := NewYtDLP("yt-dlp", zerolog.Nop(), false)
+	y.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		return nil, []byte("ERROR: [youtube] abc: Video unavailable\n"), errors.New("exit status 1")
+	}
+
+	ctx := ContextWithStderrLog(context.Background(), l)
+	if _, err := y.Execute(ctx, "https://www.youtube.com/watch?v=abc"); !errors.Is(err, ErrVideoUnavailable) {
+		t.Fatalf("expected the error to be classified as before, got %v", err)
+	}
+	if _, err := y.DownloadSubtitles(ctx, "https://www.youtube.com/watch?v=abc", "", []string{"en"}, t.TempDir()); err == nil {
+		t.Fatalf("expected the subtitle download to fail")
+	}
+
+	got := stderrLogText(t, l)
+	if n := strings.Count(got, "Video unavailable\n"); n != 2 {
+		t.Fatalf("expected both calls captured; got %q", got)
+	}
+}
+
+func TestNewStderrLog_DisabledIsNil(t *testing.T) {
+	if l := NewStderrLog(0, zerolog.Nop()); l != nil {
+		t.Fatalf("expected a zero limit to disable capture")
+	}
+	f := NewFFmpeg("ffmpeg", zerolog.Nop())
+	if f.WithStderrLog(nil) != f {
+		t.Fatalf("expected no copy without a log")
+	}
+}
@@ -0,0 +1,109 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sixfeetup/blobtube/internal/hls"
+)
+
+// subtitleLangRe accepts the language tags yt-dlp uses, e.g. "en",
+// "pt-BR", "zh-Hans". It also keeps tags safe to use as directory names.
+var subtitleLangRe = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// MaxSubtitleLanguages is how many languages one stream may ask for. Each
+// one is fetched and segmented before the stream starts.
+const MaxSubtitleLanguages = 4
+
+// ValidSubtitleLanguage reports whether lang is a usable language tag.
+func ValidSubtitleLanguage(lang string) bool {
+	return subtitleLangRe.MatchString(lang)
+}
+
+// Subtitle is a WebVTT track downloaded by yt-dlp.
+type Subtitle struct {
+	Language string
+	Path     string
+}
+
+// DownloadSubtitles fetches subtitles in langs into dir, preferring manual
+// subtitles over automatic captions where a language has both. Languages
+// the video has no subtitles for are silently missing from the result.
+func (y *YtDLP) DownloadSubtitles(ctx context.Context, videoURL, proxy string, langs []string, dir string) ([]Subtitle, error) {
+	if len(langs) == 0 {
+		return nil, nil
+	}
+	for _, l := range langs {
+		if !ValidSubtitleLanguage(l) {
+			return nil, fmt.Errorf("invalid subtitle language %q", l)
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create subtitle dir: %w", err)
+	}
+
+	args := []string{
+		"--no-warnings",
+		"--no-playlist",
+		"--skip-download",
+		"--write-subs",
+		"--write-auto-subs",
+		"--sub-langs", strings.Join(langs, ","),
+		"--sub-format", "vtt/best",
+		"--convert-subs", "vtt",
+		"--output", filepath.Join(dir, "subs.%(ext)s"),
+	}
+	args = append(args, y.Options.args(proxy)...)
+	args = append(args, videoURL)
+
+	if _, stderr, err := y.run(ctx, args...); err != nil {
+		return nil, classifyYtDLPErr(redact(stderr, y.Options.secrets(proxy)), err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "subs.*.vtt"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	var subs []Subtitle
+	for _, f := range files {
+		lang := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(f), "subs."), ".vtt")
+		if ValidSubtitleLanguage(lang) {
+			subs = append(subs, Subtitle{Language: lang, Path: f})
+		}
+	}
+	return subs, nil
+}
+
+// WriteSubtitleRenditions segments each subtitle into dir/<lang>/ on the
+// same boundaries as the video tiers, and returns the languages written.
+// effectiveSeconds bounds the tracks to the transcoded length; zero keeps
+// them whole.
+func WriteSubtitleRenditions(subs []Subtitle, dir string, effectiveSeconds int) ([]string, error) {
+	total := time.Duration(effectiveSeconds) * time.Second
+	var langs []string
+	for _, s := range subs {
+		b, err := os.ReadFile(s.Path)
+		if err != nil {
+			return langs, err
+		}
+		cues, err := hls.ParseWebVTT(b)
+		if err != nil {
+			return langs, fmt.Errorf("subtitles %s: %w", s.Language, err)
+		}
+		if len(cues) == 0 {
+			continue
+		}
+		if err := hls.WriteSegmentedWebVTT(filepath.Join(dir, s.Language), cues, variantSegmentSeconds, total); err != nil {
+			return langs, fmt.Errorf("subtitles %s: %w", s.Language, err)
+		}
+		langs = append(langs, s.Language)
+	}
+	return langs, nil
+}
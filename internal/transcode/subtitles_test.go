@@ -0,0 +1,68 @@
+package transcode
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestYtDLP_DownloadSubtitles_ReturnsWrittenLanguages(t *testing.T) {
+	dir := t.TempDir()
+	y := NewYtDLP("yt-dlp", zerolog.Nop(), false)
+	var gotArgs []string
+	y.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		gotArgs = args
+		for _, lang := range []string{"en", "pt-BR"} {
+			if err := os.WriteFile(filepath.Join(dir, "subs."+lang+".vtt"), []byte("WEBVTT\n"), 0o644); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+		}
+		return nil, nil, nil
+	}
+
+	subs, err := y.DownloadSubtitles(context.Background(), "https://youtu.be/x", "", []string{"en", "pt-BR", "de"}, dir)
+	if err != nil {
+		t.Fatalf("DownloadSubtitles: %v", err)
+	}
+	if len(subs) != 2 || subs[0].Language != "en" || subs[1].Language != "pt-BR" {
+		t.Fatalf("unexpected subtitles: %+v", subs)
+	}
+	if !strings.Contains(strings.Join(gotArgs, " "), "--sub-langs en,pt-BR,de") {
+		t.Fatalf("expected requested languages in args, got %v", gotArgs)
+	}
+}
+
+func TestYtDLP_DownloadSubtitles_RejectsInvalidLanguage(t *testing.T) {
+	y := NewYtDLP("yt-dlp", zerolog.Nop(), false)
+	y.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		t.Fatalf("yt-dlp should not run")
+		return nil, nil, nil
+	}
+	if _, err := y.DownloadSubtitles(context.Background(), "https://youtu.be/x", "", []string{"../etc"}, t.TempDir()); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestWriteSubtitleRenditions_SegmentsEachLanguage(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "subs.en.vtt")
+	vtt := "WEBVTT\n\n00:00:01.000 --> 00:00:03.000\nhello\n\n00:00:07.000 --> 00:00:09.000\nworld\n"
+	if err := os.WriteFile(src, []byte(vtt), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	out := t.TempDir()
+
+	langs, err := WriteSubtitleRenditions([]Subtitle{{Language: "en", Path: src}}, out, 10)
+	if err != nil {
+		t.Fatalf("WriteSubtitleRenditions: %v", err)
+	}
+	if len(langs) != 1 || langs[0] != "en" {
+		t.Fatalf("unexpected languages: %v", langs)
+	}
+	if _, err := os.Stat(filepath.Join(out, "en", "index.m3u8")); err != nil {
+		t.Fatalf("expected index.m3u8: %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package transcode
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -12,39 +13,78 @@ import (
 	"time"
 
 	"github.com/rs/zerolog"
+
+	"github.com/sixfeetup/blobtube/internal/lru"
 )
 
 var (
-	ErrUnsupportedURL   = errors.New("unsupported url")
-	ErrVideoUnavailable = errors.New("video unavailable")
-	ErrRegionLocked     = errors.New("region locked")
+	ErrUnsupportedURL    = errors.New("unsupported url")
+	ErrVideoUnavailable  = errors.New("video unavailable")
+	ErrRegionLocked      = errors.New("region locked")
+	ErrAgeRestricted     = errors.New("age restricted")
+	ErrLiveNotSupported  = errors.New("live stream not supported")
+	ErrSourceRateLimited = errors.New("source rate limited")
+	ErrSourceTooLong     = errors.New("source too long")
+	ErrEncoderFailed     = errors.New("encoder failed")
+	ErrDiskFull          = errors.New("disk full")
 )
 
-type ExecFunc func(ctx context.Context, name string, args ...string) (stdout []byte, stderr []byte, err error)
-
 type YtDLP struct {
 	Path    string
 	Exec    ExecFunc
 	Logger  zerolog.Logger
 	DevMode bool
+	// Options apply to every call, including the download pipe built by
+	// Input.
+	Options YtDLPOptions
+
+	// cache is nil when metadata caching is off; flights collapses
+	// concurrent extractions of the same URL either way.
+	cache       *lru.Cache[string, cachedResult]
+	negativeTTL time.Duration
+	flights     flightGroup
 
-	mu    sync.Mutex
-	cache map[string]cacheEntry
+	// mu guards version and health; see ytdlp_runtime.go.
+	mu      sync.Mutex
+	version string
+	health  healthRing
 }
 
-type cacheEntry struct {
-	info    StreamInfo
-	expires time.Time
+// MetadataCacheConfig sizes the yt-dlp metadata cache. NegativeTTL is how
+// long a permanent failure (unavailable, unsupported, region locked, age
+// restricted) is remembered; zero disables negative caching.
+type MetadataCacheConfig struct {
+	Size        int
+	TTL         time.Duration
+	NegativeTTL time.Duration
+}
+
+// devMetadataCache is what DEV_MODE has always enabled (ADR-013).
+var devMetadataCache = MetadataCacheConfig{Size: 256, TTL: 5 * time.Minute}
+
+type cachedResult struct {
+	info StreamInfo
+	err  error
 }
 
 type StreamInfo struct {
 	VideoID    string
 	Title      string
+	Channel    string
 	Duration   int
 	Thumbnail  string
 	StreamURL  string
 	FormatID   string
 	FormatNote string
+
+	// IsLive and LiveStatus ("is_live", "is_upcoming", "post_live",
+	// "was_live", "not_live") come straight from yt-dlp.
+	IsLive         bool
+	LiveStatus     string
+	AgeLimit       int
+	FilesizeApprox int64
+	// IsPlaylist is set when the URL resolved to more than one video.
+	IsPlaylist bool
 }
 
 func NewYtDLP(path string, logger zerolog.Logger, devMode bool) *YtDLP {
@@ -52,24 +92,70 @@ func NewYtDLP(path string, logger zerolog.Logger, devMode bool) *YtDLP {
 		Path:    path,
 		Logger:  logger,
 		DevMode: devMode,
-		cache:   map[string]cacheEntry{},
 	}
 	y.Exec = y.defaultExec
+	if devMode {
+		y.EnableCache(devMetadataCache)
+	}
 	return y
 }
 
+// EnableCache turns on the metadata cache, replacing any existing one. It
+// must be called before the first Execute.
+func (y *YtDLP) EnableCache(cfg MetadataCacheConfig) {
+	if cfg.Size <= 0 || cfg.TTL <= 0 {
+		y.cache = nil
+		return
+	}
+	y.cache = lru.New[string, cachedResult](cfg.Size, cfg.TTL)
+	y.negativeTTL = cfg.NegativeTTL
+}
+
+// CacheStats reports metadata cache counters; ok is false when caching is
+// off.
+func (y *YtDLP) CacheStats() (stats lru.Stats, ok bool) {
+	if y.cache == nil {
+		return lru.Stats{}, false
+	}
+	return y.cache.Stats(), true
+}
+
 func (y *YtDLP) Execute(ctx context.Context, videoURL string) (StreamInfo, error) {
+	return y.ExecuteVia(ctx, videoURL, y.Options.Proxy)
+}
+
+// ExecuteVia extracts metadata through proxy, which callers should get
+// from Options.ResolveProxy.
+func (y *YtDLP) ExecuteVia(ctx context.Context, videoURL, proxy string) (StreamInfo, error) {
 	if videoURL == "" {
 		return StreamInfo{}, fmt.Errorf("video url is required")
 	}
 
-	if y.DevMode {
-		if info, ok := y.getCached(videoURL); ok {
-			y.Logger.Debug().Str("url", videoURL).Msg("yt-dlp cache hit")
-			return info, nil
+	// What a video looks like depends on where it's fetched from.
+	key := videoURL
+	if proxy != y.Options.Proxy {
+		key = proxy + " " + videoURL
+	}
+
+	if y.cache != nil {
+		if r, ok := y.cache.Get(key); ok {
+			y.Logger.Debug().Str("url", videoURL).Bool("negative", r.err != nil).Msg("yt-dlp cache hit")
+			return r.info, r.err
 		}
 	}
 
+	info, err, shared := y.flights.do(ctx, key, func(ctx context.Context) (StreamInfo, error) {
+		info, err := y.extract(ctx, key, videoURL, proxy)
+		y.recordExtraction(err)
+		return info, err
+	})
+	if shared {
+		y.Logger.Debug().Str("url", videoURL).Msg("yt-dlp extraction shared")
+	}
+	return info, err
+}
+
+func (y *YtDLP) extract(ctx context.Context, key, videoURL, proxy string) (StreamInfo, error) {
 	args := []string{
 		"-j",
 		"--no-warnings",
@@ -78,31 +164,54 @@ func (y *YtDLP) Execute(ctx context.Context, videoURL string) (StreamInfo, error
 		// Prefer a muxed format (audio+video) when possible.
 		"-f",
 		"best[acodec!=none][vcodec!=none]/best",
-		videoURL,
 	}
+	args = append(args, y.Options.args(proxy)...)
+	args = append(args, videoURL)
 
-	stdout, stderr, err := y.Exec(ctx, y.Path, args...)
+	stdout, stderr, err := y.run(ctx, args...)
 	if err != nil {
-		return StreamInfo{}, classifyYtDLPErr(stderr, err)
+		err = classifyYtDLPErr(redact(stderr, y.Options.secrets(proxy)), err)
+		if y.cache != nil && y.negativeTTL > 0 && permanentFailure(err) {
+			y.cache.SetWithTTL(key, cachedResult{err: err}, y.negativeTTL)
+		}
+		return StreamInfo{}, err
 	}
 
+	// -j prints one JSON document per video, so a playlist that got past
+	// --no-playlist shows up as several documents.
+	dec := json.NewDecoder(bytes.NewReader(stdout))
 	var payload ytDLPJSON
-	if uerr := json.Unmarshal(stdout, &payload); uerr != nil {
+	if uerr := dec.Decode(&payload); uerr != nil {
 		return StreamInfo{}, fmt.Errorf("parse yt-dlp json: %w", uerr)
 	}
+	if payload.Type == "playlist" || dec.More() {
+		return StreamInfo{VideoID: payload.ID, Title: payload.Title, IsPlaylist: true}, nil
+	}
 
 	info, err := extractStreamInfo(payload)
 	if err != nil {
 		return StreamInfo{}, err
 	}
 
-	if y.DevMode {
-		y.setCached(videoURL, info, 5*time.Minute)
+	if y.cache != nil {
+		y.cache.Set(key, cachedResult{info: info})
 	}
 
 	return info, nil
 }
 
+// Input returns the download pipe for videoURL with the same options as
+// ExecuteVia(ctx, videoURL, proxy).
+func (y *YtDLP) Input(videoURL, proxy string) YtDLPInput {
+	return YtDLPInput{Path: y.Path, URL: videoURL, Args: y.Options.args(proxy), secrets: y.Options.secrets(proxy)}
+}
+
+// run calls yt-dlp through Exec, capturing its stderr in the StderrLog
+// carried by ctx, if any.
+func (y *YtDLP) run(ctx context.Context, args ...string) ([]byte, []byte, error) {
+	return CaptureStderr(y.Exec, stderrLogFrom(ctx)).Run(ctx, Command{Name: y.Path, Args: args})
+}
+
 func (y *YtDLP) defaultExec(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
 	cmd := exec.CommandContext(ctx, name, args...)
 	stdout, err := cmd.Output()
@@ -117,37 +226,34 @@ func (y *YtDLP) defaultExec(ctx context.Context, name string, args ...string) ([
 	return stdout, nil, err
 }
 
-func (y *YtDLP) getCached(videoURL string) (StreamInfo, bool) {
-	y.mu.Lock()
-	defer y.mu.Unlock()
-
-	e, ok := y.cache[videoURL]
-	if !ok {
-		return StreamInfo{}, false
-	}
-	if time.Now().After(e.expires) {
-		delete(y.cache, videoURL)
-		return StreamInfo{}, false
+// permanentFailure reports whether retrying the same URL soon would fail
+// the same way. Rate limiting and timeouts are transient.
+func permanentFailure(err error) bool {
+	for _, target := range []error{ErrVideoUnavailable, ErrUnsupportedURL, ErrRegionLocked, ErrAgeRestricted} {
+		if errors.Is(err, target) {
+			return true
+		}
 	}
-	return e.info, true
-}
-
-func (y *YtDLP) setCached(videoURL string, info StreamInfo, ttl time.Duration) {
-	y.mu.Lock()
-	defer y.mu.Unlock()
-
-	y.cache[videoURL] = cacheEntry{info: info, expires: time.Now().Add(ttl)}
+	return false
 }
 
 type ytDLPJSON struct {
+	Type      string        `json:"_type"`
 	ID        string        `json:"id"`
 	Title     string        `json:"title"`
+	Channel   string        `json:"channel"`
+	Uploader  string        `json:"uploader"`
 	Duration  int           `json:"duration"`
 	Thumbnail string        `json:"thumbnail"`
 	URL       string        `json:"url"`
 	FormatID  string        `json:"format_id"`
 	Format    string        `json:"format"`
 	Formats   []ytDLPFormat `json:"formats"`
+
+	IsLive         bool   `json:"is_live"`
+	LiveStatus     string `json:"live_status"`
+	AgeLimit       int    `json:"age_limit"`
+	FilesizeApprox int64  `json:"filesize_approx"`
 }
 
 type ytDLPFormat struct {
@@ -176,14 +282,25 @@ func extractStreamInfo(p ytDLPJSON) (StreamInfo, error) {
 		return StreamInfo{}, errors.New("yt-dlp did not provide a stream url")
 	}
 
+	// Not every extractor knows the channel; the uploader is close enough.
+	channel := p.Channel
+	if channel == "" {
+		channel = p.Uploader
+	}
+
 	return StreamInfo{
-		VideoID:    p.ID,
-		Title:      p.Title,
-		Duration:   p.Duration,
-		Thumbnail:  p.Thumbnail,
-		StreamURL:  streamURL,
-		FormatID:   formatID,
-		FormatNote: formatNote,
+		VideoID:        p.ID,
+		Title:          p.Title,
+		Channel:        channel,
+		Duration:       p.Duration,
+		Thumbnail:      p.Thumbnail,
+		StreamURL:      streamURL,
+		FormatID:       formatID,
+		FormatNote:     formatNote,
+		IsLive:         p.IsLive,
+		LiveStatus:     p.LiveStatus,
+		AgeLimit:       p.AgeLimit,
+		FilesizeApprox: p.FilesizeApprox,
 	}, nil
 }
 
@@ -209,18 +326,34 @@ func chooseBestMuxed(formats []ytDLPFormat) ytDLPFormat {
 }
 
 func classifyYtDLPErr(stderr []byte, err error) error {
+	if reason := ytdlpReason(stderr); reason != nil {
+		return fmt.Errorf("%w: %s", reason, strings.TrimSpace(string(stderr)))
+	}
+	if len(stderr) > 0 {
+		return fmt.Errorf("yt-dlp failed: %s", strings.TrimSpace(string(stderr)))
+	}
+	return err
+}
+
+// ytdlpReason matches yt-dlp's error output to a sentinel, or returns nil
+// for failures we have no specific answer for.
+func ytdlpReason(stderr []byte) error {
 	msg := strings.ToLower(string(stderr))
 	switch {
 	case strings.Contains(msg, "unsupported url"):
-		return fmt.Errorf("%w: %s", ErrUnsupportedURL, strings.TrimSpace(string(stderr)))
-	case strings.Contains(msg, "video unavailable") || strings.Contains(msg, "private video") || strings.Contains(msg, "this video is private"):
-		return fmt.Errorf("%w: %s", ErrVideoUnavailable, strings.TrimSpace(string(stderr)))
-	case strings.Contains(msg, "not available in your country") || strings.Contains(msg, "geo"):
-		return fmt.Errorf("%w: %s", ErrRegionLocked, strings.TrimSpace(string(stderr)))
+		return ErrUnsupportedURL
+	case strings.Contains(msg, "confirm your age") || strings.Contains(msg, "age-restricted") || strings.Contains(msg, "inappropriate for some users"):
+		return ErrAgeRestricted
+	case strings.Contains(msg, "live event will begin") || strings.Contains(msg, "premieres in") || strings.Contains(msg, "is a live stream"):
+		return ErrLiveNotSupported
+	case strings.Contains(msg, "http error 429") || strings.Contains(msg, "too many requests") || strings.Contains(msg, "not a bot"):
+		return ErrSourceRateLimited
+	case strings.Contains(msg, "video unavailable") || strings.Contains(msg, "private video") || strings.Contains(msg, "this video is private") ||
+		strings.Contains(msg, "members-only") || strings.Contains(msg, "has been removed") || strings.Contains(msg, "account associated with this video has been terminated"):
+		return ErrVideoUnavailable
+	case strings.Contains(msg, "available in your country") || strings.Contains(msg, "geo"):
+		return ErrRegionLocked
 	default:
-		if len(stderr) > 0 {
-			return fmt.Errorf("yt-dlp failed: %s", strings.TrimSpace(string(stderr)))
-		}
-		return err
+		return nil
 	}
 }
@@ -0,0 +1,129 @@
+package transcode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+var ErrProxyNotAllowed = errors.New("proxy not allowed")
+
+// YtDLPOptions are operator settings applied to every yt-dlp call, the
+// metadata lookup and the download pipe alike, so both see the video the
+// same way. The cookies file and proxy userinfo are credentials: they are
+// never logged, and are scrubbed from yt-dlp's stderr.
+type YtDLPOptions struct {
+	CookiesFile string
+	// Proxy is the default HTTP or SOCKS proxy. A request may pick another
+	// one only from ProxyAllowlist.
+	Proxy            string
+	ProxyAllowlist   []string
+	GeoBypassCountry string
+	// ExtractorArgs are passed as-is, one --extractor-args each, e.g.
+	// "youtube:player_client=web".
+	ExtractorArgs []string
+}
+
+// ResolveProxy returns the proxy to use for a request asking for
+// requested; an empty request gets the default.
+func (o YtDLPOptions) ResolveProxy(requested string) (string, error) {
+	requested = strings.TrimSpace(requested)
+	if requested == "" || requested == o.Proxy {
+		return o.Proxy, nil
+	}
+	for _, p := range o.ProxyAllowlist {
+		if p == requested {
+			return requested, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrProxyNotAllowed, redactProxy(requested))
+}
+
+// args returns the yt-dlp options for a call going through proxy.
+func (o YtDLPOptions) args(proxy string) []string {
+	var args []string
+	if o.CookiesFile != "" {
+		args = append(args, "--cookies", o.CookiesFile)
+	}
+	if proxy != "" {
+		args = append(args, "--proxy", proxy)
+	}
+	if o.GeoBypassCountry != "" {
+		args = append(args, "--geo-bypass-country", o.GeoBypassCountry)
+	}
+	for _, a := range o.ExtractorArgs {
+		args = append(args, "--extractor-args", a)
+	}
+	return args
+}
+
+// secrets are the strings scrubbed from the output of a yt-dlp call going
+// through proxy before it reaches errors and logs: the cookies path, and
+// both the configured and the request's proxy with their credentials.
+// Longer strings come first, so a whole proxy URL is scrubbed before its
+// userinfo.
+func (o YtDLPOptions) secrets(proxy string) []string {
+	var s []string
+	if o.CookiesFile != "" {
+		s = append(s, o.CookiesFile)
+	}
+	for _, p := range []string{o.Proxy, proxy} {
+		if p == "" {
+			continue
+		}
+		s = append(s, p)
+		u, err := url.Parse(p)
+		if err != nil || u.User == nil {
+			continue
+		}
+		s = append(s, u.User.String())
+		if pw, ok := u.User.Password(); ok {
+			s = append(s, pw)
+		}
+	}
+	sort.Slice(s, func(i, j int) bool { return len(s[i]) > len(s[j]) })
+	return s
+}
+
+// MarshalZerologObject logs the options without their credentials.
+func (o YtDLPOptions) MarshalZerologObject(e *zerolog.Event) {
+	e.Bool("cookies", o.CookiesFile != "")
+	if o.Proxy != "" {
+		e.Str("proxy", redactProxy(o.Proxy))
+	}
+	if len(o.ProxyAllowlist) > 0 {
+		e.Int("proxy_allowlist", len(o.ProxyAllowlist))
+	}
+	if o.GeoBypassCountry != "" {
+		e.Str("geo_bypass_country", o.GeoBypassCountry)
+	}
+	if len(o.ExtractorArgs) > 0 {
+		e.Strs("extractor_args", o.ExtractorArgs)
+	}
+}
+
+// redactProxy drops credentials from a proxy URL.
+func redactProxy(proxy string) string {
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		return "(invalid proxy)"
+	}
+	if u.User != nil {
+		u.User = url.User("redacted")
+	}
+	return u.String()
+}
+
+func redact(b []byte, secrets []string) []byte {
+	for _, s := range secrets {
+		if s != "" {
+			b = bytes.ReplaceAll(b, []byte(s), []byte("[redacted]"))
+		}
+	}
+	return b
+}
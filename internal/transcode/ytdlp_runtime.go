@@ -0,0 +1,327 @@
+package transcode
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// YouTube changes often enough that a stale yt-dlp is the usual cause of
+// extraction failures (TODO.md, lesson 5). This file keeps track of the
+// installed version, can update it, and scores recent extractions.
+
+// DefaultYtDLPReleaseURL is the binary the Dockerfile installs.
+const DefaultYtDLPReleaseURL = "https://github.com/yt-dlp/yt-dlp/releases/latest/download/yt-dlp"
+
+// releaseSumsFile is the checksum list yt-dlp publishes beside every
+// release binary.
+const releaseSumsFile = "SHA2-256SUMS"
+
+const (
+	UpdateOff     = "off"
+	UpdateSelf    = "self"    // yt-dlp -U, needs a writable install
+	UpdateRelease = "release" // download the release binary to a managed path
+)
+
+// Extractor health statuses.
+const (
+	ExtractorOK       = "ok"
+	ExtractorDegraded = "degraded"
+	ExtractorBroken   = "broken"
+)
+
+const (
+	// healthWindow is how many recent extractions the score covers, and
+	// healthMinSamples how many it needs before calling anything broken.
+	healthWindow     = 20
+	healthMinSamples = 5
+)
+
+// ExtractorHealth scores recent extractions. Failures explained by the
+// video itself (unavailable, region locked, live...) and rate limiting
+// don't count: what's left is what a broken extractor looks like.
+type ExtractorHealth struct {
+	Status  string  `json:"status"`
+	Score   float64 `json:"score"`
+	Samples int     `json:"samples"`
+	// LastError describes the last failure in fixed terms. It is served on
+	// the public /health, so yt-dlp's own output stays in the logs.
+	LastError   string    `json:"last_error,omitempty"`
+	LastFailure time.Time `json:"last_failure,omitempty"`
+}
+
+type healthRing struct {
+	outcomes    [healthWindow]bool
+	next, n     int
+	lastError   string
+	lastFailure time.Time
+}
+
+func (h *healthRing) record(ok bool) {
+	h.outcomes[h.next] = ok
+	h.next = (h.next + 1) % healthWindow
+	if h.n < healthWindow {
+		h.n++
+	}
+}
+
+func (h *healthRing) snapshot() ExtractorHealth {
+	out := ExtractorHealth{Status: ExtractorOK, Score: 1, Samples: h.n, LastError: h.lastError, LastFailure: h.lastFailure}
+	if h.n == 0 {
+		return out
+	}
+	okCount := 0
+	for i := 0; i < h.n; i++ {
+		if h.outcomes[i] {
+			okCount++
+		}
+	}
+	out.Score = float64(okCount) / float64(h.n)
+	if h.n >= healthMinSamples {
+		switch {
+		case out.Score <= 0.2:
+			out.Status = ExtractorBroken
+		case out.Score < 0.7:
+			out.Status = ExtractorDegraded
+		}
+	}
+	return out
+}
+
+// recordExtraction feeds an extraction outcome into the health score.
+func (y *YtDLP) recordExtraction(err error) {
+	if err != nil && !extractorFailure(err) {
+		return
+	}
+	y.mu.Lock()
+	defer y.mu.Unlock()
+	y.health.record(err == nil)
+	if err != nil {
+		y.health.lastError = healthError(err)
+		y.health.lastFailure = time.Now().UTC()
+	}
+}
+
+// healthError describes an extractor failure without any of the process's
+// output, which can carry video URLs, proxy hosts and the like.
+func healthError(err error) string {
+	var execErr *exec.Error
+	if errors.As(err, &execErr) {
+		return "yt-dlp could not be started"
+	}
+	return "extraction failed"
+}
+
+// extractorFailure reports whether err may point at yt-dlp itself rather
+// than at the video or the caller.
+func extractorFailure(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	for _, target := range []error{ErrLiveNotSupported, ErrSourceRateLimited} {
+		if errors.Is(err, target) {
+			return false
+		}
+	}
+	return !permanentFailure(err)
+}
+
+// Health returns the extractor health score.
+func (y *YtDLP) Health() ExtractorHealth {
+	y.mu.Lock()
+	defer y.mu.Unlock()
+	return y.health.snapshot()
+}
+
+// Version returns the version last reported by RefreshVersion.
+func (y *YtDLP) Version() string {
+	y.mu.Lock()
+	defer y.mu.Unlock()
+	return y.version
+}
+
+// RefreshVersion asks the binary for its version.
+func (y *YtDLP) RefreshVersion(ctx context.Context) (string, error) {
+	v, err := ytdlpVersion(ctx, y.Exec, y.Path)
+	if err != nil {
+		return "", err
+	}
+	y.mu.Lock()
+	y.version = v
+	y.mu.Unlock()
+	return v, nil
+}
+
+func ytdlpVersion(ctx context.Context, run ExecFunc, path string) (string, error) {
+	stdout, stderr, err := run(ctx, path, "--version")
+	if err != nil {
+		return "", fmt.Errorf("yt-dlp --version: %s: %w", strings.TrimSpace(string(stderr)), err)
+	}
+	v := strings.TrimSpace(string(stdout))
+	if v == "" {
+		return "", fmt.Errorf("yt-dlp --version: empty output")
+	}
+	return v, nil
+}
+
+// Updater keeps yt-dlp current according to Mode.
+type Updater struct {
+	YtDLP *YtDLP
+	Mode  string
+	// ReleaseURL and Client are used by UpdateRelease, which writes the
+	// binary to YtDLP.Path; point Path at a writable managed location.
+	ReleaseURL string
+	Client     *http.Client
+}
+
+// Update runs one update and refreshes the recorded version.
+func (u *Updater) Update(ctx context.Context) error {
+	y := u.YtDLP
+	switch u.Mode {
+	case UpdateSelf:
+		if _, stderr, err := y.Exec(ctx, y.Path, "-U"); err != nil {
+			return fmt.Errorf("yt-dlp -U: %s: %w", strings.TrimSpace(string(stderr)), err)
+		}
+	case UpdateRelease:
+		if err := u.downloadRelease(ctx); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	_, err := y.RefreshVersion(ctx)
+	return err
+}
+
+// Run updates every interval until ctx is done.
+func (u *Updater) Run(ctx context.Context, interval time.Duration) {
+	if u.Mode == "" || u.Mode == UpdateOff || interval <= 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			before := u.YtDLP.Version()
+			if err := u.Update(ctx); err != nil {
+				u.YtDLP.Logger.Warn().Err(err).Str("mode", u.Mode).Msg("yt-dlp update failed")
+				continue
+			}
+			if after := u.YtDLP.Version(); after != before {
+				u.YtDLP.Logger.Info().Str("from", before).Str("to", after).Msg("yt-dlp updated")
+			}
+		}
+	}
+}
+
+// downloadRelease fetches the release binary next to the managed path,
+// checks it against the release's SHA2-256SUMS and that it runs, and
+// renames it into place so running extractions keep the old inode.
+func (u *Updater) downloadRelease(ctx context.Context) error {
+	dest := u.YtDLP.Path
+	if !filepath.IsAbs(dest) && !strings.ContainsRune(dest, filepath.Separator) {
+		return fmt.Errorf("release updates need a managed yt-dlp path, not %q", dest)
+	}
+	binURL := u.ReleaseURL
+	if binURL == "" {
+		binURL = DefaultYtDLPReleaseURL
+	}
+
+	want, err := u.releaseSum(ctx, binURL)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return fmt.Errorf("create yt-dlp dir: %w", err)
+	}
+	body, err := u.get(ctx, binURL)
+	if err != nil {
+		return fmt.Errorf("download yt-dlp: %w", err)
+	}
+	defer body.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".yt-dlp-")
+	if err != nil {
+		return fmt.Errorf("create yt-dlp temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("download yt-dlp: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("downloaded yt-dlp has sha256 %s, release lists %s", got, want)
+	}
+	if err := os.Chmod(tmp.Name(), 0o755); err != nil {
+		return err
+	}
+	if _, err := ytdlpVersion(ctx, u.YtDLP.Exec, tmp.Name()); err != nil {
+		return fmt.Errorf("downloaded yt-dlp does not run: %w", err)
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+// releaseSum looks up the binary's SHA-256 in the SHA2-256SUMS published
+// beside it.
+func (u *Updater) releaseSum(ctx context.Context, binURL string) (string, error) {
+	i := strings.LastIndex(binURL, "/")
+	if i < 0 || i == len(binURL)-1 {
+		return "", fmt.Errorf("release url %q does not name a file", binURL)
+	}
+	name := binURL[i+1:]
+
+	body, err := u.get(ctx, binURL[:i+1]+releaseSumsFile)
+	if err != nil {
+		return "", fmt.Errorf("download %s: %w", releaseSumsFile, err)
+	}
+	defer body.Close()
+	b, err := io.ReadAll(io.LimitReader(body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("download %s: %w", releaseSumsFile, err)
+	}
+	// Lines are "<hex>  <name>", with a "*" before the name in binary mode.
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name && len(fields[0]) == 2*sha256.Size {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("%s lists no checksum for %s", releaseSumsFile, name)
+}
+
+func (u *Updater) get(ctx context.Context, url string) (io.ReadCloser, error) {
+	client := u.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return resp.Body, nil
+}
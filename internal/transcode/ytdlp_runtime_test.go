@@ -0,0 +1,147 @@
+package transcode
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestYtDLP_Health_IgnoresVideoSpecificFailures(t *testing.T) {
+	y := NewYtDLP("yt-dlp", zerolog.Nop(), false)
+	for i := 0; i < 10; i++ {
+		y.recordExtraction(fmt.Errorf("yt-dlp failed: %w", ErrVideoUnavailable))
+		y.recordExtraction(ErrSourceRateLimited)
+	}
+	if h := y.Health(); h.Samples != 0 || h.Status != ExtractorOK {
+		t.Fatalf("expected no samples, got %+v", h)
+	}
+}
+
+func TestYtDLP_Health_ReportsBrokenExtractor(t *testing.T) {
+	y := NewYtDLP("yt-dlp", zerolog.Nop(), false)
+	y.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		return nil, []byte("ERROR: [youtube] abc: Unable to extract player response"), errors.New("exit status 1")
+	}
+	for i := 0; i < healthMinSamples; i++ {
+		_, _ = y.Execute(context.Background(), fmt.Sprintf("https://youtube.example/watch?v=%d", i))
+	}
+
+	h := y.Health()
+	if h.Status != ExtractorBroken || h.Score != 0 {
+		t.Fatalf("expected broken extractor, got %+v", h)
+	}
+	if h.LastError != "extraction failed" || h.LastFailure.IsZero() {
+		t.Fatalf("expected last failure details without yt-dlp's output, got %+v", h)
+	}
+
+	y.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		return []byte(`{"id":"abc","url":"https://u"}`), nil, nil
+	}
+	for i := 0; i < healthWindow; i++ {
+		_, _ = y.Execute(context.Background(), fmt.Sprintf("https://youtube.example/watch?v=ok%d", i))
+	}
+	if h := y.Health(); h.Status != ExtractorOK || h.Score != 1 {
+		t.Fatalf("expected recovery once failures leave the window, got %+v", h)
+	}
+}
+
+func TestYtDLP_RefreshVersion(t *testing.T) {
+	y := NewYtDLP("yt-dlp", zerolog.Nop(), false)
+	y.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		if len(args) != 1 || args[0] != "--version" {
+			t.Fatalf("unexpected args %v", args)
+		}
+		return []byte("2026.02.21\n"), nil, nil
+	}
+	v, err := y.RefreshVersion(context.Background())
+	if err != nil || v != "2026.02.21" || y.Version() != v {
+		t.Fatalf("expected version 2026.02.21, got %q, %v", v, err)
+	}
+}
+
+// releaseServer serves bin as /download/yt-dlp beside a SHA2-256SUMS
+// listing sum for it.
+func releaseServer(t *testing.T, bin []byte, sum string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/download/yt-dlp":
+			_, _ = w.Write(bin)
+		case "/download/SHA2-256SUMS":
+			_, _ = fmt.Fprintf(w, "%s  yt-dlp.exe\n%s  yt-dlp\n", strings.Repeat("0", 64), sum)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestUpdater_ReleaseReplacesManagedBinary(t *testing.T) {
+	bin := []byte("#!/bin/sh\n")
+	sum := sha256.Sum256(bin)
+	srv := releaseServer(t, bin, hex.EncodeToString(sum[:]))
+
+	dest := filepath.Join(t.TempDir(), "bin", "yt-dlp")
+	y := NewYtDLP(dest, zerolog.Nop(), false)
+	var ran []string
+	y.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		ran = append(ran, name)
+		return []byte("2026.10.01"), nil, nil
+	}
+
+	u := &Updater{YtDLP: y, Mode: UpdateRelease, ReleaseURL: srv.URL + "/download/yt-dlp"}
+	if err := u.Update(context.Background()); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	info, err := os.Stat(dest)
+	if err != nil {
+		t.Fatalf("expected managed binary: %v", err)
+	}
+	if info.Mode().Perm()&0o100 == 0 {
+		t.Fatalf("expected managed binary to be executable")
+	}
+	// The download is checked before it replaces the managed binary.
+	if len(ran) != 2 || ran[0] == dest || ran[1] != dest {
+		t.Fatalf("expected temp file then managed binary to be run, got %v", ran)
+	}
+	if y.Version() != "2026.10.01" {
+		t.Fatalf("expected refreshed version, got %q", y.Version())
+	}
+}
+
+func TestUpdater_ReleaseRejectsChecksumMismatch(t *testing.T) {
+	srv := releaseServer(t, []byte("#!/bin/sh\n"), strings.Repeat("ab", 32))
+
+	dest := filepath.Join(t.TempDir(), "yt-dlp")
+	if err := os.WriteFile(dest, []byte("old"), 0o755); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	y := NewYtDLP(dest, zerolog.Nop(), false)
+	y.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		return []byte("2026.10.01"), nil, nil
+	}
+
+	u := &Updater{YtDLP: y, Mode: UpdateRelease, ReleaseURL: srv.URL + "/download/yt-dlp"}
+	if err := u.Update(context.Background()); err == nil || !strings.Contains(err.Error(), "sha256") {
+		t.Fatalf("expected a checksum error, got %v", err)
+	}
+	if b, _ := os.ReadFile(dest); string(b) != "old" {
+		t.Fatalf("expected managed binary to be left alone, got %q", b)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(dest))
+	if len(entries) != 1 {
+		t.Fatalf("expected the download to be cleaned up, found %d files", len(entries))
+	}
+}
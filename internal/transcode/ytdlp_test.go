@@ -3,6 +3,10 @@ package transcode
 import (
 	"context"
 	"errors"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -18,6 +22,7 @@ func TestYtDLP_Execute_ExtractsMuxedURL(t *testing.T) {
 		return []byte(`{
   "id": "abc",
   "title": "hello",
+  "uploader": "someone",
   "duration": 12,
   "thumbnail": "https://example/thumb.jpg",
   "url": "https://fallback/url",
@@ -40,6 +45,9 @@ func TestYtDLP_Execute_ExtractsMuxedURL(t *testing.T) {
 	if info.FormatID != "mux2" {
 		t.Fatalf("expected mux2 format id, got %q", info.FormatID)
 	}
+	if info.Channel != "someone" {
+		t.Fatalf("expected channel to fall back to uploader, got %q", info.Channel)
+	}
 }
 
 func TestYtDLP_Execute_CachesInDevMode(t *testing.T) {
@@ -79,14 +87,177 @@ func TestYtDLP_Execute_ClassifiesErrors(t *testing.T) {
 	}
 }
 
-func TestYtDLP_CacheExpires(t *testing.T) {
-	y := NewYtDLP("yt-dlp", zerolog.Nop(), true)
-	y.setCached("u", StreamInfo{StreamURL: "x"}, 10*time.Millisecond)
-	if _, ok := y.getCached("u"); !ok {
-		t.Fatalf("expected cache hit")
+func TestYtDLP_Execute_NegativeCaching(t *testing.T) {
+	calls := 0
+	y := NewYtDLP("yt-dlp", zerolog.Nop(), false)
+	y.EnableCache(MetadataCacheConfig{Size: 8, TTL: time.Minute, NegativeTTL: time.Minute})
+	y.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		calls++
+		if strings.Contains(args[len(args)-1], "busy") {
+			return nil, []byte("ERROR: HTTP Error 429: Too Many Requests"), errors.New("exit status 1")
+		}
+		return nil, []byte("ERROR: [youtube] x: Video unavailable"), errors.New("exit status 1")
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := y.Execute(context.Background(), "https://gone"); !errors.Is(err, ErrVideoUnavailable) {
+			t.Fatalf("expected ErrVideoUnavailable, got %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected unavailable video to be cached, got %d calls", calls)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, _ = y.Execute(context.Background(), "https://busy")
+	}
+	if calls != 3 {
+		t.Fatalf("expected rate limiting not to be cached, got %d calls", calls)
+	}
+
+	stats, ok := y.CacheStats()
+	if !ok || stats.Hits != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestYtDLP_Execute_CollapsesConcurrentCalls(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	y := NewYtDLP("yt-dlp", zerolog.Nop(), false)
+	y.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		calls.Add(1)
+		<-release
+		return []byte(`{"id":"abc","url":"https://u"}`), nil, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := y.Execute(context.Background(), "https://same"); err != nil {
+				t.Errorf("expected nil error, got %v", err)
+			}
+		}()
+	}
+	// Let every caller join the flight before yt-dlp "returns".
+	waitForWaiters(&y.flights, "https://same", 5)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected 1 exec call, got %d", n)
+	}
+}
+
+func TestYtDLP_Execute_FlightOutlivesFirstCaller(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	y := NewYtDLP("yt-dlp", zerolog.Nop(), false)
+	y.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		close(started)
+		select {
+		case <-release:
+			return []byte(`{"id":"abc","url":"https://u"}`), nil, nil
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := y.Execute(firstCtx, "https://same")
+		firstErr <- err
+	}()
+	<-started
+
+	second := make(chan error, 1)
+	go func() {
+		_, err := y.Execute(context.Background(), "https://same")
+		second <- err
+	}()
+	waitForWaiters(&y.flights, "https://same", 2)
+
+	cancelFirst()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the canceled caller to stop waiting, got %v", err)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Fatalf("expected the other caller to get the result, got %v", err)
+	}
+}
+
+// waitForWaiters blocks until n callers are waiting on key's flight.
+func waitForWaiters(g *flightGroup, key string, n int) {
+	for {
+		g.mu.Lock()
+		c := g.calls[key]
+		joined := c != nil && c.waiters >= n
+		g.mu.Unlock()
+		if joined {
+			return
+		}
+		runtime.Gosched()
+	}
+}
+
+func TestClassifyYtDLPErr_FailureModes(t *testing.T) {
+	cases := []struct {
+		stderr string
+		want   error
+	}{
+		{"ERROR: [youtube] abc: Sign in to confirm your age. This video may be inappropriate for some users.", ErrAgeRestricted},
+		{"ERROR: [youtube] abc: This live event will begin in 3 hours.", ErrLiveNotSupported},
+		{"ERROR: [youtube] abc: Premieres in 2 days", ErrLiveNotSupported},
+		{"ERROR: unable to download video data: HTTP Error 429: Too Many Requests", ErrSourceRateLimited},
+		{"ERROR: [youtube] abc: Private video. Sign in if you've been granted access", ErrVideoUnavailable},
+		{"ERROR: [youtube] abc: This video is available to this channel's members-only", ErrVideoUnavailable},
+		{"ERROR: [youtube] abc: The uploader has not made this video available in your country", ErrRegionLocked},
+	}
+
+	for _, tc := range cases {
+		err := classifyYtDLPErr([]byte(tc.stderr), errors.New("exit status 1"))
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%q: expected %v, got %v", tc.stderr, tc.want, err)
+		}
+	}
+}
+
+func TestYtDLP_Execute_DetectsPlaylists(t *testing.T) {
+	cases := map[string]string{
+		"playlist type":  `{"_type": "playlist", "id": "PL1", "title": "mix"}`,
+		"many documents": `{"id": "a", "url": "https://a"}` + "\n" + `{"id": "b", "url": "https://b"}`,
+	}
+	for name, out := range cases {
+		t.Run(name, func(t *testing.T) {
+			y := NewYtDLP("yt-dlp", zerolog.Nop(), false)
+			y.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+				return []byte(out), nil, nil
+			}
+			info, err := y.Execute(context.Background(), "https://youtube.example/playlist?list=PL1")
+			if err != nil {
+				t.Fatalf("expected nil error, got %v", err)
+			}
+			if !info.IsPlaylist {
+				t.Fatalf("expected playlist to be detected")
+			}
+		})
+	}
+}
+
+func TestYtDLP_Execute_ParsesPolicyFields(t *testing.T) {
+	y := NewYtDLP("yt-dlp", zerolog.Nop(), false)
+	y.Exec = func(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+		return []byte(`{"id": "abc", "url": "https://u", "is_live": true, "live_status": "is_live", "age_limit": 18, "filesize_approx": 1234}`), nil, nil
+	}
+	info, err := y.Execute(context.Background(), "https://youtube.example/watch?v=abc")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
 	}
-	time.Sleep(20 * time.Millisecond)
-	if _, ok := y.getCached("u"); ok {
-		t.Fatalf("expected cache miss")
+	if !info.IsLive || info.LiveStatus != "is_live" || info.AgeLimit != 18 || info.FilesizeApprox != 1234 {
+		t.Fatalf("unexpected info: %+v", info)
 	}
 }